@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"scrypts/internal/backup"
+	"scrypts/internal/config"
+	"scrypts/internal/objstore"
+	"scrypts/internal/replica"
+	"time"
+)
+
+// runBackup implements `scrypts backup`, which takes an online snapshot and
+// can run while the server is up.
+func runBackup(args []string) int {
+	config.InitDatabase()
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", "archive to write (default: a new file in SCRYPTS_BACKUP_DIR)")
+	compress := fs.Bool("compress", config.BackupCompress, "gzip the archive")
+	encrypt := fs.Bool("encrypt", config.BackupKey != nil, "encrypt the archive with SCRYPTS_BACKUP_KEY")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: scrypts backup [-o file] [-compress=false] [-encrypt=false]")
+		return 2
+	}
+	opts := backup.Options{Compress: *compress}
+	if *encrypt {
+		if config.BackupKey == nil {
+			fmt.Fprintln(os.Stderr, "-encrypt needs SCRYPTS_BACKUP_KEY")
+			return 2
+		}
+		opts.Key = config.BackupKey
+	}
+
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open db:", err)
+		return 1
+	}
+	defer store.Close()
+
+	cfg := &backup.Config{Dir: config.BackupDir, Options: opts}
+	var info backup.Info
+	if *out == "" {
+		info, err = cfg.Run(store)
+	} else {
+		info = backup.Info{Name: filepath.Base(*out), Path: *out, Created: time.Now()}
+		info.Size, err = backup.Create(store, *out, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 1
+	}
+	fmt.Printf("wrote %s (%d bytes)\n", info.Path, info.Size)
+	return 0
+}
+
+// runRestore implements `scrypts restore`. The server must be stopped.
+// With S3 configured, -list shows the uploaded archives and -remote pulls
+// one of them (or the latest) before restoring it. -replica rebuilds the
+// database from the WAL replica instead, as of -at.
+func runRestore(args []string) int {
+	config.InitDatabase()
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := fs.Bool("force", false, "replace an existing database")
+	list := fs.Bool("list", false, "list the archives available to restore")
+	remote := fs.String("remote", "", "restore the named archive (or \"latest\") from S3")
+	fromReplica := fs.Bool("replica", false, "restore from the WAL replica")
+	at := fs.String("at", "", "with -replica, the time to restore to (RFC 3339, default now)")
+	err := fs.Parse(args)
+	wantArgs := 1
+	if *list || *remote != "" || *fromReplica {
+		wantArgs = 0
+	}
+	if err != nil || fs.NArg() != wantArgs || (*list && *remote != "") || (*fromReplica && *remote != "") ||
+		(*at != "" && !*fromReplica) {
+		fmt.Fprintln(os.Stderr, "usage: scrypts restore [-force] <archive>")
+		fmt.Fprintln(os.Stderr, "       scrypts restore [-force] -remote <name|latest>")
+		fmt.Fprintln(os.Stderr, "       scrypts restore [-force] -replica [-at 2006-01-02T15:04:05Z]")
+		fmt.Fprintln(os.Stderr, "       scrypts restore [-replica] -list")
+		return 2
+	}
+	if *fromReplica {
+		return restoreReplica(*list, *at, *force)
+	}
+	bucket := s3Bucket()
+
+	if *list {
+		if bucket == nil {
+			return listArchives(objstore.Dir{Root: config.BackupDir}, "")
+		}
+		return listArchives(bucket, config.BackupS3Prefix)
+	}
+	if config.DBDriver != config.DBSQLite {
+		fmt.Fprintln(os.Stderr, "restore only supports the sqlite driver")
+		return 1
+	}
+
+	archive := fs.Arg(0)
+	if *remote != "" {
+		if bucket == nil {
+			fmt.Fprintln(os.Stderr, "-remote needs SCRYPTS_S3_ENDPOINT")
+			return 2
+		}
+		key := config.BackupS3Prefix + *remote
+		if *remote == "latest" {
+			objs, err := backup.ListRemote(bucket, config.BackupS3Prefix)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "restore:", err)
+				return 1
+			}
+			if len(objs) == 0 {
+				fmt.Fprintln(os.Stderr, "restore: no remote archives")
+				return 1
+			}
+			key = objs[len(objs)-1].Key
+		}
+		if err := os.MkdirAll(config.BackupDir, 0o700); err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		archive = filepath.Join(config.BackupDir, path.Base(key)+".download")
+		os.Remove(archive)
+		fmt.Printf("downloading %s\n", key)
+		if err := backup.Fetch(bucket, key, archive); err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		defer os.Remove(archive)
+	}
+
+	if err := backup.Restore(archive, config.DBDSN, config.BackupKey, *force); err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	fmt.Printf("restored %s from %s\n", config.DBDSN, archive)
+	return 0
+}
+
+// restoreReplica rebuilds the database from the WAL replica as of at, or
+// lists the replica's generations.
+func restoreReplica(list bool, at string, force bool) int {
+	target, prefix := replicaTarget()
+	if target == nil {
+		fmt.Fprintln(os.Stderr, "-replica needs SCRYPTS_REPLICA")
+		return 2
+	}
+	if list {
+		gens, err := replica.Generations(target, prefix)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		for _, g := range gens {
+			fmt.Printf("%s  %s to %s  %d segments\n", g.Name(),
+				g.Start().Format(time.RFC3339), g.End().Format(time.RFC3339), g.Segments())
+		}
+		return 0
+	}
+
+	when := time.Now()
+	if at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-at must be an RFC 3339 time such as 2006-01-02T15:04:05Z")
+			return 2
+		}
+		when = t
+	}
+	if err := os.MkdirAll(config.BackupDir, 0o700); err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	// rebuild into a plain SQLite file, which Restore takes as an archive
+	rebuilt := filepath.Join(config.BackupDir, "replica-restore.db")
+	os.Remove(rebuilt)
+	defer os.Remove(rebuilt)
+	asOf, err := replica.Restore(target, prefix, config.BackupKey, when, rebuilt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	if err := backup.Restore(rebuilt, config.DBDSN, nil, force); err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	fmt.Printf("restored %s as of %s\n", config.DBDSN, asOf.Format(time.RFC3339Nano))
+	return 0
+}
+
+// listArchives prints the archives under prefix in bucket, oldest first.
+func listArchives(bucket objstore.Bucket, prefix string) int {
+	objs, err := backup.ListRemote(bucket, prefix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	for _, o := range objs {
+		fmt.Printf("%-45s %12d\n", path.Base(o.Key), o.Size)
+	}
+	return 0
+}
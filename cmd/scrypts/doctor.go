@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"scrypts/internal/config"
+	"scrypts/internal/doctor"
+	"scrypts/internal/storage"
+)
+
+// runDoctor implements `scrypts doctor`. It needs MASTER_KEY to check that
+// keys unwrap and notes decrypt.
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "fix what can be fixed safely")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: scrypts doctor [-repair]")
+		return 2
+	}
+	config.Init()
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open db:", err)
+		return 1
+	}
+	defer store.Close()
+	if m, ok := store.(storage.Migrator); ok {
+		if n, err := storage.PendingMigrations(m); err != nil || n > 0 {
+			fmt.Fprintln(os.Stderr, "doctor: the schema isn't up to date; run `scrypts migrate up` first")
+			return 1
+		}
+	}
+
+	problems, err := doctor.Check(store, config.MasterKey, *repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "doctor:", err)
+		return 1
+	}
+	left, badKeys := 0, 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if !p.Repaired {
+			left++
+		}
+		if p.Kind == doctor.BadKey {
+			badKeys++
+		}
+	}
+	if badKeys > 1 {
+		fmt.Println("several keys don't unwrap; is MASTER_KEY the one the server uses?")
+	}
+	switch {
+	case len(problems) == 0:
+		fmt.Println("no problems found")
+	case left == 0:
+		fmt.Printf("%d problems, all repaired\n", len(problems))
+	default:
+		fmt.Printf("%d problems, %d left\n", len(problems), left)
+		if !*repair {
+			fmt.Println("run with -repair to fix what can be fixed")
+		}
+		return 1
+	}
+	return 0
+}
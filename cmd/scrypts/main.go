@@ -1,65 +1,291 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"scrypts/internal/admin"
+	"scrypts/internal/api"
 	"scrypts/internal/auth"
+	"scrypts/internal/backup"
+	"scrypts/internal/blob"
 	"scrypts/internal/config"
 	"scrypts/internal/middleware"
 	"scrypts/internal/notes"
+	"scrypts/internal/objstore"
+	"scrypts/internal/passpolicy"
+	"scrypts/internal/pow"
+	"scrypts/internal/replica"
 	"scrypts/internal/storage"
 	"time"
 )
 
-func registerHandlers() {
+func registerHandlers(authSvc *auth.Service, adminH *admin.Handler, notesH *notes.Handler) {
 	// Create rate limiter: 10 requests per minute
 	rateLimiter := middleware.NewRateLimiter(10, time.Minute)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Scrypts is alive and kicking")
-	})
+	mux := http.NewServeMux()
+	if authSvc.ProofOfWork != nil {
+		challengeLimiter := middleware.NewRateLimiter(30, time.Minute)
+		mux.Handle("/pow/challenge", challengeLimiter.RateLimit(http.HandlerFunc(authSvc.ProofOfWork.ChallengeHandler)))
+	}
 
 	// Apply rate limiting to authentication endpoints
-	http.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
-		rateLimiter.RateLimit(http.HandlerFunc(auth.RegisterHandler)).ServeHTTP(w, r)
-	})
-	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		rateLimiter.RateLimit(http.HandlerFunc(auth.LoginHandler)).ServeHTTP(w, r)
+	mux.Handle("/register", rateLimiter.RateLimit(http.HandlerFunc(authSvc.RegisterHandler)))
+	mux.Handle("/login", rateLimiter.RateLimit(http.HandlerFunc(authSvc.LoginHandler)))
+
+	mux.Handle("/account/password", rateLimiter.RateLimit(http.HandlerFunc(authSvc.ChangePasswordHandler)))
+	mux.HandleFunc("/account/sessions", authSvc.ListSessionsHandler)
+	mux.HandleFunc("/account/sessions/", authSvc.RevokeSessionHandler)
+
+	mux.Handle("/admin/users", middleware.RequireAdmin(authSvc, http.HandlerFunc(adminH.ListUsersHandler)))
+	mux.Handle("/admin/users/", middleware.RequireAdmin(authSvc, http.HandlerFunc(adminH.UserHandler)))
+	mux.Handle("/admin/invites", middleware.RequireAdmin(authSvc, http.HandlerFunc(adminH.InvitesHandler)))
+	mux.Handle("/admin/invites/", middleware.RequireAdmin(authSvc, http.HandlerFunc(adminH.InviteHandler)))
+	mux.Handle("/admin/backup", middleware.RequireAdmin(authSvc, http.HandlerFunc(adminH.BackupHandler)))
+
+	mux.HandleFunc("POST /notes", notesH.CreateNoteHandler)
+	mux.HandleFunc("GET /notes", notesH.GetNotesHandler)
+	mux.HandleFunc("GET /notes/search", notesH.SearchHandler)
+	mux.HandleFunc("GET /notes/shared", notesH.SharedNotesHandler)
+	mux.HandleFunc("GET /notes/{id}", notesH.GetNoteHandler)
+	mux.HandleFunc("PUT /notes/{id}", notesH.UpdateNoteHandler)
+	mux.HandleFunc("PATCH /notes/{id}", notesH.PatchNoteHandler)
+	mux.HandleFunc("DELETE /notes/{id}", notesH.DeleteNoteHandler)
+	mux.HandleFunc("GET /notes/{id}/revisions", notesH.ListRevisionsHandler)
+	mux.HandleFunc("GET /notes/{id}/revisions/{rev}", notesH.GetRevisionHandler)
+	mux.HandleFunc("GET /notes/{id}/diff", notesH.DiffHandler)
+	mux.HandleFunc("POST /notes/{id}/restore", notesH.RestoreHandler)
+	mux.HandleFunc("GET /notes/{id}/shares", notesH.ListSharesHandler)
+	mux.HandleFunc("PUT /notes/{id}/shares/{user}", notesH.ShareNoteHandler)
+	mux.HandleFunc("DELETE /notes/{id}/shares/{user}", notesH.UnshareNoteHandler)
+	if config.LinkMaxDays > 0 {
+		// links with a password take a deliberately slow key derivation
+		createLinkLimiter := middleware.NewRateLimiter(10, time.Minute)
+		mux.Handle("POST /notes/{id}/links", createLinkLimiter.RateLimit(http.HandlerFunc(notesH.CreateLinkHandler)))
+		mux.HandleFunc("GET /notes/{id}/links", notesH.ListLinksHandler)
+		mux.HandleFunc("DELETE /notes/{id}/links/{lid}", notesH.DeleteLinkHandler)
+		// public: each request counts a view, so guessing is rate limited
+		linkLimiter := middleware.NewRateLimiter(30, time.Minute)
+		mux.Handle("GET /links/{id}", linkLimiter.RateLimit(http.HandlerFunc(notesH.ViewLinkHandler)))
+	}
+	if notesH.Blobs != nil {
+		mux.HandleFunc("POST /notes/{id}/attachments", notesH.UploadAttachmentHandler)
+		mux.HandleFunc("GET /notes/{id}/attachments", notesH.ListAttachmentsHandler)
+		mux.HandleFunc("GET /notes/{id}/attachments/{aid}", notesH.DownloadAttachmentHandler)
+		mux.HandleFunc("DELETE /notes/{id}/attachments/{aid}", notesH.DeleteAttachmentHandler)
+	}
+	mux.HandleFunc("GET /account/revisions", notesH.RevisionSettingsHandler)
+	mux.HandleFunc("PUT /account/revisions", notesH.RevisionSettingsHandler)
+	mux.HandleFunc("POST /notebooks", notesH.CreateNotebookHandler)
+	mux.HandleFunc("GET /notebooks", notesH.ListNotebooksHandler)
+	mux.HandleFunc("GET /notebooks/{id}", notesH.GetNotebookHandler)
+	mux.HandleFunc("PATCH /notebooks/{id}", notesH.UpdateNotebookHandler)
+	mux.HandleFunc("DELETE /notebooks/{id}", notesH.DeleteNotebookHandler)
+	mux.HandleFunc("POST /tags", notesH.CreateTagHandler)
+	mux.HandleFunc("GET /tags", notesH.ListTagsHandler)
+	mux.HandleFunc("PATCH /tags/{id}", notesH.RenameTagHandler)
+	mux.HandleFunc("DELETE /tags/{id}", notesH.DeleteTagHandler)
+	mux.HandleFunc("GET /trash", notesH.ListTrashHandler)
+	mux.HandleFunc("DELETE /trash", notesH.EmptyTrashHandler)
+	mux.HandleFunc("POST /trash/{id}/restore", notesH.RestoreTrashHandler)
+	mux.HandleFunc("DELETE /trash/{id}", notesH.DeleteTrashHandler)
+	if config.LegacyNoteRoutes {
+		// the id used to travel in the body
+		mux.HandleFunc("PUT /notes", notesH.LegacyUpdateNoteHandler)
+		mux.HandleFunc("DELETE /notes", notesH.LegacyDeleteNoteHandler)
+	}
+
+	apiHandler := api.Mux(mux)
+	http.Handle(api.Prefix+"/", http.StripPrefix(api.Prefix, apiHandler))
+	// the same routes without the prefix, as served before it existed
+	http.Handle("/", apiHandler)
+
+	http.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Scrypts is alive and kicking")
 	})
+}
 
-	http.HandleFunc("/notes", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			notes.CreateNoteHandler(w, r)
-		case http.MethodGet:
-			notes.GetNotesHandler(w, r)
-		case http.MethodPut:
-			notes.UpdateNoteHandler(w, r)
-		case http.MethodDelete:
-			notes.DeleteNoteHandler(w, r)
-		default:
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		}
+// openStore opens the database configured by config.InitDatabase.
+func openStore() (storage.Store, error) {
+	return storage.Open(config.DBDriver, config.DBDSN, storage.Options{
+		BusyTimeout: time.Duration(config.DBBusyTimeout) * time.Millisecond,
+		Synchronous: config.DBSynchronous,
+		ReadConns:   config.DBReadConns,
+		WriteConns:  config.DBWriteConns,
+		// the replicator checkpoints once it has shipped the WAL
+		NoAutoCheckpoint: config.ReplicaTarget != "",
 	})
 }
 
+// s3Bucket returns the configured S3-compatible bucket, or nil.
+func s3Bucket() *objstore.S3 {
+	if config.S3Endpoint == "" {
+		return nil
+	}
+	return &objstore.S3{
+		Endpoint:  config.S3Endpoint,
+		Region:    config.S3Region,
+		Bucket:    config.S3Bucket,
+		AccessKey: config.S3AccessKey,
+		SecretKey: config.S3SecretKey,
+		PathStyle: config.S3PathStyle,
+	}
+}
+
+// replicaTarget returns where the WAL is replicated to and the key prefix
+// there, or nil when replication is off.
+func replicaTarget() (objstore.Bucket, string) {
+	switch config.ReplicaTarget {
+	case "":
+		return nil, ""
+	case "s3":
+		return s3Bucket(), config.ReplicaPrefix
+	default:
+		return objstore.Dir{Root: config.ReplicaTarget}, ""
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "reindex":
+			os.Exit(runReindex(os.Args[2:]))
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
 	// initialize config (loads secrets)
 	config.Init()
 
 	//initialize db
 
-	if err := storage.Init("./scrypts.db"); err != nil {
+	store, err := openStore()
+	if err != nil {
 		fmt.Println("Failed to init db: ", err)
 		return
 	}
-	defer storage.Close()
+	defer store.Close()
+	if m, ok := store.(storage.Migrator); ok {
+		if err := prepareSchema(m); err != nil {
+			log.Fatalf("Database schema: %v", err)
+		}
+	}
+
+	for _, u := range config.AdminUsers {
+		if err := store.SetUserRole(u, storage.RoleAdmin); err != nil {
+			log.Printf("Could not grant admin role to %q: %v", u, err)
+		}
+	}
+
+	authSvc := auth.New(store)
+	authSvc.PasswordPolicy = &passpolicy.Policy{
+		MinLength: config.PasswordMinLength,
+		MinScore:  config.PasswordMinScore,
+	}
+	if config.PwnedPasswordsPath != "" {
+		breaches, err := passpolicy.LoadBreachList(config.PwnedPasswordsPath)
+		if err != nil {
+			log.Fatalf("Failed to load breached password list: %v", err)
+		}
+		authSvc.PasswordPolicy.Breaches = breaches
+	}
+
+	if config.PowDifficulty > 0 {
+		// sign challenges with a key derived from the JWT secret rather than the secret itself
+		mac := hmac.New(sha256.New, config.JwtSecret)
+		mac.Write([]byte("scrypts proof-of-work"))
+		authSvc.ProofOfWork = pow.NewIssuer(mac.Sum(nil), config.PowDifficulty, config.PowMaxDifficulty, 5*time.Minute, 20)
+	}
+
+	adminH := admin.NewHandler(store)
+	adminH.Backups = &backup.Config{
+		Dir:     config.BackupDir,
+		Options: backup.Options{Compress: config.BackupCompress, Key: config.BackupKey},
+	}
+
+	if config.BackupScheduled {
+		sched := &backup.Scheduler{
+			Store:     store,
+			Config:    adminH.Backups,
+			Prefix:    config.BackupS3Prefix,
+			At:        config.BackupAt,
+			Retention: backup.Retention{Daily: config.BackupKeepDaily, Weekly: config.BackupKeepWeekly},
+		}
+		if remote := s3Bucket(); remote != nil {
+			sched.Remote = remote
+			if config.BackupKey == nil {
+				log.Println("WARNING: uploading unencrypted backups; set SCRYPTS_BACKUP_KEY")
+			}
+		}
+		sched.Start()
+	}
+
+	if config.TrashDays > 0 {
+		purger := &notes.TrashPurger{
+			Store:     store,
+			Retention: time.Duration(config.TrashDays) * 24 * time.Hour,
+			Interval:  time.Hour,
+		}
+		purger.Start()
+	}
+
+	if config.LinkMaxDays > 0 {
+		linkPurger := &notes.LinkPurger{Store: store, Interval: time.Hour}
+		linkPurger.Start()
+	}
+
+	if target, prefix := replicaTarget(); target != nil {
+		if config.BackupKey == nil {
+			log.Println("WARNING: replicating unencrypted; set SCRYPTS_BACKUP_KEY")
+		}
+		db, ok := store.(*storage.SQLiteStore)
+		if !ok {
+			log.Fatalf("FATAL: SCRYPTS_REPLICA needs the sqlite driver, not %q", config.DBDriver)
+		}
+		r := &replica.Replicator{
+			DB:               db,
+			Target:           target,
+			Prefix:           prefix,
+			Key:              config.BackupKey,
+			Interval:         config.ReplicaSyncInterval,
+			SnapshotInterval: config.ReplicaSnapshotInterval,
+			Retention:        config.ReplicaRetention,
+		}
+		r.Start()
+	}
+
+	notesH := notes.NewHandler(store, authSvc)
+	if config.MaxAttachmentMB > 0 {
+		if config.AttachmentsStore == "s3" {
+			notesH.Blobs = blob.S3{Bucket: s3Bucket(), Prefix: config.AttachmentsS3Prefix}
+		} else {
+			notesH.Blobs = blob.Dir{Root: config.AttachmentsDir}
+		}
+		collector := &notes.BlobCollector{
+			Store:    store,
+			Blobs:    notesH.Blobs,
+			Interval: time.Hour,
+		}
+		collector.Start()
+	}
 
-	registerHandlers()
+	registerHandlers(authSvc, adminH, notesH)
 
 	certPath := os.Getenv("SCRYPTS_TLS_CERT")
 	keyPath := os.Getenv("SCRYPTS_TLS_KEY")
@@ -79,8 +305,8 @@ func main() {
 			CurvePreferences:         []tls.CurveID{tls.CurveP256, tls.X25519},
 		}
 
-		// Chain middleware: SecurityHeaders -> CORS -> DefaultServeMux
-		handler := middleware.SecurityHeaders(middleware.CORS(http.DefaultServeMux))
+		// Chain middleware: RequestID -> SecurityHeaders -> CORS -> DefaultServeMux
+		handler := api.RequestID(middleware.SecurityHeaders(middleware.CORS(http.DefaultServeMux)))
 
 		httpsSrv := &http.Server{
 			Addr:         ":" + httpsPort,
@@ -124,8 +350,8 @@ func main() {
 	// If no TLS cert/key provided we fall back to plain HTTP (blocking)
 	log.Printf("Starting server on http://localhost:%s", httpPort)
 	if certPath == "" || keyPath == "" {
-		// Chain middleware: SecurityHeaders -> CORS -> DefaultServeMux
-		handler := middleware.SecurityHeaders(middleware.CORS(http.DefaultServeMux))
+		// Chain middleware: RequestID -> SecurityHeaders -> CORS -> DefaultServeMux
+		handler := api.RequestID(middleware.SecurityHeaders(middleware.CORS(http.DefaultServeMux)))
 		if err := http.ListenAndServe(":"+httpPort, handler); err != nil {
 			fmt.Println("Failed to start HTTP server:", err)
 		}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: scrypts migrate up | down [n] | status"
+
+// runMigrate implements `scrypts migrate`. It only needs the database
+// settings, not the server's secrets.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	config.InitDatabase()
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open db:", err)
+		return 1
+	}
+	defer store.Close()
+	m, ok := store.(storage.Migrator)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "the %s store has no schema to migrate\n", config.DBDriver)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.MigrateUp()
+		for _, v := range done {
+			fmt.Printf("applied %04d\n", v)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate up:", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		done, err := m.MigrateDown(n)
+		for _, v := range done {
+			fmt.Printf("reverted %04d\n", v)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate down:", err)
+			return 1
+		}
+	case "status":
+		status, err := m.MigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate status:", err)
+			return 1
+		}
+		for _, st := range status {
+			state := "pending"
+			if st.AppliedAt != 0 {
+				state = "applied " + time.Unix(st.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			switch {
+			case st.Missing:
+				state += " (unknown to this binary)"
+			case st.Mismatch:
+				state += " (CHECKSUM MISMATCH)"
+			}
+			fmt.Printf("%04d %-20s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
+
+// prepareSchema brings the schema up to date at startup, or checks that it
+// already is when SCRYPTS_AUTO_MIGRATE is off.
+func prepareSchema(m storage.Migrator) error {
+	if !config.AutoMigrate {
+		n, err := storage.PendingMigrations(m)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%d pending migration(s); run `scrypts migrate up`", n)
+		}
+		return nil
+	}
+	done, err := m.MigrateUp()
+	for _, v := range done {
+		log.Printf("Applied migration %04d", v)
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"scrypts/internal/config"
+	"scrypts/internal/search"
+	"scrypts/internal/storage"
+)
+
+// runReindex implements `scrypts reindex`, which rebuilds the search index
+// of every note, the one SCRYPTS_SEARCH selects. It needs MASTER_KEY to
+// decrypt them.
+func runReindex(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: scrypts reindex")
+		return 2
+	}
+	config.Init()
+	store, err := openStore()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open db:", err)
+		return 1
+	}
+	defer store.Close()
+	if m, ok := store.(storage.Migrator); ok {
+		if n, err := storage.PendingMigrations(m); err != nil || n > 0 {
+			fmt.Fprintln(os.Stderr, "reindex: the schema isn't up to date; run `scrypts migrate up` first")
+			return 1
+		}
+	}
+	indexed, skipped, err := search.Reindex(store, config.MasterKey, config.SearchMode == config.SearchFTS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reindex:", err)
+		return 1
+	}
+	fmt.Printf("%d note(s) indexed\n", indexed)
+	if skipped > 0 {
+		fmt.Printf("%d note(s) don't decrypt and were skipped; run `scrypts doctor`\n", skipped)
+		return 1
+	}
+	return 0
+}
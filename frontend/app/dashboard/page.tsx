@@ -13,6 +13,7 @@ export default function DashboardPage() {
   const router = useRouter()
   const [sidebarOpen, setSidebarOpen] = useState(false)
   const [searchTerm, setSearchTerm] = useState('')
+  const [matchIds, setMatchIds] = useState<Set<string> | null>(null)
   const [deleteModalOpen, setDeleteModalOpen] = useState(false)
   const [noteToDelete, setNoteToDelete] = useState<Note | null>(null)
   
@@ -25,6 +26,7 @@ export default function DashboardPage() {
     currentNote,
     loading,
     fetchNotes,
+    searchNotes,
     createNote,
     updateNote,
     deleteNote,
@@ -45,8 +47,26 @@ export default function DashboardPage() {
     })
   }, [isAuthenticated, router, fetchNotes, showToast])
 
+  // Search on the server once typing pauses; queries it refuses, such as a
+  // single letter, are matched locally instead
+  useEffect(() => {
+    if (!searchTerm.trim()) {
+      setMatchIds(null)
+      return
+    }
+    const timer = setTimeout(() => {
+      searchNotes(searchTerm)
+        .then((ids) => setMatchIds(new Set(ids)))
+        .catch(() => setMatchIds(null))
+    }, 300)
+    return () => clearTimeout(timer)
+  }, [searchTerm, searchNotes, notes])
+
   // Filter notes by search term
   const filteredNotes = notes.filter(note => {
+    if (matchIds) {
+      return matchIds.has(note.id)
+    }
     const displayNote = useNotesStore.getState().getDisplayNote(note)
     return (
       displayNote.title.toLowerCase().includes(searchTerm.toLowerCase()) ||
@@ -0,0 +1,92 @@
+'use client'
+
+import { useParams } from 'next/navigation'
+import { useEffect, useRef, useState } from 'react'
+import { fetchLink, openLink, SealedLink } from '@/lib/store'
+
+// LinkPage shows a note shared through a public link. The key is in the
+// fragment of the URL and the note is decrypted here, in the browser.
+export default function LinkPage() {
+  const params = useParams()
+  const linkId = params.id as string
+
+  const [sealed, setSealed] = useState<SealedLink | null>(null)
+  const [note, setNote] = useState<{ title: string, content: string } | null>(null)
+  const [password, setPassword] = useState('')
+  const [error, setError] = useState('')
+  // every fetch counts as a view, so the link is fetched once even when the
+  // effect runs twice
+  const fetched = useRef(false)
+
+  const key = () => window.location.hash.slice(1)
+
+  useEffect(() => {
+    if (fetched.current) return
+    fetched.current = true
+    if (!key()) {
+      setError('The link is incomplete')
+      return
+    }
+    fetchLink(linkId)
+      .then(async (s) => {
+        setSealed(s)
+        if (!s.salt) {
+          setNote(await openLink(s, key()))
+        }
+      })
+      .catch((e: Error) => setError(e.message))
+  }, [linkId])
+
+  const handleUnlock = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (!sealed) return
+    try {
+      setNote(await openLink(sealed, key(), password))
+      setError('')
+    } catch (e: any) {
+      setError(e.message)
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-background p-4">
+      <div className="w-full max-w-2xl space-y-4">
+        {note ? (
+          <>
+            <h1 className="text-lg font-mono font-medium text-foreground">
+              {note.title || 'Untitled'}
+            </h1>
+            <pre className="whitespace-pre-wrap font-mono text-sm text-foreground bg-slate-800/30 border border-slate-700/50 rounded p-4">
+              {note.content}
+            </pre>
+            {sealed?.views_left === 0 && (
+              <p className="text-slate-400 font-mono text-sm">
+                This was the last view; the link no longer works.
+              </p>
+            )}
+          </>
+        ) : sealed?.salt ? (
+          <form onSubmit={handleUnlock} className="space-y-4">
+            <p className="text-foreground font-mono">This note is protected by a password.</p>
+            <input
+              type="password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className="w-full px-3 py-2 bg-slate-800 border border-slate-700 rounded font-mono text-foreground"
+              autoFocus
+            />
+            <button
+              type="submit"
+              className="px-4 py-2 bg-accent hover:bg-accent-dark text-slate-900 font-mono font-medium rounded transition-colors"
+            >
+              Open
+            </button>
+          </form>
+        ) : !error && (
+          <div className="text-accent font-mono">Loading note...</div>
+        )}
+        {error && <p className="text-red-400 font-mono text-sm">{error}</p>}
+      </div>
+    </div>
+  )
+}
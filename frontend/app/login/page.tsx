@@ -14,7 +14,7 @@ export default function LoginPage() {
   const register = useAuthStore((state) => state.register)
   const { showToast, Toast } = useToast()
 
-  const handleSubmit = async (username: string, password: string) => {
+  const handleSubmit = async (username: string, password: string, inviteCode: string) => {
     setLoading(true)
     try {
       if (mode === 'login') {
@@ -22,7 +22,7 @@ export default function LoginPage() {
         showToast('Welcome back!', 'success')
         router.push('/dashboard')
       } else {
-        await register(username, password)
+        await register(username, password, inviteCode)
         showToast('Account created! Please sign in.', 'success')
         setMode('login')
       }
@@ -1,11 +1,12 @@
 'use client'
 
 import { useState } from 'react'
-import { Eye, EyeOff, Lock, User } from 'lucide-react'
+import { Eye, EyeOff, Lock, Ticket, User } from 'lucide-react'
 
 interface AuthFormProps {
   mode: 'login' | 'register'
-  onSubmit: (username: string, password: string) => Promise<void>
+  // inviteCode is empty when none was given, and always when logging in
+  onSubmit: (username: string, password: string, inviteCode: string) => Promise<void>
   onModeChange: () => void
   loading: boolean
 }
@@ -13,12 +14,13 @@ interface AuthFormProps {
 export function AuthForm({ mode, onSubmit, onModeChange, loading }: AuthFormProps) {
   const [username, setUsername] = useState('')
   const [password, setPassword] = useState('')
+  const [inviteCode, setInviteCode] = useState('')
   const [showPassword, setShowPassword] = useState(false)
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
     if (username.trim() && password.trim()) {
-      await onSubmit(username.trim(), password)
+      await onSubmit(username.trim(), password, mode === 'register' ? inviteCode.trim() : '')
     }
   }
 
@@ -90,6 +92,27 @@ export function AuthForm({ mode, onSubmit, onModeChange, loading }: AuthFormProp
               </div>
             </div>
 
+            {/* Invitation code, needed when the server only lets invited users register */}
+            {mode === 'register' && (
+              <div>
+                <label htmlFor="invite-code" className="sr-only">
+                  Invitation code
+                </label>
+                <div className="relative">
+                  <Ticket className="absolute left-3 top-1/2 -translate-y-1/2 w-5 h-5 text-slate-400" />
+                  <input
+                    id="invite-code"
+                    type="text"
+                    value={inviteCode}
+                    onChange={(e) => setInviteCode(e.target.value)}
+                    placeholder="Invitation code (if you have one)"
+                    disabled={loading}
+                    className="w-full pl-10 pr-4 py-3 bg-slate-900/50 border border-slate-600 rounded-lg focus:border-accent focus:ring-1 focus:ring-accent text-foreground placeholder-slate-400 font-mono transition-colors disabled:opacity-50"
+                  />
+                </div>
+              </div>
+            )}
+
             {/* Submit Button */}
             <button
               type="submit"
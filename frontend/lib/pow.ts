@@ -0,0 +1,31 @@
+import axios from 'axios'
+
+export type PowPurpose = 'register' | 'login'
+
+export interface PowSolution {
+  pow_challenge: string
+  pow_solution: string
+}
+
+// solveChallenge fetches a proof-of-work challenge for purpose and solves
+// it in a worker, so that the page stays responsive while it does.
+export const solveChallenge = async (purpose: PowPurpose): Promise<PowSolution> => {
+  const response = await axios.get('/pow/challenge', { params: { purpose } })
+  const { challenge, difficulty } = response.data
+  const worker = new Worker(new URL('./pow.worker.ts', import.meta.url))
+  try {
+    const solution = await new Promise<string>((resolve, reject) => {
+      worker.onmessage = (event: MessageEvent<string>) => resolve(event.data)
+      worker.onerror = () => reject(new Error('Failed to solve the challenge'))
+      worker.postMessage({ challenge, difficulty })
+    })
+    return { pow_challenge: challenge, pow_solution: solution }
+  } finally {
+    worker.terminate()
+  }
+}
+
+// powRequired tells whether the server refused a request for want of a
+// proof of work.
+export const powRequired = (error: any): boolean =>
+  error.response?.status === 428 && error.response?.data?.error?.code === 'pow_required'
@@ -0,0 +1,34 @@
+// Solves a proof-of-work challenge off the main thread: finds a string s
+// such that SHA-256(challenge + ":" + s) starts with difficulty zero bits,
+// and posts it back.
+
+// digests are computed this many at a time, as each one is asynchronous
+const BATCH = 256
+
+const leadingZeroBits = (digest: ArrayBuffer): number => {
+  const bytes = new Uint8Array(digest)
+  let bits = 0
+  for (const b of bytes) {
+    if (b === 0) {
+      bits += 8
+      continue
+    }
+    return bits + Math.clz32(b) - 24
+  }
+  return bits
+}
+
+self.onmessage = async (event: MessageEvent<{ challenge: string, difficulty: number }>) => {
+  const { challenge, difficulty } = event.data
+  const encoder = new TextEncoder()
+  for (let n = 0; ; n += BATCH) {
+    const candidates = Array.from({ length: BATCH }, (_, i) => (n + i).toString(36))
+    const digests = await Promise.all(candidates.map(s =>
+      crypto.subtle.digest('SHA-256', encoder.encode(`${challenge}:${s}`))))
+    const i = digests.findIndex(d => leadingZeroBits(d) >= difficulty)
+    if (i >= 0) {
+      self.postMessage(candidates[i])
+      return
+    }
+  }
+}
@@ -1,12 +1,63 @@
 import { create } from 'zustand'
 import axios from 'axios'
+import { PowPurpose, powRequired, solveChallenge } from './pow'
 
 export interface Note {
   id: string
   owner: string
+  title: string
   content: string
+  notebook?: string
+  tags: string[]
   created: number
   modified: number
+  revision: number
+  // notes shared with the user only
+  permission?: SharePermission
+}
+
+export type SharePermission = 'read' | 'write'
+
+export interface Share {
+  recipient: string
+  permission: SharePermission
+  created: number
+}
+
+export interface Link {
+  id: string
+  note: string
+  created: number
+  expires: number
+  max_views?: number
+  views: number
+  password: boolean
+}
+
+export interface LinkOptions {
+  expiresIn?: number // seconds
+  maxViews?: number
+  password?: string
+}
+
+// SealedLink is what the server keeps of a public link: the snapshot,
+// encrypted under a key it never sees
+export interface SealedLink {
+  ciphertext: string
+  nonce: string
+  salt?: string
+  iterations?: number
+  expires: number
+  views_left?: number
+}
+
+export interface Attachment {
+  id: string
+  note: string
+  name: string
+  content_type: string
+  size: number
+  created: number
 }
 
 export interface NoteDisplay {
@@ -21,7 +72,7 @@ interface AuthState {
   token: string | null
   user: string | null
   login: (username: string, password: string) => Promise<void>
-  register: (username: string, password: string) => Promise<void>
+  register: (username: string, password: string, inviteCode?: string) => Promise<void>
   logout: () => void
   isAuthenticated: () => boolean
 }
@@ -31,9 +82,21 @@ interface NotesState {
   currentNote: Note | null
   loading: boolean
   fetchNotes: () => Promise<void>
+  searchNotes: (query: string) => Promise<string[]>
   createNote: (title: string, content: string) => Promise<void>
   updateNote: (id: string, title: string, content: string) => Promise<void>
   deleteNote: (id: string) => Promise<void>
+  listAttachments: (noteId: string) => Promise<Attachment[]>
+  uploadAttachment: (noteId: string, file: File) => Promise<Attachment>
+  downloadAttachment: (attachment: Attachment) => Promise<Blob>
+  deleteAttachment: (attachment: Attachment) => Promise<void>
+  fetchSharedNotes: () => Promise<Note[]>
+  listShares: (noteId: string) => Promise<Share[]>
+  shareNote: (noteId: string, username: string, permission: SharePermission) => Promise<Share>
+  unshareNote: (noteId: string, username: string) => Promise<void>
+  createLink: (noteId: string, options: LinkOptions) => Promise<{ link: Link, url: string }>
+  listLinks: (noteId: string) => Promise<Link[]>
+  deleteLink: (link: Link) => Promise<void>
   setCurrentNote: (note: Note | null) => void
   getDisplayNote: (note: Note) => NoteDisplay
 }
@@ -41,7 +104,25 @@ interface NotesState {
 const API_BASE = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8081'
 
 // Configure axios defaults
-axios.defaults.baseURL = API_BASE
+axios.defaults.baseURL = `${API_BASE}/api/v1`
+
+// errorMessage returns the message of an API error response
+// ({"error": {"code", "message", "request_id"}}), or fallback.
+const errorMessage = (error: any, fallback: string): string =>
+  error.response?.data?.error?.message || fallback
+
+// postWithPow posts body to path and, if the server asks for a proof of
+// work first, solves a challenge for purpose and posts it again with it.
+const postWithPow = async (path: string, body: object, purpose: PowPurpose) => {
+  try {
+    return await axios.post(path, body)
+  } catch (error: any) {
+    if (!powRequired(error)) {
+      throw error
+    }
+    return axios.post(path, { ...body, ...await solveChallenge(purpose) })
+  }
+}
 
 // Axios interceptor to add token to requests
 axios.interceptors.request.use((config) => {
@@ -69,19 +150,20 @@ export const useAuthStore = create<AuthState>((set, get) => ({
   
   login: async (username: string, password: string) => {
     try {
-      const response = await axios.post('/login', { username, password })
+      const response = await postWithPow('/login', { username, password }, 'login')
       const { token } = response.data
       set({ token, user: username })
     } catch (error: any) {
-      throw new Error(error.response?.data || 'Login failed')
+      throw new Error(errorMessage(error, 'Login failed'))
     }
   },
   
-  register: async (username: string, password: string) => {
+  register: async (username: string, password: string, inviteCode?: string) => {
     try {
-      await axios.post('/register', { username, password })
+      // undefined, and left out, without a code
+      await postWithPow('/register', { username, password, invite_code: inviteCode || undefined }, 'register')
     } catch (error: any) {
-      throw new Error(error.response?.data || 'Registration failed')
+      throw new Error(errorMessage(error, 'Registration failed'))
     }
   },
   
@@ -102,19 +184,31 @@ export const useNotesStore = create<NotesState>((set, get) => ({
   fetchNotes: async () => {
     set({ loading: true })
     try {
-      const response = await axios.get('/notes')
-      set({ notes: response.data, loading: false })
+      // the list is paged; follow the cursor to load every note
+      const notes: Note[] = []
+      let cursor: string | undefined
+      do {
+        const response = await axios.get('/notes', { params: { limit: 200, cursor } })
+        notes.push(...response.data.notes)
+        cursor = response.data.next_cursor
+      } while (cursor)
+      set({ notes, loading: false })
     } catch (error) {
       set({ loading: false })
       throw error
     }
   },
   
+  // searchNotes returns the ids of the notes with all of the words of query,
+  // found by the server's search index
+  searchNotes: async (query: string) => {
+    const response = await axios.get('/notes/search', { params: { q: query, limit: 200 } })
+    return response.data.notes.map((note: Note) => note.id)
+  },
+
   createNote: async (title: string, content: string) => {
     try {
-      // Combine title and content for backend
-      const fullContent = title ? `${title}\n${content}` : content
-      const response = await axios.post('/notes', { content: fullContent })
+      const response = await axios.post('/notes', { title, content })
       // Backend returns {id: "uuid"}, we need to fetch notes to get the full note
       await get().fetchNotes()
     } catch (error) {
@@ -124,10 +218,12 @@ export const useNotesStore = create<NotesState>((set, get) => ({
   
   updateNote: async (id: string, title: string, content: string) => {
     try {
-      // Combine title and content for backend
-      const fullContent = title ? `${title}\n${content}` : content
-      await axios.put('/notes', { id, content: fullContent })
-      // Backend returns {status: "updated"}, we need to fetch notes to get updated data
+      // the revision we last saw; the server refuses the update if the note
+      // has been changed since, e.g. in another tab
+      const revision = get().notes.find(note => note.id === id)?.revision
+      // PATCH rather than PUT, which would clear the notebook and tags
+      await axios.patch(`/notes/${id}`, { title, content, revision })
+      // refetch so that the list order follows the new modification time
       await get().fetchNotes()
       // Update current note if it was the one being edited
       const notes = get().notes
@@ -135,33 +231,125 @@ export const useNotesStore = create<NotesState>((set, get) => ({
       if (updatedNote && get().currentNote?.id === id) {
         set({ currentNote: updatedNote })
       }
-    } catch (error) {
+    } catch (error: any) {
+      if (error.response?.status === 412) {
+        await get().fetchNotes()
+        throw new Error('This note was changed elsewhere; review the latest version and try again')
+      }
       throw error
     }
   },
   
   deleteNote: async (id: string) => {
     try {
-      await axios.delete('/notes', { data: { id } })
+      const revision = get().notes.find(note => note.id === id)?.revision
+      await axios.delete(`/notes/${id}`, { params: { revision } })
       set({ 
         notes: get().notes.filter(note => note.id !== id),
         currentNote: get().currentNote?.id === id ? null : get().currentNote
       })
-    } catch (error) {
+    } catch (error: any) {
+      if (error.response?.status === 412) {
+        await get().fetchNotes()
+        throw new Error('This note was changed elsewhere; review the latest version before deleting it')
+      }
       throw error
     }
   },
   
+  listAttachments: async (noteId: string) => {
+    const response = await axios.get(`/notes/${noteId}/attachments`)
+    return response.data.attachments
+  },
+
+  // uploadAttachment sends the file as the request body, which the server
+  // encrypts as it streams in
+  uploadAttachment: async (noteId: string, file: File) => {
+    try {
+      const response = await axios.post(`/notes/${noteId}/attachments`, file, {
+        params: { name: file.name },
+        headers: { 'Content-Type': file.type || 'application/octet-stream' },
+      })
+      return response.data
+    } catch (error: any) {
+      throw new Error(errorMessage(error, 'Failed to attach file'))
+    }
+  },
+
+  downloadAttachment: async (attachment: Attachment) => {
+    const response = await axios.get(`/notes/${attachment.note}/attachments/${attachment.id}`, { responseType: 'blob' })
+    return response.data
+  },
+
+  deleteAttachment: async (attachment: Attachment) => {
+    await axios.delete(`/notes/${attachment.note}/attachments/${attachment.id}`)
+  },
+
+  // fetchSharedNotes returns the notes other users share with this one; they
+  // are updated through updateNote when their permission is write
+  fetchSharedNotes: async () => {
+    const response = await axios.get('/notes/shared')
+    return response.data.notes
+  },
+
+  listShares: async (noteId: string) => {
+    const response = await axios.get(`/notes/${noteId}/shares`)
+    return response.data.shares
+  },
+
+  shareNote: async (noteId: string, username: string, permission: SharePermission) => {
+    try {
+      const response = await axios.put(`/notes/${noteId}/shares/${encodeURIComponent(username)}`, { permission })
+      return response.data
+    } catch (error: any) {
+      throw new Error(errorMessage(error, 'Failed to share note'))
+    }
+  },
+
+  // unshareNote stops sharing a note of this user with username, or, with
+  // this user's own name, leaves a note shared with them
+  unshareNote: async (noteId: string, username: string) => {
+    await axios.delete(`/notes/${noteId}/shares/${encodeURIComponent(username)}`)
+  },
+
+  // createLink makes a public link to a snapshot of the note; its key is
+  // only ever in the fragment of url, which browsers don't send
+  createLink: async (noteId: string, options: LinkOptions) => {
+    try {
+      const response = await axios.post(`/notes/${noteId}/links`, {
+        expires_in: options.expiresIn,
+        max_views: options.maxViews,
+        password: options.password,
+      })
+      const { key, ...link } = response.data
+      return { link, url: `${window.location.origin}/link/${link.id}#${key}` }
+    } catch (error: any) {
+      throw new Error(errorMessage(error, 'Failed to create link'))
+    }
+  },
+
+  listLinks: async (noteId: string) => {
+    const response = await axios.get(`/notes/${noteId}/links`)
+    return response.data.links
+  },
+
+  deleteLink: async (link: Link) => {
+    await axios.delete(`/notes/${link.note}/links/${link.id}`)
+  },
+
   setCurrentNote: (note: Note | null) => {
     set({ currentNote: note })
   },
 
   getDisplayNote: (note: Note): NoteDisplay => {
-    // Parse title from content (first line)
-    const lines = note.content.split('\n')
-    const title = lines[0] || 'Untitled'
-    const content = lines.slice(1).join('\n')
-    
+    let { title, content } = note
+    if (!title) {
+      // notes written before titles were stored apart start with theirs
+      const lines = content.split('\n')
+      title = lines[0] || 'Untitled'
+      content = lines.slice(1).join('\n')
+    }
+
     return {
       id: note.id,
       title,
@@ -170,4 +358,49 @@ export const useNotesStore = create<NotesState>((set, get) => ({
       updatedAt: new Date(note.modified * 1000).toISOString()
     }
   },
-}))
\ No newline at end of file
+}))
+
+// fetchLink fetches the sealed snapshot behind a public link, which counts
+// as one of its views.
+export const fetchLink = async (id: string): Promise<SealedLink> => {
+  try {
+    const response = await axios.get(`/links/${id}`)
+    return response.data
+  } catch (error: any) {
+    throw new Error(errorMessage(error, 'Failed to fetch link'))
+  }
+}
+
+const fromBase64 = (s: string) => {
+  const b64 = s.replace(/-/g, '+').replace(/_/g, '/')
+  const bin = atob(b64 + '==='.slice((b64.length + 3) % 4))
+  return Uint8Array.from(bin, (c) => c.charCodeAt(0))
+}
+
+// openLink decrypts a sealed link in the browser with the key from the
+// fragment of its URL and, for links made with one, the password: the AES
+// key is then derived with PBKDF2-SHA256 from the key followed by the
+// password.
+export const openLink = async (sealed: SealedLink, key: string, password = ''): Promise<{ title: string, content: string }> => {
+  const raw = fromBase64(key)
+  let aesKey: CryptoKey
+  if (sealed.salt) {
+    const secret = new Uint8Array([...raw, ...new TextEncoder().encode(password)])
+    const material = await crypto.subtle.importKey('raw', secret, 'PBKDF2', false, ['deriveKey'])
+    aesKey = await crypto.subtle.deriveKey(
+      { name: 'PBKDF2', hash: 'SHA-256', salt: fromBase64(sealed.salt), iterations: sealed.iterations! },
+      material,
+      { name: 'AES-GCM', length: 256 },
+      false,
+      ['decrypt'],
+    )
+  } else {
+    aesKey = await crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['decrypt'])
+  }
+  try {
+    const plaintext = await crypto.subtle.decrypt({ name: 'AES-GCM', iv: fromBase64(sealed.nonce) }, aesKey, fromBase64(sealed.ciphertext))
+    return JSON.parse(new TextDecoder().decode(plaintext))
+  } catch {
+    throw new Error(sealed.salt ? 'Wrong password, or the link is incomplete' : 'The link is incomplete')
+  }
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/auth"
+	"scrypts/internal/backup"
+)
+
+// BackupHandler takes an online backup into the backup directory:
+// POST /admin/backup. The archive stays on the server.
+func (h *Handler) BackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.Backups == nil {
+		api.Error(w, r, http.StatusNotImplemented, api.CodeNotImplemented, "Backups are not configured")
+		return
+	}
+	caller, _ := auth.IdentityFromContext(r.Context())
+	info, err := h.Backups.Run(h.store)
+	switch err {
+	case nil:
+	case backup.ErrBusy:
+		api.Error(w, r, http.StatusConflict, api.CodeConflict, "A backup is already running")
+		return
+	case backup.ErrUnsupported:
+		api.Error(w, r, http.StatusNotImplemented, api.CodeNotImplemented, "Online backups are not supported by this database driver")
+		return
+	default:
+		log.Printf("backup error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Backup failed")
+		return
+	}
+	log.Printf("admin %s: created backup %s", caller.Username, info.Name)
+
+	api.JSON(w, http.StatusCreated, map[string]any{"file": info.Name, "size": info.Size, "created": info.Created.Unix()})
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/auth"
+	"scrypts/internal/backup"
+	"scrypts/internal/storage"
+	"strings"
+	"time"
+)
+
+// Handler serves the admin API.
+type Handler struct {
+	store storage.Store
+
+	// Backups is where POST /admin/backup writes archives; nil disables it.
+	Backups *backup.Config
+}
+
+func NewHandler(store storage.Store) *Handler {
+	return &Handler{store: store}
+}
+
+type UserResp struct {
+	Username       string `json:"username"`
+	Role           string `json:"role"`
+	Disabled       bool   `json:"disabled"`
+	Created        int64  `json:"created"`
+	NoteCount      int64  `json:"note_count"`
+	StorageBytes   int64  `json:"storage_bytes"`
+	ActiveSessions int64  `json:"active_sessions"`
+}
+
+// ListUsersHandler lists every user with their note count and storage usage.
+func (h *Handler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	users, err := h.store.ListUserSummaries(time.Now().Unix())
+	if err != nil {
+		log.Printf("ListUserSummaries error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch users")
+		return
+	}
+	resp := make([]UserResp, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, UserResp{
+			Username:       u.Username,
+			Role:           u.Role,
+			Disabled:       u.Disabled,
+			Created:        u.CreatedAt,
+			NoteCount:      u.NoteCount,
+			StorageBytes:   u.StorageBytes,
+			ActiveSessions: u.ActiveSessions,
+		})
+	}
+	api.JSON(w, http.StatusOK, resp)
+}
+
+// UserHandler serves the per-user admin actions:
+//
+//	DELETE /admin/users/{name}          delete the user and their notes
+//	POST   /admin/users/{name}/disable  disable the account and end its sessions
+//	POST   /admin/users/{name}/enable   re-enable the account
+//	POST   /admin/users/{name}/logout   end all of the user's sessions
+func (h *Handler) UserHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.IdentityFromContext(r.Context())
+	username, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/users/"), "/")
+	if username == "" {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Not found")
+		return
+	}
+
+	var status string
+	var err error
+	now := time.Now().Unix()
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		if username == caller.Username {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Admins cannot delete their own account")
+			return
+		}
+		status = "deleted"
+		err = h.store.DeleteUser(username)
+	case action == "disable" && r.Method == http.MethodPost:
+		if username == caller.Username {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Admins cannot disable their own account")
+			return
+		}
+		status = "disabled"
+		if err = h.store.SetUserDisabled(username, true); err == nil {
+			err = h.store.RevokeUserSessions(username, now)
+		}
+	case action == "enable" && r.Method == http.MethodPost:
+		status = "enabled"
+		err = h.store.SetUserDisabled(username, false)
+	case action == "logout" && r.Method == http.MethodPost:
+		status = "logged_out"
+		if _, err = h.store.GetUser(username); err == nil {
+			err = h.store.RevokeUserSessions(username, now)
+		}
+	case action == "" || action == "disable" || action == "enable" || action == "logout":
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	default:
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Not found")
+		return
+	}
+
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("admin %s %q error: %v", status, username, err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to update user")
+		return
+	}
+	log.Printf("admin %s: %s %s", caller.Username, status, username)
+	api.JSON(w, http.StatusOK, map[string]string{"status": status})
+}
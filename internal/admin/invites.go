@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/auth"
+	"scrypts/internal/storage"
+	"strings"
+	"time"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+type InviteResp struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires"`
+	UsedBy    string `json:"used_by,omitempty"`
+	Used      int64  `json:"used,omitempty"`
+}
+
+// InvitesHandler lists invitations (GET) or creates one (POST). The code is
+// returned once on creation; only its hash is stored.
+func (h *Handler) InvitesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listInvites(w, r)
+	case http.MethodPost:
+		h.createInvite(w, r)
+	default:
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (h *Handler) listInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.store.ListInvites()
+	if err != nil {
+		log.Printf("ListInvites error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch invites")
+		return
+	}
+	resp := make([]InviteResp, 0, len(invites))
+	for _, inv := range invites {
+		resp = append(resp, InviteResp{
+			ID:        inv.ID,
+			CreatedBy: inv.CreatedBy,
+			Created:   inv.CreatedAt,
+			Expires:   inv.ExpiresAt,
+			UsedBy:    inv.UsedBy,
+			Used:      inv.UsedAt,
+		})
+	}
+	api.JSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createInvite(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.IdentityFromContext(r.Context())
+	var req struct {
+		ExpiresInHours int `json:"expires_in_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	ttl := defaultInviteTTL
+	if req.ExpiresInHours != 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl <= 0 || ttl > maxInviteTTL {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Expires_in_hours must be between 1 and 720")
+		return
+	}
+
+	code, id, err := auth.NewInviteCode()
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to generate invite")
+		return
+	}
+	now := time.Now()
+	inv := storage.Invite{
+		ID:        id,
+		CreatedBy: caller.Username,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	if err := h.store.CreateInvite(inv); err != nil {
+		log.Printf("CreateInvite error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save invite")
+		return
+	}
+	log.Printf("admin %s: created invite %s", caller.Username, id[:12])
+
+	api.JSON(w, http.StatusCreated, map[string]any{"id": id, "code": code, "expires": inv.ExpiresAt})
+}
+
+// InviteHandler revokes an unused invitation: DELETE /admin/invites/{id}.
+func (h *Handler) InviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/admin/invites/")
+	err := h.store.DeleteInvite(id)
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Invite not found")
+		return
+	}
+	if err != nil {
+		log.Printf("DeleteInvite error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to revoke invite")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
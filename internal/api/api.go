@@ -0,0 +1,154 @@
+// Package api holds what every handler of the HTTP API shares: the version
+// prefix, request IDs and the JSON response format.
+//
+// Successful responses are JSON documents. Errors are
+//
+//	{"error": {"code": "not_found", "message": "Note not found", "request_id": "..."}}
+//
+// where code is one of the constants below and stays stable across
+// releases, message is meant for people and may change, and request_id is
+// also sent in the X-Request-ID header and written to the server log for
+// server errors.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Prefix is the path under which the current version of the API is served.
+const Prefix = "/api/v1"
+
+// Error codes.
+const (
+	CodeInvalidRequest     = "invalid_request"     // malformed body, query or path
+	CodeUnauthorized       = "unauthorized"        // missing, invalid or revoked token
+	CodeInvalidCredentials = "invalid_credentials" // wrong username or password
+	CodeForbidden          = "forbidden"
+	CodeAccountDisabled    = "account_disabled"
+	CodeRegistrationClosed = "registration_closed"
+	CodeInviteRequired     = "invite_required"
+	CodeInvalidInvite      = "invalid_invite"
+	CodeWeakPassword       = "weak_password"
+	CodePowRequired        = "pow_required"
+	CodeInvalidPow         = "invalid_pow"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeRevisionRequired   = "revision_required" // no If-Match or revision on a change
+	CodeRevisionMismatch   = "revision_mismatch" // the note changed since that revision
+	CodeTooLarge           = "too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeNotImplemented     = "not_implemented"
+	CodeInternal           = "internal_error"
+)
+
+// RequestIDHeader carries the request ID both ways.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID, taken from the X-Request-ID header
+// when a proxy in front already set a sane one, and echoes it in the
+// response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDFrom returns the ID that RequestID gave the request, or "".
+func RequestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// JSON writes v as the response with the given status.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ErrorBody is the body of an error response.
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error writes an error response. Server errors are logged with the request
+// ID, so that a user's report can be matched with the log.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	id := RequestIDFrom(r)
+	if status >= 500 {
+		log.Printf("request %s: %d %s", id, status, message)
+	}
+	// whatever http.Error or a failed handler may have set
+	w.Header().Del("Content-Length")
+	JSON(w, status, struct {
+		Error ErrorBody `json:"error"`
+	}{ErrorBody{Code: code, Message: message, RequestID: id}})
+}
+
+// Mux serves mux, answering requests that no route takes with JSON errors
+// rather than the plain text of http.ServeMux.
+func Mux(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern == "" {
+			// the mux's own 404 or 405 (with its Allow header)
+			h.ServeHTTP(&errorWriter{ResponseWriter: w, r: r}, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// errorWriter turns the plain text error written through it into a JSON
+// one.
+type errorWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	wrote bool
+}
+
+func (e *errorWriter) WriteHeader(status int) {
+	if e.wrote {
+		return
+	}
+	e.wrote = true
+	code := CodeNotFound
+	if status == http.StatusMethodNotAllowed {
+		code = CodeMethodNotAllowed
+	}
+	Error(e.ResponseWriter, e.r, status, code, http.StatusText(status))
+}
+
+func (e *errorWriter) Write(b []byte) (int, error) {
+	if !e.wrote {
+		e.WriteHeader(http.StatusOK)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"time"
+)
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePasswordHandler replaces the caller's password after checking the
+// current one, and signs out every other session.
+func (s *Service) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := s.Authenticate(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req ChangePasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	u, err := s.store.GetUser(id.Username)
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return
+	}
+	if !CheckPasswordHash(req.CurrentPassword, u.PasswordHash) {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "New password must differ from the current one")
+		return
+	}
+	if !s.checkPassword(w, r, req.NewPassword, id.Username) {
+		return
+	}
+
+	hashed, err := HashPass(req.NewPassword)
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to hash password")
+		return
+	}
+	if err := s.store.SetPasswordHash(id.Username, hashed); err != nil {
+		log.Printf("SetPasswordHash error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to update password")
+		return
+	}
+	if err := s.store.RevokeOtherSessions(id.Username, id.SessionID, time.Now().Unix()); err != nil {
+		log.Printf("RevokeOtherSessions error: %v", err)
+	}
+
+	api.JSON(w, http.StatusOK, map[string]string{"status": "password_changed"})
+}
@@ -1,140 +1,211 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	mrand "math/rand"
 	"net/http"
+	"scrypts/internal/api"
 	"scrypts/internal/config"
+	"scrypts/internal/passpolicy"
+	"scrypts/internal/pow"
 	"scrypts/internal/storage"
 	"scrypts/internal/utils"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type RegisterReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	InviteCode   string `json:"invite_code,omitempty"`
+	PowChallenge string `json:"pow_challenge,omitempty"`
+	PowSolution  string `json:"pow_solution,omitempty"`
 }
 
 type LoginReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PowChallenge string `json:"pow_challenge,omitempty"`
+	PowSolution  string `json:"pow_solution,omitempty"`
 }
 
-var JwtSecret = config.JwtSecret
+const tokenTTL = 15 * time.Minute
 
-func generateJWT(username string) (string, error) {
+// Service holds the handlers for registration, login and account management
+// along with the store they use.
+type Service struct {
+	store storage.Store
+
+	// PasswordPolicy is applied on registration and password change.
+	PasswordPolicy *passpolicy.Policy
+
+	// ProofOfWork verifies the challenges required on registration and on
+	// login after repeated failures. Nil disables proof of work.
+	ProofOfWork *pow.Issuer
+
+	loginFailures *failureTracker
+}
+
+// New returns a Service backed by store, with the default password policy
+// and proof of work disabled.
+func New(store storage.Store) *Service {
+	return &Service{
+		store:          store,
+		PasswordPolicy: &passpolicy.Policy{MinLength: 8, MinScore: 3},
+		loginFailures:  &failureTracker{counts: make(map[string]*failureCount)},
+	}
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Username  string
+	SessionID string
+	Admin     bool
+}
+
+// generateJWT signs a token for username whose jti is the ID of the session it belongs to.
+func generateJWT(username, sessionID string, admin bool, expires time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(15 * time.Minute).Unix(),
+		"jti":      sessionID,
+		"admin":    admin,
+		"exp":      expires.Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtSecret)
+	return token.SignedString(config.JwtSecret)
 }
 
-func isComplex(password string) bool {
-	var hasUpper, hasLower, hasDigit, hasSpecial bool
-	for _, c := range password {
-		switch {
-		case unicode.IsUpper(c):
-			hasUpper = true
-		case unicode.IsLower(c):
-			hasLower = true
-		case unicode.IsDigit(c):
-			hasDigit = true
-		case !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c):
-			hasSpecial = true
-		}
+// checkPassword writes an error response and returns false if password is
+// rejected by the password policy.
+func (s *Service) checkPassword(w http.ResponseWriter, r *http.Request, password, username string) bool {
+	err := s.PasswordPolicy.Check(password, username)
+	if err == nil {
+		return true
 	}
-	return hasUpper && hasLower && hasDigit && hasSpecial
+	var rejected *passpolicy.RejectedError
+	if errors.As(err, &rejected) {
+		api.Error(w, r, http.StatusBadRequest, api.CodeWeakPassword, rejected.Reason)
+		return false
+	}
+	log.Printf("password policy error: %v", err)
+	api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+	return false
 }
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if config.RegistrationMode == config.RegistrationClosed {
+		api.Error(w, r, http.StatusForbidden, api.CodeRegistrationClosed, "Registration is closed")
 		return
 	}
 	var req RegisterReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
 		return
 	}
-	if len(req.Username) < 4 || len(req.Password) < 8 {
-		http.Error(w, "Invalid username or password", http.StatusBadRequest)
+	inviteOnly := config.RegistrationMode == config.RegistrationInvite
+	if inviteOnly && req.InviteCode == "" {
+		api.Error(w, r, http.StatusForbidden, api.CodeInviteRequired, "Invitation code required")
 		return
 	}
-	if !isComplex(req.Password) {
-		http.Error(w, "Password must contain uppercase, lowercase, digit, and special character", http.StatusBadRequest)
+	if len(req.Username) < 4 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid username or password")
+		return
+	}
+	if !s.checkPassword(w, r, req.Password, req.Username) {
+		return
+	}
+	// checked last, as a solved challenge is spent even when the
+	// registration fails
+	if !s.checkProofOfWork(w, r, req.PowChallenge, req.PowSolution, pow.PurposeRegister) {
 		return
 	}
 
 	// Check if user exists (prevent user enumeration with timing attack mitigation)
-	_, err := storage.GetUser(req.Username)
+	_, err := s.store.GetUser(req.Username)
 	if err == nil {
 		// User exists - add random delay to mimic registration time
 		time.Sleep(time.Duration(50+mrand.Intn(50)) * time.Millisecond)
-		http.Error(w, "Registration failed", http.StatusBadRequest)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Registration failed")
 		return
-	} else if err != sql.ErrNoRows {
-		http.Error(w, "Server error", http.StatusInternalServerError)
+	} else if err != storage.ErrNotFound {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
 		return
 	}
 
 	hashed, err := HashPass(req.Password)
 	if err != nil {
-		http.Error(w, "Error in hashing password", http.StatusInternalServerError)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to hash password")
+		return
+	}
+
+	// the key is created with the user, so that no user is ever left without
+	// one and unable to use their notes
+	userKey := make([]byte, 32)
+	if _, err := rand.Read(userKey); err != nil {
+		log.Printf("user key generation error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return
+	}
+	wrapped, nonce, err := utils.WrapKey(config.MasterKey, userKey)
+	if err != nil {
+		log.Printf("WrapKey error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
 		return
 	}
 
 	u := storage.User{
 		Username:     req.Username,
 		PasswordHash: hashed,
-		WrappedKey:   nil,
-		WrappedNonce: nil,
+		WrappedKey:   wrapped,
+		WrappedNonce: nonce,
 		CreatedAt:    time.Now().Unix(),
 	}
-	if err := storage.CreateUser(u); err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	if inviteOnly {
+		err = s.store.CreateUserWithInvite(u, InviteID(req.InviteCode), u.CreatedAt)
+	} else {
+		err = s.store.CreateUser(u)
+	}
+	if err == storage.ErrInvalidInvite {
+		api.Error(w, r, http.StatusForbidden, api.CodeInvalidInvite, "Invalid or expired invitation code")
 		return
 	}
-
-	userKey := make([]byte, 32)
-	if _, err := rand.Read(userKey); err != nil {
-		fmt.Println("warning: failed to generate user encryption key:", err)
-	} else {
-		wrapped, nonce, werr := utils.WrapKey(config.MasterKey, userKey)
-		if werr != nil {
-			fmt.Println("warning: failed to wrap user key:", werr)
-		} else {
-			if err := storage.SaveWrappedKey(req.Username, wrapped, nonce); err != nil {
-				fmt.Println("warning: failed to save wrapped key to DB:", err)
-			}
-		}
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to create user")
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "User registered successfully")
+	api.JSON(w, http.StatusCreated, map[string]string{"status": "registered"})
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Service) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 	var req LoginReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	ip := utils.ClientIP(r)
+	if s.loginNeedsProofOfWork(ip) && !s.checkProofOfWork(w, r, req.PowChallenge, req.PowSolution, pow.PurposeLogin) {
 		return
 	}
 
 	// Always perform timing-consistent operations
-	u, err := storage.GetUser(req.Username)
+	u, err := s.store.GetUser(req.Username)
 
 	// Dummy hash for timing attack mitigation when user doesn't exist
 	dummyHash := "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
@@ -151,25 +222,53 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Only succeed if both user exists and password is correct
 	if !userValid || !passwordValid {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		s.loginFailures.add(ip)
+		api.Error(w, r, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+	s.loginFailures.clear(ip)
+	if u.Disabled {
+		api.Error(w, r, http.StatusForbidden, api.CodeAccountDisabled, "Account disabled")
 		return
 	}
 
-	token, err := generateJWT(req.Username)
+	now := time.Now()
+	sess := storage.Session{
+		ID:        uuid.New().String(),
+		Username:  req.Username,
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+		CreatedAt: now.Unix(),
+		LastSeen:  now.Unix(),
+		ExpiresAt: now.Add(tokenTTL).Unix(),
+	}
+	if err := s.store.CreateSession(sess); err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not create session")
+		return
+	}
+
+	token, err := generateJWT(req.Username, sess.ID, u.Role == storage.RoleAdmin, now.Add(tokenTTL))
 	if err != nil {
-		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not generate token")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	api.JSON(w, http.StatusOK, map[string]string{"token": token})
 }
 
 // GetUsernameFromJWT extracts the username claim from a Bearer JWT in the request.
-func GetUsernameFromJWT(r *http.Request) (string, error) {
+// Tokens whose session has been revoked are rejected.
+func (s *Service) GetUsernameFromJWT(r *http.Request) (string, error) {
+	id, err := s.Authenticate(r)
+	return id.Username, err
+}
+
+// Authenticate validates the Bearer JWT in the request and the session named
+// by its jti.
+func (s *Service) Authenticate(r *http.Request) (Identity, error) {
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", http.ErrNoCookie
+		return Identity{}, http.ErrNoCookie
 	}
 	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -177,24 +276,64 @@ func GetUsernameFromJWT(r *http.Request) (string, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return JwtSecret, nil
+		return config.JwtSecret, nil
 	})
 	if err != nil || !token.Valid {
-		return "", err
+		return Identity{}, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("invalid claims")
+		return Identity{}, fmt.Errorf("invalid claims")
 	}
 	username, ok := claims["username"].(string)
 	if !ok {
-		return "", fmt.Errorf("username not found in token")
+		return Identity{}, fmt.Errorf("username not found in token")
+	}
+	sessionID, ok := claims["jti"].(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("session not found in token")
+	}
+	admin, _ := claims["admin"].(bool)
+	sess, err := s.store.GetSession(sessionID)
+	if err != nil {
+		return Identity{}, fmt.Errorf("unknown session")
+	}
+	if sess.Username != username || sess.RevokedAt != 0 {
+		return Identity{}, fmt.Errorf("session revoked")
+	}
+	if err := s.store.TouchSession(sessionID, time.Now().Unix()); err != nil {
+		log.Printf("TouchSession error: %v", err)
+	}
+	return Identity{Username: username, SessionID: sessionID, Admin: admin}, nil
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id, for handlers behind
+// middleware that has already authenticated the request.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored by WithIdentity.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// IsActiveAdmin reports whether username currently has the admin role and is
+// not disabled, so that demoted or disabled admins lose access before their
+// token expires.
+func (s *Service) IsActiveAdmin(username string) (bool, error) {
+	u, err := s.store.GetUser(username)
+	if err != nil {
+		return false, err
 	}
-	return username, nil
+	return u.Role == storage.RoleAdmin && !u.Disabled, nil
 }
 
-func GetUserKey(username string) ([]byte, error) {
-	u, err := storage.GetUser(username)
+func (s *Service) GetUserKey(username string) ([]byte, error) {
+	u, err := s.store.GetUser(username)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"testing"
+)
+
+const testPassword = "Tr0ub4dor&3-horse-battery"
+
+func TestMain(m *testing.M) {
+	config.JwtSecret = []byte("test-jwt-secret-test-jwt-secret-0123456789")
+	config.MasterKey = []byte("test-master-key-0123456789abcdef")
+	os.Exit(m.Run())
+}
+
+// post sends body as JSON to h and returns the response.
+func post(h http.HandlerFunc, body any) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// errorCode returns the code of an API error response.
+func errorCode(rec *httptest.ResponseRecorder) string {
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp.Error.Code
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	config.RegistrationMode = config.RegistrationOpen
+	store := storage.NewMemoryStore()
+	s := New(store)
+
+	rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body)
+	}
+	u, err := store.GetUser("alice1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.WrappedKey) == 0 || u.PasswordHash == testPassword {
+		t.Errorf("registered user = %+v", u)
+	}
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword}); rec.Code != http.StatusBadRequest {
+		t.Errorf("registering a taken username: %d", rec.Code)
+	}
+
+	if rec := post(s.LoginHandler, LoginReq{Username: "alice1", Password: "wrong password"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password: %d", rec.Code)
+	}
+	rec = post(s.LoginHandler, LoginReq{Username: "alice1", Password: testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	if name, err := s.GetUsernameFromJWT(req); err != nil || name != "alice1" {
+		t.Errorf("GetUsernameFromJWT = %q, %v", name, err)
+	}
+}
+
+func TestRegisterWeakPassword(t *testing.T) {
+	config.RegistrationMode = config.RegistrationOpen
+	store := storage.NewMemoryStore()
+	s := New(store)
+
+	for _, pw := range []string{"password", "Password1!", "bobby1-bobby1"} {
+		if rec := post(s.RegisterHandler, RegisterReq{Username: "bobby1", Password: pw}); errorCode(rec) != "weak_password" {
+			t.Errorf("registering with %q: %d %s", pw, rec.Code, rec.Body)
+		}
+	}
+	if _, err := store.GetUser("bobby1"); err != storage.ErrNotFound {
+		t.Errorf("a rejected registration made the user: %v", err)
+	}
+}
+
+func TestLoginDisabled(t *testing.T) {
+	config.RegistrationMode = config.RegistrationOpen
+	store := storage.NewMemoryStore()
+	s := New(store)
+
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword}); rec.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body)
+	}
+	if err := store.SetUserDisabled("alice1", true); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(s.LoginHandler, LoginReq{Username: "alice1", Password: testPassword}); errorCode(rec) != "account_disabled" {
+		t.Errorf("login to a disabled account: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(s.LoginHandler, LoginReq{Username: "alice1", Password: "wrong password"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login to a disabled account with a wrong password: %d", rec.Code)
+	}
+}
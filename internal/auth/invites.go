@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// NewInviteCode returns a fresh invitation code and the ID under which it is
+// stored. The code itself is only ever shown to the admin who created it.
+func NewInviteCode() (code, id string, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code = hex.EncodeToString(b)
+	return code, InviteID(code), nil
+}
+
+// InviteID hashes an invitation code. Codes carry 128 random bits, so a fast
+// hash is enough to keep a leaked database from yielding usable codes.
+func InviteID(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
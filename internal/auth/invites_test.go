@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"testing"
+	"time"
+)
+
+func TestRegisterWithInvite(t *testing.T) {
+	config.RegistrationMode = config.RegistrationInvite
+	defer func() { config.RegistrationMode = config.RegistrationOpen }()
+	store := storage.NewMemoryStore()
+	s := New(store)
+
+	code, id, err := NewInviteCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	if err := store.CreateInvite(storage.Invite{ID: id, CreatedBy: "admin", CreatedAt: now, ExpiresAt: now + 3600}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword}); errorCode(rec) != "invite_required" {
+		t.Errorf("registering without a code: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword, InviteCode: "wrong"}); errorCode(rec) != "invalid_invite" {
+		t.Errorf("registering with a wrong code: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword, InviteCode: code}); rec.Code != http.StatusCreated {
+		t.Fatalf("registering with a code: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "bobby1", Password: testPassword, InviteCode: code}); errorCode(rec) != "invalid_invite" {
+		t.Errorf("registering with a used code: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRegisterClosed(t *testing.T) {
+	config.RegistrationMode = config.RegistrationClosed
+	defer func() { config.RegistrationMode = config.RegistrationOpen }()
+	s := New(storage.NewMemoryStore())
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword}); errorCode(rec) != "registration_closed" {
+		t.Errorf("registering while closed: %d %s", rec.Code, rec.Body)
+	}
+}
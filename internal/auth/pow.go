@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/config"
+	"sync"
+	"time"
+)
+
+const loginFailureWindow = 15 * time.Minute
+
+// failureTracker counts failed logins per client IP.
+type failureTracker struct {
+	mu     sync.Mutex
+	counts map[string]*failureCount
+}
+
+type failureCount struct {
+	n     int
+	reset time.Time
+}
+
+func (ft *failureTracker) count(key string) int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	c, ok := ft.counts[key]
+	if !ok || time.Now().After(c.reset) {
+		return 0
+	}
+	return c.n
+}
+
+func (ft *failureTracker) add(key string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	now := time.Now()
+	c, ok := ft.counts[key]
+	if !ok || now.After(c.reset) {
+		// drop stale entries while we're here so the map can't grow without bound
+		for k, old := range ft.counts {
+			if now.After(old.reset) {
+				delete(ft.counts, k)
+			}
+		}
+		c = &failureCount{}
+		ft.counts[key] = c
+	}
+	c.n++
+	c.reset = now.Add(loginFailureWindow)
+}
+
+func (ft *failureTracker) clear(key string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	delete(ft.counts, key)
+}
+
+// checkProofOfWork writes an error response and returns false if proof of
+// work is enabled and the request's solution doesn't verify.
+func (s *Service) checkProofOfWork(w http.ResponseWriter, r *http.Request, challenge, solution, purpose string) bool {
+	if s.ProofOfWork == nil {
+		return true
+	}
+	if challenge == "" || solution == "" {
+		api.Error(w, r, http.StatusPreconditionRequired, api.CodePowRequired, "Proof of work required")
+		return false
+	}
+	if err := s.ProofOfWork.Verify(challenge, solution, purpose); err != nil {
+		api.Error(w, r, http.StatusForbidden, api.CodeInvalidPow, "Invalid proof of work: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// loginNeedsProofOfWork reports whether the client has failed enough logins
+// recently to be asked for proof of work.
+func (s *Service) loginNeedsProofOfWork(ip string) bool {
+	return s.ProofOfWork != nil && s.loginFailures.count(ip) >= config.PowLoginFailures
+}
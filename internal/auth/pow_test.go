@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"scrypts/internal/config"
+	"scrypts/internal/pow"
+	"scrypts/internal/storage"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solve finds a solution to a challenge by brute force.
+func solve(c pow.Challenge) string {
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		sum := sha256.Sum256([]byte(c.Token + ":" + s))
+		zeros := 0
+		for _, b := range sum {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= c.Difficulty {
+			return s
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	config.RegistrationMode = config.RegistrationOpen
+	s := New(storage.NewMemoryStore())
+	s.ProofOfWork = pow.NewIssuer([]byte("test-pow-secret"), 8, 8, time.Minute, 0)
+	config.PowLoginFailures = 3
+	defer func() { config.PowLoginFailures = 0 }()
+
+	if rec := post(s.RegisterHandler, RegisterReq{Username: "alice1", Password: testPassword}); errorCode(rec) != "pow_required" {
+		t.Fatalf("registering without a solution: %d %s", rec.Code, rec.Body)
+	}
+	c, err := s.ProofOfWork.Issue(pow.PurposeRegister)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a rejected registration doesn't spend the challenge
+	req := RegisterReq{Username: "alice1", Password: "password", PowChallenge: c.Token, PowSolution: solve(c)}
+	if rec := post(s.RegisterHandler, req); errorCode(rec) != "weak_password" {
+		t.Fatalf("registering with a weak password: %d %s", rec.Code, rec.Body)
+	}
+	req.Password = testPassword
+	if rec := post(s.RegisterHandler, req); rec.Code != http.StatusCreated {
+		t.Fatalf("registering with a solution: %d %s", rec.Code, rec.Body)
+	}
+	req.Username = "bobby1"
+	if rec := post(s.RegisterHandler, req); errorCode(rec) != "invalid_pow" {
+		t.Errorf("registering with a spent challenge: %d %s", rec.Code, rec.Body)
+	}
+
+	// logins need a solution after repeated failures only
+	for i := 0; i < config.PowLoginFailures; i++ {
+		if rec := post(s.LoginHandler, LoginReq{Username: "alice1", Password: "wrong password"}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: %d %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	if rec := post(s.LoginHandler, LoginReq{Username: "alice1", Password: testPassword}); errorCode(rec) != "pow_required" {
+		t.Fatalf("login after failures without a solution: %d %s", rec.Code, rec.Body)
+	}
+	c, err = s.ProofOfWork.Issue(pow.PurposeLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := LoginReq{Username: "alice1", Password: testPassword, PowChallenge: c.Token, PowSolution: solve(c)}
+	if rec := post(s.LoginHandler, login); rec.Code != http.StatusOK {
+		t.Errorf("login after failures with a solution: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProofOfWorkForwardedFor(t *testing.T) {
+	config.RegistrationMode = config.RegistrationOpen
+	s := New(storage.NewMemoryStore())
+	s.ProofOfWork = pow.NewIssuer([]byte("test-pow-secret"), 8, 8, time.Minute, 0)
+	config.PowLoginFailures = 3
+	defer func() { config.PowLoginFailures = 0 }()
+
+	// without a trusted proxy, a new X-Forwarded-For per attempt changes nothing
+	for i := 0; i <= config.PowLoginFailures; i++ {
+		b, _ := json.Marshal(LoginReq{Username: "alice1", Password: "wrong password"})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		rec := httptest.NewRecorder()
+		s.LoginHandler(rec, req)
+		if i < config.PowLoginFailures && rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: %d %s", i+1, rec.Code, rec.Body)
+		}
+		if i == config.PowLoginFailures && errorCode(rec) != "pow_required" {
+			t.Errorf("login after failures from new forwarded addresses: %d %s", rec.Code, rec.Body)
+		}
+	}
+	if n := len(s.loginFailures.counts); n != 1 {
+		t.Errorf("tracked failures for %d clients, want 1", n)
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SessionResp struct {
+	ID        string `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	Created   int64  `json:"created"`
+	LastSeen  int64  `json:"last_seen"`
+	Expires   int64  `json:"expires"`
+	Current   bool   `json:"current"`
+}
+
+// ListSessionsHandler returns the caller's active sessions.
+func (s *Service) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, err := s.Authenticate(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	sessions, err := s.store.ListSessions(id.Username, time.Now().Unix())
+	if err != nil {
+		log.Printf("ListSessions error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch sessions")
+		return
+	}
+	resp := make([]SessionResp, 0, len(sessions))
+	for _, sess := range sessions {
+		resp = append(resp, SessionResp{
+			ID:        sess.ID,
+			UserAgent: sess.UserAgent,
+			IP:        sess.IP,
+			Created:   sess.CreatedAt,
+			LastSeen:  sess.LastSeen,
+			Expires:   sess.ExpiresAt,
+			Current:   sess.ID == id.SessionID,
+		})
+	}
+	api.JSON(w, http.StatusOK, resp)
+}
+
+// RevokeSessionHandler revokes one of the caller's sessions, identified by the
+// last path segment of /account/sessions/{id}.
+func (s *Service) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	caller, err := s.Authenticate(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/account/sessions/")
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid session id")
+		return
+	}
+	err = s.store.RevokeSession(id, caller.Username, time.Now().Unix())
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		log.Printf("RevokeSession error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to revoke session")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
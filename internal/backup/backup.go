@@ -0,0 +1,278 @@
+// Package backup takes online snapshots of the database and packs them into
+// optionally compressed and encrypted archives.
+//
+// An archive is either a bare SQLite file (neither compressed nor encrypted)
+// or the header
+//
+//	"SCRYBAK1" | flags (1 byte) | salt (16 bytes, encrypted archives only)
+//
+// followed by the database, gzipped if flagGzip is set and then encrypted
+// with utils.StreamCipher if flagEncrypted is set. The encryption key is
+// derived from the backup key and the salt, and the header is authenticated
+// as associated data.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"sync"
+	"time"
+)
+
+const magic = "SCRYBAK1"
+
+const (
+	flagGzip      = 1 << 0
+	flagEncrypted = 1 << 1
+)
+
+const saltSize = 16
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+var (
+	ErrBusy        = errors.New("a backup is already running")
+	ErrUnsupported = errors.New("the database driver does not support online backups")
+	ErrKeyRequired = errors.New("archive is encrypted and no backup key is configured")
+	ErrNotArchive  = errors.New("not a scrypts backup")
+)
+
+// Options controls how archives are written.
+type Options struct {
+	Compress bool
+	Key      []byte // backup key; nil leaves the archive unencrypted
+}
+
+// archiveKey derives the AES-256 key of one archive, so that every archive
+// gets its own key and the stream nonces can't repeat across archives.
+func archiveKey(key, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("scrypts backup archive"))
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+func streamCipher(key, salt []byte) (*utils.StreamCipher, error) {
+	return utils.NewStreamCipher(archiveKey(key, salt), make([]byte, utils.StreamPrefixSize))
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	// innermost writer first
+	for i := len(cs) - 1; i >= 0; i-- {
+		if err := cs[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode writes an archive of the database read from r to w.
+func Encode(w io.Writer, r io.Reader, opts Options) error {
+	if !opts.Compress && opts.Key == nil {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	header := []byte(magic)
+	var flags byte
+	if opts.Compress {
+		flags |= flagGzip
+	}
+	if opts.Key != nil {
+		flags |= flagEncrypted
+	}
+	header = append(header, flags)
+
+	out := w
+	var cs closers
+	if opts.Key != nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		header = append(header, salt...)
+		c, err := streamCipher(opts.Key, salt)
+		if err != nil {
+			return err
+		}
+		sw := utils.NewStreamWriter(w, c, header)
+		cs = append(cs, sw)
+		out = sw
+	}
+	if opts.Compress {
+		gz := gzip.NewWriter(out)
+		cs = append(cs, gz)
+		out = gz
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	return cs.Close()
+}
+
+// Decode returns a reader of the database in the archive read from r. Errors
+// from corrupted or tampered archives surface from the returned reader.
+func Decode(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(sqliteMagic))
+	if err != nil {
+		return nil, ErrNotArchive
+	}
+	if bytes.Equal(head, sqliteMagic) {
+		return br, nil
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, ErrNotArchive
+	}
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	flags := header[len(magic)]
+	if flags&^(flagGzip|flagEncrypted) != 0 {
+		return nil, fmt.Errorf("unknown archive flags %#x", flags)
+	}
+	var in io.Reader = br
+	if flags&flagEncrypted != 0 {
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(br, salt); err != nil {
+			return nil, err
+		}
+		header = append(header, salt...)
+		c, err := streamCipher(key, salt)
+		if err != nil {
+			return nil, err
+		}
+		in = utils.NewStreamReader(br, c, header)
+	}
+	if flags&flagGzip != 0 {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing archive: %w", err)
+		}
+		in = gz
+	}
+	return in, nil
+}
+
+// FileName returns the name of an archive created at t.
+func FileName(t time.Time, opts Options) string {
+	name := "scrypts-" + t.UTC().Format("20060102T150405.000Z") + ".db"
+	if opts.Compress {
+		name += ".gz"
+	}
+	if opts.Key != nil {
+		name += ".enc"
+	}
+	return name
+}
+
+var running sync.Mutex
+
+// Create snapshots the store into a new archive at dst and returns its size.
+func Create(store storage.Store, dst string, opts Options) (int64, error) {
+	snap, ok := store.(storage.Snapshotter)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	if !running.TryLock() {
+		return 0, ErrBusy
+	}
+	defer running.Unlock()
+
+	if _, err := os.Stat(dst); err == nil {
+		return 0, fmt.Errorf("%s already exists", dst)
+	}
+	tmp := dst + ".snapshot"
+	os.Remove(tmp)
+	if err := snap.Snapshot(tmp); err != nil {
+		return 0, fmt.Errorf("snapshot: %w", err)
+	}
+	defer os.Remove(tmp)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		return 0, err
+	}
+
+	if !opts.Compress && opts.Key == nil {
+		if err := os.Rename(tmp, dst); err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(dst)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+
+	in, err := os.Open(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	err = Encode(out, in, opts)
+	if err == nil {
+		err = out.Sync()
+	}
+	var size int64
+	if err == nil {
+		size, err = out.Seek(0, io.SeekCurrent)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, err
+	}
+	return size, nil
+}
+
+// Info describes an archive written by Config.Run.
+type Info struct {
+	Name    string
+	Path    string
+	Size    int64
+	Created time.Time
+}
+
+// Config is where and how backups are written.
+type Config struct {
+	Dir string
+	Options
+}
+
+// Run creates a new archive of the store in the backup directory.
+func (c *Config) Run(store storage.Store) (Info, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return Info{}, err
+	}
+	now := time.Now()
+	info := Info{Name: FileName(now, c.Options), Created: now}
+	info.Path = filepath.Join(c.Dir, info.Name)
+	size, err := Create(store, info.Path, c.Options)
+	info.Size = size
+	return info, err
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"scrypts/internal/storage"
+	"strings"
+	"time"
+)
+
+// Verify checks that the SQLite database at path is intact and holds a
+// schema this binary knows how to run.
+func Verify(path string) error {
+	store, err := storage.OpenSQLite(path, storage.Options{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	problems, err := store.IntegrityCheck()
+	if err != nil {
+		return fmt.Errorf("integrity check: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	status, err := store.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	applied := 0
+	for _, st := range status {
+		switch {
+		case st.Missing:
+			return fmt.Errorf("backup has migration %04d, which this version doesn't know; restore with a newer scrypts", st.Version)
+		case st.Mismatch:
+			return fmt.Errorf("backup has a modified migration %04d", st.Version)
+		case st.AppliedAt != 0:
+			applied++
+		}
+	}
+	if applied == 0 {
+		return errors.New("backup doesn't contain a scrypts database")
+	}
+	return nil
+}
+
+// Restore replaces the SQLite database at dbPath with the one in archive.
+// The archive is unpacked next to dbPath and verified before anything is
+// touched; the current database is then kept as dbPath.pre-restore-<time>.
+// An existing database is only replaced when force is set. The server must
+// not be running.
+func Restore(archive, dbPath string, key []byte, force bool) error {
+	if _, err := os.Stat(dbPath); err == nil && !force {
+		return fmt.Errorf("%s exists; use -force to replace it", dbPath)
+	}
+
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	db, err := Decode(in, key)
+	if err != nil {
+		return err
+	}
+
+	tmp := dbPath + ".restore"
+	removeDB(tmp)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, db)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = Verify(tmp)
+	}
+	if err != nil {
+		removeDB(tmp)
+		return fmt.Errorf("archive rejected: %w", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		old := dbPath + ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		// the WAL belongs to the old database, so it moves with it
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(dbPath+suffix, old+suffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+func removeDB(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+}
@@ -0,0 +1,229 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"scrypts/internal/objstore"
+	"scrypts/internal/storage"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotTime returns when the archive with the given file name was taken,
+// for names produced by FileName.
+func SnapshotTime(name string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(path.Base(name), "scrypts-")
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ext, ok := strings.Cut(rest, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	// the stamp has a dot of its own before the milliseconds
+	ms, ext, _ := strings.Cut(ext, ".")
+	stamp += "." + ms
+	switch ext {
+	case "db", "db.gz", "db.enc", "db.gz.enc":
+	default:
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102T150405.000Z", stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Retention decides which archives to keep: the newest archive of each of
+// the last Daily days and of each of the last Weekly ISO weeks that have one.
+// Archives kept for either reason survive. A zero Retention keeps everything.
+type Retention struct {
+	Daily  int
+	Weekly int
+}
+
+// Expired returns the names that r no longer keeps. Names that aren't
+// archives are left alone.
+func (r Retention) Expired(names []string) []string {
+	if r.Daily <= 0 && r.Weekly <= 0 {
+		return nil
+	}
+	type snapshot struct {
+		name string
+		t    time.Time
+	}
+	var snaps []snapshot
+	for _, n := range names {
+		if t, ok := SnapshotTime(n); ok {
+			snaps = append(snaps, snapshot{n, t})
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].t.After(snaps[j].t) })
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	var expired []string
+	for i, s := range snaps {
+		keep := i == 0 // never delete the latest backup
+		day := s.t.UTC().Format("2006-01-02")
+		if !days[day] && len(days) < r.Daily {
+			days[day] = true
+			keep = true
+		}
+		y, w := s.t.UTC().ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", y, w)
+		if !weeks[week] && len(weeks) < r.Weekly {
+			weeks[week] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, s.name)
+		}
+	}
+	return expired
+}
+
+// Scheduler takes a backup every day at a fixed time, copies it to a remote
+// bucket and prunes old archives locally and remotely.
+type Scheduler struct {
+	Store     storage.Store
+	Config    *Config
+	Remote    objstore.Bucket // nil keeps archives in Config.Dir only
+	Prefix    string          // key prefix of archives in Remote
+	At        time.Duration   // time of day, UTC
+	Retention Retention
+}
+
+// Start runs the scheduler in the background for the life of the process.
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			time.Sleep(time.Until(s.next(time.Now())))
+			if err := s.RunOnce(); err != nil {
+				log.Printf("scheduled backup error: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) next(now time.Time) time.Time {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(s.At)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// RunOnce takes a backup, uploads it and applies the retention policy.
+func (s *Scheduler) RunOnce() error {
+	info, err := s.Config.Run(s.Store)
+	if err != nil {
+		return err
+	}
+	log.Printf("backup: wrote %s (%d bytes)", info.Name, info.Size)
+	if s.Remote != nil {
+		if err := s.upload(info); err != nil {
+			return fmt.Errorf("uploading %s: %w", info.Name, err)
+		}
+		log.Printf("backup: uploaded %s", info.Name)
+		if err := s.pruneRemote(); err != nil {
+			return err
+		}
+	}
+	return s.pruneLocal()
+}
+
+func (s *Scheduler) upload(info Info) error {
+	f, err := os.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Remote.Put(s.Prefix+info.Name, f, info.Size)
+}
+
+func (s *Scheduler) pruneRemote() error {
+	objs, err := s.Remote.List(s.Prefix)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(objs))
+	for i, o := range objs {
+		keys[i] = o.Key
+	}
+	for _, key := range s.Retention.Expired(keys) {
+		if err := s.Remote.Delete(key); err != nil {
+			return err
+		}
+		log.Printf("backup: deleted expired %s from remote", key)
+	}
+	return nil
+}
+
+func (s *Scheduler) pruneLocal() error {
+	entries, err := os.ReadDir(s.Config.Dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	for _, name := range s.Retention.Expired(names) {
+		if err := os.Remove(filepath.Join(s.Config.Dir, name)); err != nil {
+			return err
+		}
+		log.Printf("backup: deleted expired %s", name)
+	}
+	return nil
+}
+
+// ListRemote returns the archives in bucket under prefix, oldest first.
+func ListRemote(bucket objstore.Bucket, prefix string) ([]objstore.Object, error) {
+	objs, err := bucket.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var res []objstore.Object
+	for _, o := range objs {
+		if _, ok := SnapshotTime(o.Key); ok {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		ti, _ := SnapshotTime(res[i].Key)
+		tj, _ := SnapshotTime(res[j].Key)
+		return ti.Before(tj)
+	})
+	return res, nil
+}
+
+// Fetch downloads the object at key to the new file dst.
+func Fetch(bucket objstore.Bucket, key, dst string) error {
+	r, err := bucket.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
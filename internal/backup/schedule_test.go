@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"scrypts/internal/objstore"
+	"scrypts/internal/objstore/objstoretest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archive returns the name of an archive taken at the RFC 3339 time s.
+func archive(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return FileName(t, Options{})
+}
+
+func TestRetentionExpired(t *testing.T) {
+	// Friday 2026-10-09 is in ISO week 41, Monday 2026-10-12 starts week 42;
+	// 2026-12-31 and 2027-01-01 are both in 2026-W53
+	tests := []struct {
+		name      string
+		retention Retention
+		archives  []string
+		expired   []string
+	}{
+		{
+			name:      "zero keeps everything",
+			retention: Retention{},
+			archives:  []string{"2026-10-01T03:00:00Z", "2026-10-02T03:00:00Z"},
+		},
+		{
+			name:      "one day keeps the newest",
+			retention: Retention{Daily: 1},
+			archives:  []string{"2026-10-12T03:00:00Z", "2026-10-12T15:00:00Z", "2026-10-11T03:00:00Z"},
+			expired:   []string{"2026-10-12T03:00:00Z", "2026-10-11T03:00:00Z"},
+		},
+		{
+			name:      "newest of each day",
+			retention: Retention{Daily: 2},
+			archives: []string{
+				"2026-10-12T03:00:00Z", "2026-10-12T15:00:00Z",
+				"2026-10-11T03:00:00Z", "2026-10-11T15:00:00Z",
+				"2026-10-10T15:00:00Z",
+			},
+			expired: []string{"2026-10-12T03:00:00Z", "2026-10-11T03:00:00Z", "2026-10-10T15:00:00Z"},
+		},
+		{
+			name:      "days without archives don't count",
+			retention: Retention{Daily: 2},
+			archives:  []string{"2026-10-12T03:00:00Z", "2026-10-02T03:00:00Z", "2026-10-01T03:00:00Z"},
+			expired:   []string{"2026-10-01T03:00:00Z"},
+		},
+		{
+			name:      "newest of each ISO week",
+			retention: Retention{Weekly: 2},
+			archives: []string{
+				"2026-10-13T03:00:00Z", "2026-10-12T03:00:00Z",
+				"2026-10-09T03:00:00Z", "2026-10-05T03:00:00Z",
+				"2026-10-04T03:00:00Z",
+			},
+			expired: []string{"2026-10-12T03:00:00Z", "2026-10-05T03:00:00Z", "2026-10-04T03:00:00Z"},
+		},
+		{
+			name:      "ISO weeks span the new year",
+			retention: Retention{Weekly: 1},
+			archives:  []string{"2027-01-01T03:00:00Z", "2026-12-31T03:00:00Z"},
+			expired:   []string{"2026-12-31T03:00:00Z"},
+		},
+		{
+			name:      "days and weeks together",
+			retention: Retention{Daily: 2, Weekly: 3},
+			archives: []string{
+				"2026-10-13T03:00:00Z", "2026-10-12T03:00:00Z", "2026-10-10T03:00:00Z",
+				"2026-10-09T03:00:00Z", "2026-10-03T03:00:00Z", "2026-09-27T03:00:00Z",
+				"2026-09-20T03:00:00Z",
+			},
+			// the 13th and 12th by day, the 13th, 10th and 3rd by week
+			expired: []string{"2026-10-09T03:00:00Z", "2026-09-27T03:00:00Z", "2026-09-20T03:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names, want []string
+			for _, a := range tt.archives {
+				names = append(names, archive(a))
+			}
+			for _, a := range tt.expired {
+				want = append(want, archive(a))
+			}
+			got := tt.retention.Expired(names)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expired = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRetentionIgnoresOtherNames(t *testing.T) {
+	names := []string{
+		"notes.txt",
+		"scrypts-latest.db",
+		"backups/" + archive("2026-10-12T03:00:00Z"),
+		"backups/" + FileName(time.Date(2026, 10, 11, 3, 0, 0, 0, time.UTC), Options{Compress: true, Key: []byte("k")}),
+	}
+	got := Retention{Daily: 1}.Expired(names)
+	if len(got) != 1 || got[0] != names[3] {
+		t.Errorf("Expired = %v, want only %s", got, names[3])
+	}
+}
+
+func TestRemoteDir(t *testing.T) {
+	testRemote(t, objstore.Dir{Root: t.TempDir()})
+}
+
+func TestRemoteS3(t *testing.T) {
+	testRemote(t, objstoretest.Bucket(t))
+}
+
+// testRemote lists and fetches archives of bucket, under a random prefix.
+func testRemote(t *testing.T, bucket objstore.Bucket) {
+	prefix := objstoretest.Prefix()
+	// uploaded out of order, with an object that isn't an archive
+	keys := []string{
+		prefix + archive("2026-10-12T03:00:00Z"),
+		prefix + archive("2026-10-10T03:00:00Z"),
+		prefix + archive("2026-10-11T03:00:00Z"),
+		prefix + "README",
+	}
+	for _, key := range keys {
+		if err := bucket.Put(key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatal(err)
+		}
+		defer bucket.Delete(key)
+	}
+
+	objs, err := ListRemote(bucket, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, o := range objs {
+		got = append(got, o.Key)
+	}
+	if want := []string{keys[1], keys[2], keys[0]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRemote = %v, want %v", got, want)
+	}
+
+	dst := filepath.Join(t.TempDir(), "fetched.db")
+	if err := Fetch(bucket, keys[0], dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != keys[0] {
+		t.Errorf("fetched %q, %v", data, err)
+	}
+	if err := Fetch(bucket, keys[1], dst); err == nil {
+		t.Error("Fetch overwrote an existing file")
+	}
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	if err := Fetch(bucket, prefix+"missing", missing); err != objstore.ErrNotExist {
+		t.Errorf("Fetch of a missing archive: %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(missing); err == nil {
+		t.Error("Fetch of a missing archive left a file")
+	}
+}
@@ -0,0 +1,170 @@
+// Package blob keeps large opaque contents, such as the encrypted files
+// attached to notes, outside the database.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned by Open for missing blobs.
+var ErrNotExist = errors.New("blob does not exist")
+
+// Info describes a stored blob.
+type Info struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Store keeps blobs under their keys: the hex SHA-256 of their contents,
+// so that a blob never changes and storing the same contents twice keeps
+// them once. Blobs stored before that are under other keys of letters,
+// digits and dashes.
+type Store interface {
+	// Put stores what r yields and returns its key and size. Storing a
+	// blob that is already there marks it as modified. Nothing is stored
+	// when it fails.
+	Put(r io.Reader) (key string, size int64, err error)
+	// Open opens a blob for reading at any offset.
+	Open(key string) (Reader, error)
+	// Delete removes a blob; deleting a missing blob is not an error.
+	Delete(key string) error
+	// List returns all of the blobs.
+	List() ([]Info, error)
+}
+
+// Reader reads a blob.
+type Reader interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// Dir is a Store in a local directory. Blobs are files spread over
+// subdirectories named after the first two characters of their keys.
+type Dir struct {
+	Root string
+}
+
+func validKey(key string) bool {
+	if len(key) < 3 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// hashedKey returns the key of the contents h has hashed.
+func hashedKey(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d Dir) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", errors.New("invalid blob key " + key)
+	}
+	return filepath.Join(d.Root, key[:2], key), nil
+}
+
+func (d Dir) Put(r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(d.Root, 0o700); err != nil {
+		return "", 0, err
+	}
+	// the key is only known once it is all written
+	tmp, err := os.CreateTemp(d.Root, ".put-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	key := hashedKey(h)
+	p, _ := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return "", 0, err
+	}
+	return key, n, os.Rename(tmp.Name(), p)
+}
+
+type file struct {
+	*os.File
+	size int64
+}
+
+func (f file) Size() int64 { return f.size }
+
+func (d Dir) Open(key string) (Reader, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return file{f, info.Size()}, nil
+}
+
+func (d Dir) Delete(key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// the subdirectory goes once it is empty
+	os.Remove(filepath.Dir(p))
+	return nil
+}
+
+func (d Dir) List() ([]Info, error) {
+	var res []Info
+	err := filepath.WalkDir(d.Root, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if p == d.Root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".put-") || !validKey(e.Name()) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		res = append(res, Info{Key: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	return res, err
+}
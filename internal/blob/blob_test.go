@@ -0,0 +1,113 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"scrypts/internal/objstore/objstoretest"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	testStore(t, Dir{Root: t.TempDir()})
+}
+
+func TestS3(t *testing.T) {
+	bucket := objstoretest.Bucket(t)
+	s := S3{Bucket: bucket, Prefix: objstoretest.Prefix()}
+	testStore(t, s)
+
+	// objects under the prefix that aren't laid out like blobs are ignored
+	if err := bucket.Put(s.Prefix+"stray", bytes.NewReader(nil), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Delete(s.Prefix + "stray")
+	if infos, err := s.List(); err != nil || len(infos) != 0 {
+		t.Errorf("List = %v, %v, want nothing", infos, err)
+	}
+}
+
+// testStore runs the same checks against any Store, which must be empty.
+func testStore(t *testing.T, s Store) {
+	// big enough for reads to be spread over several requests
+	data := make([]byte, 3<<20+17)
+	rand.Read(data)
+	sum := sha256.Sum256(data)
+
+	key, size, err := s.Put(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Delete(key)
+	if key != hex.EncodeToString(sum[:]) || size != int64(len(data)) {
+		t.Errorf("Put = %s, %d", key, size)
+	}
+	if again, _, err := s.Put(bytes.NewReader(data)); err != nil || again != key {
+		t.Errorf("Put of the same contents = %s, %v", again, err)
+	}
+
+	r, err := s.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != size {
+		t.Errorf("Size = %d, want %d", r.Size(), size)
+	}
+	// in order, then backwards, then past the end
+	got, err := io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("reading in order doesn't yield the contents")
+	}
+	for _, off := range []int64{2 << 20, 1 << 20, 5, 0} {
+		p := make([]byte, 4096)
+		if n, err := r.ReadAt(p, off); err != nil || n != len(p) || !bytes.Equal(p, data[off:off+4096]) {
+			t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+		}
+	}
+	p := make([]byte, 100)
+	if n, err := r.ReadAt(p, size-10); n != 10 || err != io.EOF || !bytes.Equal(p[:n], data[size-10:]) {
+		t.Errorf("ReadAt across the end = %d, %v", n, err)
+	}
+	if n, err := r.ReadAt(p, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v", n, err)
+	}
+
+	small, _, err := s.Put(bytes.NewReader([]byte("small")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Delete(small)
+	infos, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{}
+	for _, info := range infos {
+		sizes[info.Key] = info.Size
+		if info.Modified.IsZero() {
+			t.Errorf("blob %s has no modification time", info.Key)
+		}
+	}
+	if len(sizes) != 2 || sizes[key] != size || sizes[small] != 5 {
+		t.Errorf("List = %v", infos)
+	}
+
+	if err := s.Delete(small); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(small); err != ErrNotExist {
+		t.Errorf("Open of a deleted blob: %v, want ErrNotExist", err)
+	}
+	if err := s.Delete(small); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+	if _, err := s.Open("../etc"); err == nil {
+		t.Error("Open accepted an invalid key")
+	}
+}
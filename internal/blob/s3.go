@@ -0,0 +1,157 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"scrypts/internal/objstore"
+	"sync"
+)
+
+// S3 is a Store in an S3-compatible bucket, such as AWS S3 or MinIO. Blobs
+// are objects under Prefix laid out as in a Dir, so that a directory can be
+// copied into a bucket as it is.
+type S3 struct {
+	Bucket *objstore.S3
+	Prefix string // e.g. "attachments/"
+}
+
+func (s S3) object(key string) (string, error) {
+	if !validKey(key) {
+		return "", errors.New("invalid blob key " + key)
+	}
+	return s.Prefix + key[:2] + "/" + key, nil
+}
+
+func (s S3) Put(r io.Reader) (string, int64, error) {
+	// the key and the size must be known before the upload starts, so the
+	// blob is spooled to a temporary file first
+	tmp, err := os.CreateTemp("", "scrypts-blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	key := hashedKey(h)
+	obj, _ := s.object(key)
+	// uploading a blob that is already there again refreshes its
+	// modification time, which keeps it from being collected meanwhile
+	if err := s.Bucket.Put(obj, tmp, n); err != nil {
+		return "", 0, err
+	}
+	return key, n, nil
+}
+
+func (s S3) Open(key string) (Reader, error) {
+	obj, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.Bucket.Stat(obj)
+	if err == objstore.ErrNotExist {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return &s3Reader{bucket: s.Bucket, key: obj, size: info.Size}, nil
+}
+
+func (s S3) Delete(key string) error {
+	obj, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	return s.Bucket.Delete(obj)
+}
+
+func (s S3) List() ([]Info, error) {
+	objs, err := s.Bucket.List(s.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	var res []Info
+	for _, o := range objs {
+		key := path.Base(o.Key)
+		if !validKey(key) || o.Key != s.Prefix+key[:2]+"/"+key {
+			continue
+		}
+		res = append(res, Info{Key: key, Size: o.Size, Modified: o.Modified})
+	}
+	return res, nil
+}
+
+// s3Reader reads an object through one ranged GET for as long as it is read
+// in order, and starts another one where a read out of order begins.
+type s3Reader struct {
+	bucket *objstore.S3
+	key    string
+	size   int64
+
+	mu   sync.Mutex
+	body io.ReadCloser
+	pos  int64 // where body is at
+}
+
+func (r *s3Reader) Size() int64 { return r.size }
+
+func (r *s3Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("blob: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if rest := r.size - off; int64(want) > rest {
+		p = p[:rest]
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.body == nil || r.pos != off {
+		if r.body != nil {
+			r.body.Close()
+		}
+		body, err := r.bucket.GetRange(r.key, off, r.size-off)
+		if err != nil {
+			r.body = nil
+			return 0, err
+		}
+		r.body, r.pos = body, off
+	}
+	n, err := io.ReadFull(r.body, p)
+	r.pos += int64(n)
+	if err != nil {
+		// the object is shorter than it was, or the connection broke
+		r.body.Close()
+		r.body = nil
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *s3Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -3,12 +3,187 @@ package config
 import (
 	"log"
 	"math"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var JwtSecret []byte
 var MasterKey []byte
 
+// AdminUsers lists accounts that are promoted to the admin role at startup.
+var AdminUsers []string
+
+// Registration policies accepted in SCRYPTS_REGISTRATION.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+// RegistrationMode controls who may use /register.
+var RegistrationMode = RegistrationOpen
+
+// Proof-of-work settings. PowDifficulty is the base number of leading zero
+// bits a solution must have; zero disables proof of work.
+var (
+	PowDifficulty    int
+	PowMaxDifficulty int
+	PowLoginFailures int
+)
+
+// TrustedProxies are the addresses of the reverse proxies in front of the
+// server. X-Forwarded-For is only believed in requests that come from one
+// of them; otherwise clients are told apart by the address they connect from.
+var TrustedProxies []*net.IPNet
+
+// Password policy settings. PasswordMinScore is the minimum strength
+// estimate (0-4); PwnedPasswordsPath optionally points at a local HIBP dataset.
+var (
+	PasswordMinLength  int
+	PasswordMinScore   int
+	PwnedPasswordsPath string
+)
+
+// Database drivers accepted in SCRYPTS_DB_DRIVER.
+const (
+	DBSQLite   = "sqlite"
+	DBPostgres = "postgres"
+	DBMemory   = "memory"
+)
+
+// DBDriver selects the storage backend and DBDSN tells it where the data
+// lives: a file path for SQLite, a connection string for PostgreSQL.
+var (
+	DBDriver = DBSQLite
+	DBDSN    = "./scrypts.db"
+)
+
+// Connection tuning. DBBusyTimeout (milliseconds) and DBSynchronous apply to
+// SQLite only; DBReadConns sizes the read-only pool and DBWriteConns the
+// write pool (PostgreSQL only, SQLite always has a single writer).
+var (
+	DBBusyTimeout int
+	DBSynchronous string
+	DBReadConns   int
+	DBWriteConns  int
+)
+
+// Backup settings. Archives are encrypted when BackupKey is set; it must
+// differ from MASTER_KEY so that a leaked archive and a leaked master key
+// aren't the same secret.
+var (
+	BackupDir      string
+	BackupKey      []byte
+	BackupCompress bool
+)
+
+// Backup schedule. When BackupScheduled is set the server takes a backup
+// every day at BackupAt past midnight UTC and prunes archives down to the
+// newest of each of the last BackupKeepDaily days and BackupKeepWeekly weeks.
+var (
+	BackupScheduled  bool
+	BackupAt         time.Duration
+	BackupKeepDaily  int
+	BackupKeepWeekly int
+	BackupS3Prefix   string
+)
+
+// S3-compatible object storage for off-box copies. Unused while S3Endpoint
+// is empty.
+var (
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+)
+
+// Continuous replication of the SQLite WAL. ReplicaTarget is "s3" for the
+// S3-compatible bucket, a local directory, or empty when replication is off.
+var (
+	ReplicaTarget           string
+	ReplicaPrefix           string
+	ReplicaSyncInterval     time.Duration
+	ReplicaSnapshotInterval time.Duration
+	ReplicaRetention        time.Duration
+)
+
+// LegacyNoteRoutes keeps the original PUT and DELETE /notes routes, which
+// take the note id in the request body, for clients not yet moved to
+// /notes/{id}.
+var LegacyNoteRoutes bool
+
+// Server-wide limits on the earlier revisions kept of each note: at most
+// RevisionsKeep of them, for at most RevisionsDays days. Zero means no
+// limit. Users can choose to keep less, not more.
+var (
+	RevisionsKeep int
+	RevisionsDays int
+)
+
+// TrashDays is how long deleted notes stay in the trash before they are
+// purged for good. Zero keeps them until the trash is emptied.
+var TrashDays int
+
+// LinkMaxDays is the longest a public link to a note can last; zero turns
+// links off.
+var LinkMaxDays int
+
+// AttachmentsStore is where the encrypted files attached to notes are
+// kept: "dir" for AttachmentsDir, or "s3" for the S3-compatible bucket under
+// AttachmentsS3Prefix. MaxAttachmentMB bounds their size; zero turns
+// attachments off.
+var (
+	AttachmentsStore    string
+	AttachmentsDir      string
+	AttachmentsS3Prefix string
+	MaxAttachmentMB     int
+)
+
+// SearchMode selects the index behind GET /notes/search: the blind keyword
+// index, or SQLite's full-text index with ranked, phrase and prefix
+// queries. Switching needs `scrypts reindex`.
+var SearchMode string
+
+const (
+	SearchBlind = "blind"
+	SearchFTS   = "fts"
+)
+
+// AutoMigrate applies pending schema migrations at startup. When disabled the
+// server refuses to start until `scrypts migrate up` has been run.
+var AutoMigrate = true
+
+// envInt reads a non-negative integer from the environment, exiting on garbage.
+func envInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("FATAL: %s must be a non-negative integer (got %q)", name, v)
+	}
+	return n
+}
+
+// envBool reads a boolean from the environment, exiting on garbage.
+func envBool(name string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("FATAL: %s must be true or false (got %q)", name, v)
+	}
+	return b
+}
+
 // calculateEntropy measures the Shannon entropy of a byte slice
 func calculateEntropy(data []byte) float64 {
 	if len(data) == 0 {
@@ -58,5 +233,203 @@ func Init() {
 		log.Printf("WARNING: MASTER_KEY has low entropy (%.2f bits/byte). Use a stronger secret.", masterEntropy)
 	}
 
+	InitDatabase()
+	if BackupKey != nil && string(BackupKey) == string(MasterKey) {
+		log.Fatal("FATAL: SCRYPTS_BACKUP_KEY must differ from MASTER_KEY")
+	}
+
+	AdminUsers = nil
+	for _, u := range strings.Split(os.Getenv("SCRYPTS_ADMINS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			AdminUsers = append(AdminUsers, u)
+		}
+	}
+
+	TrustedProxies = nil
+	for _, p := range strings.Split(os.Getenv("SCRYPTS_TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Fatalf("FATAL: SCRYPTS_TRUSTED_PROXIES must list IP addresses or CIDR ranges (got %q)", p)
+		}
+		TrustedProxies = append(TrustedProxies, n)
+	}
+
+	RegistrationMode = RegistrationOpen
+	if m := strings.ToLower(strings.TrimSpace(os.Getenv("SCRYPTS_REGISTRATION"))); m != "" {
+		switch m {
+		case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+			RegistrationMode = m
+		default:
+			log.Fatalf("FATAL: SCRYPTS_REGISTRATION must be one of open, invite or closed (got %q)", m)
+		}
+	}
+
+	PowDifficulty = envInt("SCRYPTS_POW_DIFFICULTY", 0)
+	PowMaxDifficulty = envInt("SCRYPTS_POW_MAX_DIFFICULTY", PowDifficulty+8)
+	PowLoginFailures = envInt("SCRYPTS_POW_LOGIN_FAILURES", 3)
+	if PowDifficulty > 32 || PowMaxDifficulty > 32 {
+		log.Fatal("FATAL: proof-of-work difficulty above 32 bits is not solvable in a browser")
+	}
+
+	PasswordMinLength = envInt("SCRYPTS_PASSWORD_MIN_LENGTH", 8)
+	PasswordMinScore = envInt("SCRYPTS_PASSWORD_MIN_SCORE", 3)
+	if PasswordMinScore > 4 {
+		log.Fatal("FATAL: SCRYPTS_PASSWORD_MIN_SCORE must be between 0 and 4")
+	}
+	PwnedPasswordsPath = os.Getenv("SCRYPTS_PWNED_PASSWORDS")
+
+	LegacyNoteRoutes = envBool("SCRYPTS_LEGACY_NOTE_ROUTES", false)
+
+	RevisionsKeep = envInt("SCRYPTS_REVISIONS_KEEP", 50)
+	RevisionsDays = envInt("SCRYPTS_REVISIONS_DAYS", 0)
+	TrashDays = envInt("SCRYPTS_TRASH_DAYS", 30)
+	LinkMaxDays = envInt("SCRYPTS_LINK_MAX_DAYS", 30)
+
+	AttachmentsDir = os.Getenv("SCRYPTS_ATTACHMENTS_DIR")
+	if AttachmentsDir == "" {
+		AttachmentsDir = "./attachments"
+	}
+	AttachmentsS3Prefix = os.Getenv("SCRYPTS_ATTACHMENTS_S3_PREFIX")
+	if AttachmentsS3Prefix == "" {
+		AttachmentsS3Prefix = "attachments/"
+	}
+	MaxAttachmentMB = envInt("SCRYPTS_MAX_ATTACHMENT_MB", 100)
+	AttachmentsStore = strings.ToLower(strings.TrimSpace(os.Getenv("SCRYPTS_ATTACHMENTS_STORE")))
+	switch AttachmentsStore {
+	case "":
+		AttachmentsStore = "dir"
+	case "dir":
+	case "s3":
+		if S3Endpoint == "" && MaxAttachmentMB > 0 {
+			log.Fatal("FATAL: SCRYPTS_ATTACHMENTS_STORE=s3 needs SCRYPTS_S3_ENDPOINT")
+		}
+	default:
+		log.Fatalf("FATAL: SCRYPTS_ATTACHMENTS_STORE must be dir or s3 (got %q)", AttachmentsStore)
+	}
+
+	SearchMode = strings.ToLower(strings.TrimSpace(os.Getenv("SCRYPTS_SEARCH")))
+	switch SearchMode {
+	case "":
+		SearchMode = SearchBlind
+	case SearchBlind:
+	case SearchFTS:
+		if DBDriver != DBSQLite {
+			log.Fatal("FATAL: SCRYPTS_SEARCH=fts needs the sqlite driver")
+		}
+	default:
+		log.Fatalf("FATAL: SCRYPTS_SEARCH must be blind or fts (got %q)", SearchMode)
+	}
+
 	log.Println("Configuration initialized successfully")
 }
+
+// InitDatabase loads only the database and backup settings, for commands
+// that don't need the server's secrets.
+func InitDatabase() {
+	DBDriver = DBSQLite
+	if d := strings.ToLower(strings.TrimSpace(os.Getenv("SCRYPTS_DB_DRIVER"))); d != "" {
+		switch d {
+		case DBSQLite, DBPostgres, DBMemory:
+			DBDriver = d
+		default:
+			log.Fatalf("FATAL: SCRYPTS_DB_DRIVER must be one of sqlite, postgres or memory (got %q)", d)
+		}
+	}
+	DBDSN = os.Getenv("SCRYPTS_DB_DSN")
+	if DBDSN == "" {
+		switch DBDriver {
+		case DBSQLite:
+			DBDSN = os.Getenv("SCRYPTS_DB_PATH")
+			if DBDSN == "" {
+				DBDSN = "./scrypts.db"
+			}
+		case DBPostgres:
+			log.Fatal("FATAL: SCRYPTS_DB_DSN must be set when SCRYPTS_DB_DRIVER is postgres")
+		}
+	}
+	DBBusyTimeout = envInt("SCRYPTS_DB_BUSY_TIMEOUT", 5000)
+	DBSynchronous = strings.ToUpper(strings.TrimSpace(os.Getenv("SCRYPTS_DB_SYNCHRONOUS")))
+	switch DBSynchronous {
+	case "", "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		log.Fatalf("FATAL: SCRYPTS_DB_SYNCHRONOUS must be one of OFF, NORMAL, FULL or EXTRA (got %q)", DBSynchronous)
+	}
+	DBReadConns = envInt("SCRYPTS_DB_READ_CONNS", 4)
+	DBWriteConns = envInt("SCRYPTS_DB_WRITE_CONNS", 10)
+	if DBDriver == DBMemory {
+		log.Println("WARNING: using the in-memory store; all data is lost when the server stops")
+	}
+	AutoMigrate = envBool("SCRYPTS_AUTO_MIGRATE", true)
+
+	BackupDir = os.Getenv("SCRYPTS_BACKUP_DIR")
+	if BackupDir == "" {
+		BackupDir = "./backups"
+	}
+	BackupKey = nil
+	if k := os.Getenv("SCRYPTS_BACKUP_KEY"); k != "" {
+		if len(k) < 32 {
+			log.Fatal("FATAL: SCRYPTS_BACKUP_KEY must be at least 32 characters long")
+		}
+		BackupKey = []byte(k)
+	}
+	BackupCompress = envBool("SCRYPTS_BACKUP_COMPRESS", true)
+
+	BackupScheduled = false
+	if at := strings.TrimSpace(os.Getenv("SCRYPTS_BACKUP_AT")); at != "" {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			log.Fatalf("FATAL: SCRYPTS_BACKUP_AT must be a time of day such as 03:00 (got %q)", at)
+		}
+		BackupScheduled = true
+		BackupAt = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	BackupKeepDaily = envInt("SCRYPTS_BACKUP_KEEP_DAILY", 7)
+	BackupKeepWeekly = envInt("SCRYPTS_BACKUP_KEEP_WEEKLY", 4)
+	BackupS3Prefix = os.Getenv("SCRYPTS_BACKUP_S3_PREFIX")
+	if BackupS3Prefix == "" {
+		BackupS3Prefix = "backups/"
+	}
+
+	S3Endpoint = strings.TrimSpace(os.Getenv("SCRYPTS_S3_ENDPOINT"))
+	S3Region = os.Getenv("SCRYPTS_S3_REGION")
+	if S3Region == "" {
+		S3Region = "us-east-1"
+	}
+	S3Bucket = os.Getenv("SCRYPTS_S3_BUCKET")
+	S3AccessKey = os.Getenv("SCRYPTS_S3_ACCESS_KEY")
+	S3SecretKey = os.Getenv("SCRYPTS_S3_SECRET_KEY")
+	S3PathStyle = envBool("SCRYPTS_S3_PATH_STYLE", true)
+	if S3Endpoint != "" && (S3Bucket == "" || S3AccessKey == "" || S3SecretKey == "") {
+		log.Fatal("FATAL: SCRYPTS_S3_BUCKET, SCRYPTS_S3_ACCESS_KEY and SCRYPTS_S3_SECRET_KEY must be set with SCRYPTS_S3_ENDPOINT")
+	}
+
+	ReplicaTarget = strings.TrimSpace(os.Getenv("SCRYPTS_REPLICA"))
+	ReplicaPrefix = os.Getenv("SCRYPTS_REPLICA_S3_PREFIX")
+	if ReplicaPrefix == "" {
+		ReplicaPrefix = "replica/"
+	}
+	ReplicaSyncInterval = time.Duration(envInt("SCRYPTS_REPLICA_SYNC_INTERVAL", 1000)) * time.Millisecond
+	ReplicaSnapshotInterval = time.Duration(envInt("SCRYPTS_REPLICA_SNAPSHOT_HOURS", 24)) * time.Hour
+	ReplicaRetention = time.Duration(envInt("SCRYPTS_REPLICA_RETENTION_HOURS", 72)) * time.Hour
+	if ReplicaTarget != "" {
+		if DBDriver != DBSQLite {
+			log.Fatal("FATAL: SCRYPTS_REPLICA needs the sqlite driver")
+		}
+		if ReplicaTarget == "s3" && S3Endpoint == "" {
+			log.Fatal("FATAL: SCRYPTS_REPLICA=s3 needs SCRYPTS_S3_ENDPOINT")
+		}
+		if ReplicaSyncInterval <= 0 || ReplicaSnapshotInterval <= 0 {
+			log.Fatal("FATAL: SCRYPTS_REPLICA_SYNC_INTERVAL and SCRYPTS_REPLICA_SNAPSHOT_HOURS must be positive")
+		}
+	}
+}
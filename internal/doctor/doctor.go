@@ -0,0 +1,188 @@
+// Package doctor looks for damage in the database that the server can't
+// recover from on its own, such as users without an encryption key or notes
+// that no longer decrypt, and repairs what it can without losing anything
+// that is still readable.
+package doctor
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of problems.
+const (
+	Integrity     = "integrity"          // SQLite's integrity_check failed
+	MissingKey    = "missing-key"        // user has no wrapped key
+	BadKey        = "bad-key"            // user's key doesn't unwrap with the master key
+	Undecryptable = "undecryptable-note" // note fails to decrypt with its owner's key
+	Orphan        = "orphaned-note"      // note's owner doesn't exist
+	InvalidID     = "invalid-note-id"    // note id isn't a UUID
+)
+
+// Problem is one finding.
+type Problem struct {
+	Kind     string
+	Subject  string // user name or note id
+	Detail   string
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%-20s %s: %s", p.Kind, p.Subject, p.Detail)
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// integrityChecker is implemented by storage.SQLiteStore.
+type integrityChecker interface {
+	IntegrityCheck() ([]string, error)
+}
+
+// Check examines the store and, when repair is set, fixes the problems it
+// safely can:
+//
+//   - users without a key who have no notes get a new key;
+//   - orphaned notes, which nobody can decrypt any more, are deleted;
+//   - notes with an invalid id are given a new one.
+//
+// Nothing is repaired if the database fails its integrity check.
+func Check(store storage.Store, masterKey []byte, repair bool) ([]Problem, error) {
+	c, ok := store.(storage.Checker)
+	if !ok {
+		return nil, errors.New("the store doesn't support checks")
+	}
+	var problems []Problem
+
+	if ic, ok := store.(integrityChecker); ok {
+		msgs, err := ic.IntegrityCheck()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			problems = append(problems, Problem{Kind: Integrity, Subject: "database", Detail: msg})
+		}
+		if len(msgs) > 0 {
+			// writing to a damaged file can make things worse; restore a backup
+			repair = false
+		}
+	}
+
+	users, err := c.AllUsers()
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string][]byte{}
+	exists := map[string]bool{}
+	var keyless []string
+	for _, u := range users {
+		exists[u.Username] = true
+		if len(u.WrappedKey) == 0 || len(u.WrappedNonce) == 0 {
+			keyless = append(keyless, u.Username)
+			continue
+		}
+		k, err := utils.UnwrapKey(masterKey, u.WrappedNonce, u.WrappedKey)
+		if err != nil {
+			problems = append(problems, Problem{Kind: BadKey, Subject: u.Username,
+				Detail: "key doesn't unwrap with MASTER_KEY"})
+			continue
+		}
+		keys[u.Username] = k
+	}
+
+	noteCount := map[string]int{}
+	var orphans, badIDs []string
+	err = c.EachNote(func(n storage.Note) error {
+		if !exists[n.Owner] {
+			orphans = append(orphans, n.ID)
+			problems = append(problems, Problem{Kind: Orphan, Subject: n.ID,
+				Detail: fmt.Sprintf("owner %q doesn't exist", n.Owner)})
+			return nil
+		}
+		noteCount[n.Owner]++
+		if _, err := uuid.Parse(n.ID); err != nil {
+			badIDs = append(badIDs, n.ID)
+			problems = append(problems, Problem{Kind: InvalidID, Subject: n.ID,
+				Detail: fmt.Sprintf("note of %s has an id the API can't address", n.Owner)})
+		}
+		if k, ok := keys[n.Owner]; ok {
+			var err error
+			if len(n.Key) > 0 {
+				// shared notes have a key of their own
+				k, err = utils.UnwrapKey(k, n.KeyNonce, n.Key)
+			}
+			if err == nil {
+				_, err = utils.DecryptAESGCM(k, n.Nonce, n.Content)
+			}
+			if err != nil {
+				problems = append(problems, Problem{Kind: Undecryptable, Subject: n.ID,
+					Detail: fmt.Sprintf("note of %s doesn't decrypt: %v", n.Owner, err)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range keyless {
+		p := Problem{Kind: MissingKey, Subject: name}
+		if n := noteCount[name]; n > 0 {
+			p.Detail = fmt.Sprintf("no key, so %d note(s) can't be decrypted", n)
+		} else {
+			p.Detail = "no key; every request for notes fails"
+			if repair {
+				if err := issueKey(store, masterKey, name); err != nil {
+					return nil, fmt.Errorf("issuing key for %s: %w", name, err)
+				}
+				p.Repaired = true
+			}
+		}
+		problems = append(problems, p)
+	}
+
+	if !repair {
+		return problems, nil
+	}
+	// what was done, by kind and subject
+	repaired := map[string]string{}
+	for _, id := range orphans {
+		if err := c.PurgeNote(id); err != nil {
+			return nil, fmt.Errorf("deleting orphaned note %s: %w", id, err)
+		}
+		repaired[Orphan+id] = "deleted"
+	}
+	for _, id := range badIDs {
+		newID := uuid.New().String()
+		if err := c.SetNoteID(id, newID); err != nil {
+			return nil, fmt.Errorf("renaming note %s: %w", id, err)
+		}
+		repaired[InvalidID+id] = "now " + newID
+	}
+	for i, p := range problems {
+		if done, ok := repaired[p.Kind+p.Subject]; ok {
+			problems[i].Repaired = true
+			problems[i].Detail += "; " + done
+		}
+	}
+	return problems, nil
+}
+
+// issueKey gives the user a new random key wrapped with the master key.
+func issueKey(store storage.Store, masterKey []byte, username string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, nonce, err := utils.WrapKey(masterKey, key)
+	if err != nil {
+		return err
+	}
+	return store.SaveWrappedKey(username, wrapped, nonce)
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/auth"
+)
+
+// RequireAdmin only lets requests through from callers whose token carries the
+// admin claim and whose account is still an enabled admin. The caller's
+// identity is passed on in the request context.
+func RequireAdmin(authSvc *auth.Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := authSvc.Authenticate(r)
+		if err != nil {
+			api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if !id.Admin {
+			api.Error(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden")
+			return
+		}
+		// the claim lives as long as the token, so re-check the account itself
+		if ok, err := authSvc.IsActiveAdmin(id.Username); err != nil || !ok {
+			api.Error(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
@@ -1,9 +1,9 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
-	"strings"
+	"scrypts/internal/api"
+	"scrypts/internal/utils"
 	"sync"
 	"time"
 )
@@ -82,25 +82,11 @@ func (rl *rateLimiter) Allow(key string) bool {
 // RateLimit middleware limits requests per IP
 func (rl *rateLimiter) RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get client IP (strip port)
-		ip := r.RemoteAddr
-
-		// Check X-Forwarded-For header first (for proxied requests)
-		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-			// Take the first IP if multiple are present
-			ip = strings.Split(forwarded, ",")[0]
-			ip = strings.TrimSpace(ip)
-		} else {
-			// Strip port from RemoteAddr
-			host, _, err := net.SplitHostPort(ip)
-			if err == nil {
-				ip = host
-			}
-		}
+		ip := utils.ClientIP(r)
 
 		if !rl.Allow(ip) {
 			w.Header().Set("Retry-After", "60")
-			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+			api.Error(w, r, http.StatusTooManyRequests, api.CodeRateLimited, "Rate limit exceeded, try again later")
 			return
 		}
 
@@ -0,0 +1,369 @@
+package notes
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/blob"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Files attached to notes are streamed through the server: each is
+// encrypted as it arrives, in StreamChunkSize chunks sealed one by one
+// (utils.StreamCipher) under a random key of its own, which is kept wrapped
+// under the owner's key. Downloads decrypt only the chunks a range needs.
+// The encrypted files are kept in a blob store under the hash of their
+// contents, and counted by the attachments that refer to them; deleting an
+// attachment only drops its reference, and the BlobCollector deletes the
+// blobs no longer referred to.
+
+// transferTimeout bounds an upload or download, overriding the server's
+// much shorter read and write timeouts.
+const transferTimeout = 30 * time.Minute
+
+// collectGrace is how old a blob without references must be before the
+// BlobCollector deletes it, so that uploads still being recorded are left
+// alone.
+const collectGrace = time.Hour
+
+// attachmentResp is an attachment as the API returns it.
+type attachmentResp struct {
+	ID          string `json:"id"`
+	Note        string `json:"note"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Created     int64  `json:"created"`
+}
+
+// UploadAttachmentHandler attaches the request body to a note:
+// POST /notes/{id}/attachments?name=<file name>, with the file's media type
+// as Content-Type.
+func (h *Handler) UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	name, ok := checkName(w, r, r.URL.Query().Get("name"))
+	if !ok {
+		return
+	}
+	if strings.ContainsAny(name, "/\\") || strings.ContainsFunc(name, func(c rune) bool { return c < ' ' || c == 0x7f }) {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid file name")
+		return
+	}
+	maxSize := int64(config.MaxAttachmentMB) << 20
+	if r.ContentLength > maxSize {
+		api.Error(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "File too large")
+		return
+	}
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
+		return
+	}
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return
+	}
+	a := storage.Attachment{
+		ID:          uuid.New().String(),
+		NoteID:      id,
+		Owner:       username,
+		ContentType: contentType(r.Header.Get("Content-Type")),
+		Created:     time.Now().Unix(),
+	}
+	if a.NameNonce, a.Name, ok = h.encrypt(w, r, username, name); !ok {
+		return
+	}
+	fileKey, err := utils.GenerateNonce(32)
+	if err == nil {
+		a.Key, a.KeyNonce, err = utils.WrapKey(userKey, fileKey)
+	}
+	if err != nil {
+		log.Printf("attachment key error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to encrypt file")
+		return
+	}
+
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(transferTimeout))
+	enc := encryptStream(http.MaxBytesReader(w, r.Body, maxSize), fileKey)
+	key, n, err := h.Blobs.Put(enc)
+	enc.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		api.Error(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "File too large")
+		return
+	} else if err != nil {
+		log.Printf("blob Put error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to store file")
+		return
+	}
+	a.Blob = key
+	c, _ := fileCipher(fileKey)
+	if a.Size, err = c.PlaintextSize(n); err == nil {
+		err = h.store.CreateAttachment(a)
+	}
+	if err != nil {
+		// the blob is left to the BlobCollector
+		if err == storage.ErrNotFound {
+			// trashed in the meantime
+			api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+			return
+		}
+		log.Printf("CreateAttachment error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save attachment")
+		return
+	}
+	w.Header().Set("Location", api.Prefix+"/notes/"+id+"/attachments/"+a.ID)
+	api.JSON(w, http.StatusCreated, attachmentResp{ID: a.ID, Note: id, Name: name, ContentType: a.ContentType, Size: a.Size, Created: a.Created})
+}
+
+// ListAttachmentsHandler lists the files attached to a note, oldest first:
+// GET /notes/{id}/attachments.
+func (h *Handler) ListAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
+		return
+	}
+	attachments, err := h.store.ListAttachments(id)
+	if err != nil {
+		log.Printf("ListAttachments error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch attachments")
+		return
+	}
+	resp := make([]attachmentResp, 0, len(attachments))
+	for _, a := range attachments {
+		name, ok := h.decrypt(w, r, username, a.NameNonce, a.Name)
+		if !ok {
+			return
+		}
+		resp = append(resp, attachmentResp{ID: a.ID, Note: id, Name: name, ContentType: a.ContentType, Size: a.Size, Created: a.Created})
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"attachments": resp})
+}
+
+// DownloadAttachmentHandler sends an attached file:
+// GET /notes/{id}/attachments/{aid}. Range requests are answered with the
+// parts asked for.
+func (h *Handler) DownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	a, ok := h.noteAttachment(w, r, username)
+	if !ok {
+		return
+	}
+	name, ok := h.decrypt(w, r, username, a.NameNonce, a.Name)
+	if !ok {
+		return
+	}
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return
+	}
+	fileKey, err := utils.UnwrapKey(userKey, a.KeyNonce, a.Key)
+	if err != nil {
+		log.Printf("attachment %s: UnwrapKey error: %v", a.ID, err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to decrypt file")
+		return
+	}
+	f, err := h.Blobs.Open(a.Blob)
+	if err != nil {
+		log.Printf("attachment %s: blob Open error: %v", a.ID, err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to read file")
+		return
+	}
+	defer f.Close()
+	c, err := fileCipher(fileKey)
+	var sr *utils.StreamReaderAt
+	if err == nil {
+		sr, err = utils.NewStreamReaderAt(f, f.Size(), c, nil)
+	}
+	if err == nil && sr.Size() != a.Size {
+		err = utils.ErrStreamTruncated
+	}
+	if err != nil {
+		log.Printf("attachment %s: %v", a.ID, err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to decrypt file")
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(transferTimeout))
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	// the contents of an attachment never change
+	w.Header().Set("ETag", `"`+a.ID+`"`)
+	// a chunk failing to decrypt cuts the response short
+	http.ServeContent(w, r, "", time.Unix(a.Created, 0), io.NewSectionReader(sr, 0, sr.Size()))
+}
+
+// DeleteAttachmentHandler removes a file from a note:
+// DELETE /notes/{id}/attachments/{aid}.
+func (h *Handler) DeleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	a, ok := h.noteAttachment(w, r, username)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteAttachment(a.ID, username); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Attachment not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteAttachment error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to delete attachment")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// noteAttachment fetches the attachment {aid} of the note {id} of username,
+// which must not be in the trash.
+func (h *Handler) noteAttachment(w http.ResponseWriter, r *http.Request, username string) (storage.Attachment, bool) {
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return storage.Attachment{}, false
+	}
+	aid := r.PathValue("aid")
+	if _, err := uuid.Parse(aid); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid attachment id")
+		return storage.Attachment{}, false
+	}
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
+		return storage.Attachment{}, false
+	}
+	a, err := h.store.GetAttachment(aid)
+	if err == storage.ErrNotFound || (err == nil && a.NoteID != id) {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Attachment not found")
+		return storage.Attachment{}, false
+	}
+	if err != nil {
+		log.Printf("GetAttachment error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to query attachment")
+		return storage.Attachment{}, false
+	}
+	return a, true
+}
+
+// fileCipher returns the stream cipher of an attachment. Each file has a
+// key of its own, used for one stream only, so the nonce prefix is zero.
+func fileCipher(fileKey []byte) (*utils.StreamCipher, error) {
+	return utils.NewStreamCipher(fileKey, make([]byte, utils.StreamPrefixSize))
+}
+
+// encryptStream returns the encryption of what r yields, made as it is
+// read. Closing it stops the encryption.
+func encryptStream(r io.Reader, fileKey []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		c, err := fileCipher(fileKey)
+		if err == nil {
+			sw := utils.NewStreamWriter(pw, c, nil)
+			if _, err = io.Copy(sw, r); err == nil {
+				err = sw.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// contentType returns the media type an upload is recorded with, as given
+// when it is valid.
+func contentType(header string) string {
+	mt, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if ct := mime.FormatMediaType(mt, params); ct != "" && len(ct) <= storage.MaxContentTypeLen {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// BlobCollector deletes, every Interval, the blobs that no attachment
+// refers to any more: those of deleted attachments, and those uploaded for
+// attachments that were never recorded.
+type BlobCollector struct {
+	Store    storage.Store
+	Blobs    blob.Store
+	Interval time.Duration
+}
+
+// Start runs the collector in the background for the life of the process.
+func (c *BlobCollector) Start() {
+	go func() {
+		for {
+			if err := c.RunOnce(); err != nil {
+				log.Printf("blob collection error: %v", err)
+			}
+			time.Sleep(c.Interval)
+		}
+	}()
+}
+
+// RunOnce collects the blob store once.
+func (c *BlobCollector) RunOnce() error {
+	blobs, err := c.Blobs.List()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, b := range blobs {
+		// a blob being uploaded again is as good as new
+		if time.Since(b.Modified) < collectGrace {
+			continue
+		}
+		rec, err := c.Store.GetBlob(b.Key)
+		switch {
+		case err == storage.ErrNotFound:
+			// never recorded
+		case err != nil:
+			return err
+		case rec.Refs > 0:
+			continue
+		default:
+			// the record goes first, unless it is referred to again
+			if err := c.Store.ForgetBlob(b.Key); err == storage.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := c.Blobs.Delete(b.Key); err != nil {
+			return err
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("attachments: deleted %d unused blob(s)", n)
+	}
+	return nil
+}
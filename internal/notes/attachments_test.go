@@ -0,0 +1,220 @@
+package notes
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"scrypts/internal/blob"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBlobCollector(t *testing.T) {
+	store := storage.NewMemoryStore()
+	blobs := blob.Dir{Root: t.TempDir()}
+	c := &BlobCollector{Store: store, Blobs: blobs}
+
+	if err := store.CreateUser(storage.User{Username: "alice1", CreatedAt: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	note := storage.Note{ID: uuid.New().String(), Owner: "alice1", Content: []byte("x"), Nonce: []byte("nonce")}
+	if err := store.SaveNote(note); err != nil {
+		t.Fatal(err)
+	}
+	// put stores a blob last modified age ago
+	put := func(contents string, age time.Duration) string {
+		key, _, err := blobs.Put(strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(blobs.Root, key[:2], key), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	attach := func(key string) string {
+		a := storage.Attachment{
+			ID:          uuid.New().String(),
+			NoteID:      note.ID,
+			Owner:       "alice1",
+			Blob:        key,
+			Name:        []byte("name"),
+			NameNonce:   []byte("nonce"),
+			ContentType: "text/plain",
+			Key:         []byte("key"),
+			KeyNonce:    []byte("nonce"),
+		}
+		if err := store.CreateAttachment(a); err != nil {
+			t.Fatal(err)
+		}
+		return a.ID
+	}
+	old := 2 * collectGrace
+
+	referenced := put("referenced", old)
+	attach(referenced)
+	shared := put("shared", old)
+	attach(shared)
+	detach := attach(shared)
+	unreferenced := put("unreferenced", old)
+	if err := store.DeleteAttachment(attach(unreferenced), "alice1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAttachment(detach, "alice1"); err != nil {
+		t.Fatal(err)
+	}
+	unrecorded := put("unrecorded", old)
+	uploading := put("uploading", 0)
+	recentlyDetached := put("recently detached", 0)
+	if err := store.DeleteAttachment(attach(recentlyDetached), "alice1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{
+		referenced:       true,
+		shared:           true,
+		unreferenced:     false,
+		unrecorded:       false,
+		uploading:        true,
+		recentlyDetached: true,
+	} {
+		r, err := blobs.Open(key)
+		if err == nil {
+			r.Close()
+		}
+		if kept := err == nil; kept != want {
+			t.Errorf("blob %s kept: %v, want %v (%v)", key[:8], kept, want, err)
+		}
+	}
+	if _, err := store.GetBlob(unreferenced); err != storage.ErrNotFound {
+		t.Errorf("GetBlob of a collected blob: %v, want ErrNotFound", err)
+	}
+	if b, err := store.GetBlob(shared); err != nil || b.Refs != 1 {
+		t.Errorf("GetBlob of a blob still referred to = %+v, %v", b, err)
+	}
+	if b, err := store.GetBlob(recentlyDetached); err != nil || b.Refs != 0 {
+		t.Errorf("GetBlob of a blob within the grace period = %+v, %v", b, err)
+	}
+}
+
+// upload attaches contents to a note, with the Content-Length left unset
+// unless sized.
+func (s *testServer) upload(token, id, name string, contents []byte, sized bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/notes/"+id+"/attachments?name="+url.QueryEscape(name), bytes.NewReader(contents))
+	if !sized {
+		req.ContentLength = -1
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// download fetches an attachment, asking for rng unless it is empty.
+func (s *testServer) download(token, path, rng string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAttachments(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user("alice1")
+	bobby := s.user("bobby1")
+	id := s.createNote(alice, "photos", "")
+
+	// more than one chunk, so that ranges can span two
+	contents := make([]byte, 3*utils.StreamChunkSize/2)
+	rand.Read(contents)
+	rec := s.upload(alice, id, "beach.jpg", contents, false)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+	var a attachmentResp
+	json.Unmarshal(rec.Body.Bytes(), &a)
+	if a.Name != "beach.jpg" || a.Size != int64(len(contents)) || a.ContentType != "application/octet-stream" {
+		t.Errorf("uploaded attachment = %+v", a)
+	}
+	path := "/notes/" + id + "/attachments/" + a.ID
+
+	rec = s.download(alice, path, "")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), contents) {
+		t.Errorf("download: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if d := rec.Header().Get("Content-Disposition"); d != `attachment; filename=beach.jpg` {
+		t.Errorf("Content-Disposition = %s", d)
+	}
+	start, end := utils.StreamChunkSize-10, utils.StreamChunkSize+9
+	rec = s.download(alice, path, fmt.Sprintf("bytes=%d-%d", start, end))
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), contents[start:end+1]) {
+		t.Errorf("download across chunks: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if cr, want := rec.Header().Get("Content-Range"), fmt.Sprintf("bytes %d-%d/%d", start, end, len(contents)); cr != want {
+		t.Errorf("Content-Range = %s, want %s", cr, want)
+	}
+	rec = s.download(alice, path, "bytes=-5")
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), contents[len(contents)-5:]) {
+		t.Errorf("download of the last bytes: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec = s.download(alice, path, fmt.Sprintf("bytes=%d-", len(contents))); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("download past the end: %d", rec.Code)
+	}
+	if rec = s.download(bobby, path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("download by another user: %d", rec.Code)
+	}
+
+	// the limit is 1 MiB, announced or not
+	big := make([]byte, 1<<20+1)
+	for _, sized := range []bool{true, false} {
+		if rec := s.upload(alice, id, "big.bin", big, sized); rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("upload over the limit, sized %v: %d %s", sized, rec.Code, rec.Body)
+		}
+	}
+	if rec := s.upload(alice, id, "fits.bin", big[:1<<20], false); rec.Code != http.StatusCreated {
+		t.Errorf("upload at the limit: %d %s", rec.Code, rec.Body)
+	}
+
+	for _, name := range []string{"", "  ", "../etc/passwd", `dir\file`, "tab\tname", "bell\x07", strings.Repeat("n", storage.MaxNameSize+1)} {
+		if rec := s.upload(alice, id, name, []byte("x"), true); rec.Code != http.StatusBadRequest {
+			t.Errorf("upload named %q: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	if rec := s.upload(bobby, id, "mine.txt", []byte("x"), true); rec.Code != http.StatusNotFound {
+		t.Errorf("upload to another user's note: %d", rec.Code)
+	}
+
+	var list struct {
+		Attachments []attachmentResp `json:"attachments"`
+	}
+	s.do(http.MethodGet, "/notes/"+id+"/attachments", alice, nil, http.StatusOK, &list)
+	// both were made within the same second, so their order is unsettled
+	if len(list.Attachments) != 2 || (list.Attachments[0].ID != a.ID && list.Attachments[1].ID != a.ID) {
+		t.Errorf("GET attachments = %+v", list)
+	}
+	s.do(http.MethodDelete, path, alice, nil, http.StatusOK, nil)
+	if rec = s.download(alice, path, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("download of a deleted attachment: %d", rec.Code)
+	}
+}
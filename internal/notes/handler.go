@@ -1,252 +1,465 @@
 package notes
 
 import (
-	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"scrypts/internal/api"
 	"scrypts/internal/auth"
+	"scrypts/internal/blob"
 	"scrypts/internal/storage"
 	"scrypts/internal/utils"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Handler serves the notes API.
+type Handler struct {
+	store storage.Store
+	auth  *auth.Service
+
+	// Blobs keeps the contents of attachments; nil turns them off.
+	Blobs blob.Store
+}
+
+func NewHandler(store storage.Store, authSvc *auth.Service) *Handler {
+	return &Handler{store: store, auth: authSvc}
+}
+
 type NoteReq struct {
-	Content string `json:"content"`
+	Content  string   `json:"content"`
+	Title    string   `json:"title"`
+	Notebook string   `json:"notebook"`
+	Tags     []string `json:"tags"`
+}
+
+// noteChanges are the fields of a note set by a request, nil when left as
+// they are.
+type noteChanges struct {
+	Content  *string   `json:"content"`
+	Title    *string   `json:"title"`
+	Notebook *string   `json:"notebook"`
+	Tags     *[]string `json:"tags"`
 }
 
-func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
+// noteResp is a decrypted note as the API returns it.
+type noteResp struct {
+	ID       string   `json:"id"`
+	Owner    string   `json:"owner"`
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Notebook string   `json:"notebook,omitempty"`
+	Tags     []string `json:"tags"`
+	Created  int64    `json:"created"`
+	Modified int64    `json:"modified"`
+	Revision int64    `json:"revision"`
+	// notes shared with the caller only: what the share lets them do
+	Permission string `json:"permission,omitempty"`
+	// notes in the trash only: when they were deleted and will be purged
+	Deleted int64 `json:"deleted,omitempty"`
+	PurgeAt int64 `json:"purge_at,omitempty"`
+}
+
+func (h *Handler) CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 	var req NoteReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
 		return
 	}
 
 	if len(req.Content) > storage.MaxNoteContentSize {
-		http.Error(w, "Note content too large", http.StatusRequestEntityTooLarge)
+		api.Error(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Note content too large")
 		return
 	}
 
-	username, err := auth.GetUsernameFromJWT(r)
+	username, err := h.auth.GetUsernameFromJWT(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
-	noteID := uuid.New().String()
-	userKey, err := auth.GetUserKey(username)
-	if err != nil {
-		http.Error(w, "Server error: missing encryption key", http.StatusInternalServerError)
-		return
-	}
-	key := userKey
-	nonce, err := utils.GenerateNonce(12)
-	if err != nil {
-		http.Error(w, "Failed to generate nonce", http.StatusInternalServerError)
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
 		return
 	}
-	ciphertext, err := utils.EncryptAESGCM(key, nonce, []byte(req.Content))
-	if err != nil {
-		log.Printf("EncryptAESGCM error : %v", err)
-		http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
-		return
-	}
-
+	noteID := uuid.New().String()
 	now := time.Now().Unix()
 	snote := storage.Note{
 		ID:       noteID,
 		Owner:    username,
-		Content:  ciphertext,
-		Nonce:    nonce,
 		Created:  now,
 		Modified: now,
 	}
-	if err := storage.SaveNote(snote); err != nil {
+	changes := noteChanges{Content: &req.Content, Title: &req.Title, Notebook: &req.Notebook, Tags: &req.Tags}
+	if !h.applyChanges(w, r, &snote, changes, userKey) {
+		return
+	}
+	if err := h.store.SaveNote(snote); err != nil {
 		log.Printf("Savenote error: %v", err)
-		http.Error(w, "Failed to Save Note", http.StatusInternalServerError)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save note")
 		return
 	}
 
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": noteID})
+	w.Header().Set("Location", api.Prefix+"/notes/"+noteID)
+	w.Header().Set("ETag", etag(1)) // where every note starts
+	api.JSON(w, http.StatusCreated, map[string]string{"id": noteID})
 }
 
-func GetNotesHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetNotesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
-	username, err := auth.GetUsernameFromJWT(r)
+	h.listNotes(w, r, false)
+}
+
+// listNotes answers with a page of the caller's notes, or of their trash.
+func (h *Handler) listNotes(w http.ResponseWriter, r *http.Request, trashed bool) {
+	username, err := h.auth.GetUsernameFromJWT(r)
 	if err != nil {
-		http.Error(w, "Unauthorized Access", http.StatusUnauthorized)
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
 		return
 	}
-	snotes, err := storage.GetNotesByOwner(username)
+	q, err := parseListQuery(r.URL.Query(), trashed)
 	if err != nil {
-		log.Printf("GetNotesByOwner error: %v", err)
-		http.Error(w, "failed to fetch notes", http.StatusInternalServerError)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, err.Error())
 		return
 	}
-
-	userKey, err := auth.GetUserKey(username)
+	q.Owner = username
+	page, err := h.store.ListNotes(q)
 	if err != nil {
-		log.Printf("Get User Key error: %v", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
+		log.Printf("ListNotes error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch notes")
 		return
 	}
 
-	type NoteResp struct {
-		ID       string `json:"id"`
-		Owner    string `json:"owner"`
-		Content  string `json:"content"`
-		Created  int64  `json:"created"`
-		Modified int64  `json:"modified"`
+	resp, ok := h.noteResps(w, r, username, page.Notes)
+	if !ok {
+		return
+	}
+	out := struct {
+		Notes      []noteResp `json:"notes"`
+		Total      int        `json:"total"`
+		NextCursor string     `json:"next_cursor,omitempty"`
+	}{Notes: resp, Total: page.Total}
+	if page.Next != nil {
+		out.NextCursor = encodeCursor(q, page.Next)
 	}
-	resp := make([]NoteResp, 0, len(snotes))
-	for _, sn := range snotes {
-		pt, derr := utils.DecryptAESGCM(userKey, sn.Nonce, sn.Content)
+	api.JSON(w, http.StatusOK, out)
+}
+
+// noteResps decrypts notes of username for a list.
+func (h *Handler) noteResps(w http.ResponseWriter, r *http.Request, username string, notes []storage.Note) ([]noteResp, bool) {
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return nil, false
+	}
+	resp := make([]noteResp, 0, len(notes))
+	for _, sn := range notes {
+		key, derr := noteKeyUnder(userKey, sn)
+		var pt, title []byte
+		if derr == nil {
+			pt, derr = utils.DecryptAESGCM(key, sn.Nonce, sn.Content)
+		}
+		if derr == nil && len(sn.Title) > 0 {
+			title, derr = utils.DecryptAESGCM(key, sn.TitleNonce, sn.Title)
+		}
 		if derr != nil {
-			log.Printf("DecryptAESGCM error :%v", err)
-			http.Error(w, "failed to decrypt note", http.StatusInternalServerError)
-			return
+			log.Printf("DecryptAESGCM error :%v", derr)
+			api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to decrypt note")
+			return nil, false
 		}
-		resp = append(resp, NoteResp{
-			ID:       sn.ID,
-			Owner:    sn.Owner,
-			Content:  string(pt),
-			Created:  sn.Created,
-			Modified: sn.Modified,
-		})
+		nr := newNoteResp(sn, string(title), string(pt))
+		nr.Deleted = sn.Deleted
+		nr.PurgeAt = purgeTime(sn.Deleted)
+		resp = append(resp, nr)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	return resp, true
 }
 
-func UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// GetNoteHandler returns one of the caller's notes, or one shared with
+// them: GET /notes/{id}.
+func (h *Handler) GetNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	a, ok := h.noteAccess(w, r, id, username, false)
+	if !ok {
 		return
 	}
-	username, err := auth.GetUsernameFromJWT(r)
+	h.writeNote(w, r, a)
+}
+
+// UpdateNoteHandler replaces a note: PUT /notes/{id}. The content is
+// required; a title, notebook or tags left out are cleared. Users a note
+// is shared with for writing can change its title and content only.
+func (h *Handler) UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	h.updateNote(w, r, false)
+}
+
+// PatchNoteHandler changes only the fields present in the body:
+// PATCH /notes/{id}.
+func (h *Handler) PatchNoteHandler(w http.ResponseWriter, r *http.Request) {
+	h.updateNote(w, r, true)
+}
+
+func (h *Handler) updateNote(w http.ResponseWriter, r *http.Request, partial bool) {
+	username, err := h.auth.GetUsernameFromJWT(r)
 	if err != nil {
-		http.Error(w, "Unauthorised access", http.StatusUnauthorized)
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
 		return
 	}
 	var req struct {
-		ID      string `json:"id"`
-		Content string `json:"content"`
+		noteChanges
+		Revision int64 `json:"revision"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
 		return
 	}
-	// validate ID and content early
-	if _, err := uuid.Parse(req.ID); err != nil {
-		http.Error(w, "invalid note id", http.StatusBadRequest)
+	revision, ok := expectedRevision(w, r, req.Revision)
+	if !ok {
 		return
 	}
-	if len(req.Content) > storage.MaxNoteContentSize {
-		http.Error(w, "Note content too large", http.StatusRequestEntityTooLarge)
-		return
+	changes := req.noteChanges
+	if partial {
+		if changes == (noteChanges{}) {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Nothing to update")
+			return
+		}
+	} else {
+		if changes.Content == nil {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Content is required")
+			return
+		}
+		var none string
+		if changes.Title == nil {
+			changes.Title = &none
+		}
 	}
-	existing, err := storage.GetNoteByID(req.ID)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Note not found", http.StatusNotFound)
+	if changes.Content != nil && len(*changes.Content) > storage.MaxNoteContentSize {
+		api.Error(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Note content too large")
 		return
 	}
-	if err != nil {
-		log.Printf("GetNoteByID error: %v", err)
-		http.Error(w, "failed to query note", http.StatusInternalServerError)
+	existing, ok := h.noteAccess(w, r, id, username, true)
+	if !ok {
 		return
 	}
-	// ownership check
-	if existing.Owner != username {
-		http.Error(w, "Note not found", http.StatusNotFound)
-		return
+	if existing.permission != "" {
+		// the notebook and tags are the owner's
+		if changes.Notebook != nil || changes.Tags != nil {
+			api.Error(w, r, http.StatusForbidden, api.CodeForbidden, "Only the owner can change the notebook or tags")
+			return
+		}
+	} else if !partial {
+		var none string
+		if changes.Notebook == nil {
+			changes.Notebook = &none
+		}
+		if changes.Tags == nil {
+			changes.Tags = &[]string{}
+		}
 	}
-	userKey, err := auth.GetUserKey(username)
-	if err != nil {
-		http.Error(w, "Server error: missing encryption key", http.StatusInternalServerError)
+	a, ok := h.saveChanges(w, r, existing, changes, revision)
+	if !ok {
 		return
 	}
-	key := userKey
-	nonce, err := utils.GenerateNonce(12)
+	h.writeNote(w, r, a)
+}
+
+// DeleteNoteHandler moves a note to the trash: DELETE /notes/{id}. The
+// revision is given in If-Match or the revision query parameter.
+func (h *Handler) DeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
 	if err != nil {
-		http.Error(w, "Failed to generate Nonce", http.StatusInternalServerError)
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
 		return
 	}
-	ciphertext, err := utils.EncryptAESGCM(key, nonce, []byte(req.Content))
-	if err != nil {
-		http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
+	id, ok := pathNoteID(w, r)
+	if !ok {
 		return
 	}
-	now := time.Now().Unix()
-	snote := storage.Note{
-		ID:       req.ID,
-		Owner:    username,
-		Content:  ciphertext,
-		Nonce:    nonce,
-		Created:  existing.Created,
-		Modified: now,
+	var field int64
+	if s := r.URL.Query().Get("revision"); s != "" {
+		if field, err = strconv.ParseInt(s, 10, 64); err != nil || field <= 0 {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid revision")
+			return
+		}
 	}
-	if err := storage.UpdateNote(snote); err != nil {
-		log.Printf("UpdateNote error: %v", err)
-		http.Error(w, "failed to update note", http.StatusInternalServerError)
+	revision, ok := expectedRevision(w, r, field)
+	if !ok {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+	h.trashNote(w, r, id, username, revision)
 }
 
-func DeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// pathNoteID returns the {id} of the request path, answering 400 when it
+// isn't a note id.
+func pathNoteID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid note id")
+		return "", false
+	}
+	return id, true
+}
+
+// ownedNote fetches the note id of username. Notes of other users are
+// reported as not found, so that their ids don't leak, and so are notes in
+// the trash.
+func (h *Handler) ownedNote(w http.ResponseWriter, r *http.Request, id, username string) (storage.Note, bool) {
+	return h.userNote(w, r, id, username, false)
+}
+
+// userNote fetches the note id of username, in the trash or out of it.
+func (h *Handler) userNote(w http.ResponseWriter, r *http.Request, id, username string, trashed bool) (storage.Note, bool) {
+	existing, err := h.store.GetNoteByID(id)
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return storage.Note{}, false
 	}
-	username, err := auth.GetUsernameFromJWT(r)
 	if err != nil {
-		http.Error(w, "Unauthorized access", http.StatusUnauthorized)
-		return
+		log.Printf("GetNoteByID error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to query note")
+		return storage.Note{}, false
 	}
-	var req struct {
-		ID string `json:"id"`
+	// ownership check
+	if existing.Owner != username || (existing.Deleted != 0) != trashed {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return storage.Note{}, false
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
+	return existing, true
+}
+
+// saveChanges applies changes to the existing note and stores it, provided
+// the note is still at revision (0 for any). The content and title it
+// replaces become an earlier revision.
+func (h *Handler) saveChanges(w http.ResponseWriter, r *http.Request, a noteAccess, changes noteChanges, revision int64) (noteAccess, bool) {
+	existing := a.note
+	note := existing
+	if !h.applyChanges(w, r, &note, changes, a.key) {
+		return noteAccess{}, false
+	}
+	note.Modified = time.Now().Unix()
+	note.Revision = revision
+	var err error
+	note.Revision, err = h.store.UpdateNote(note)
+	if err != nil {
+		if err != storage.ErrConflict && err != storage.ErrNotFound {
+			log.Printf("UpdateNote error: %v", err)
+		}
+		revisionError(w, r, err, revision, "update")
+		return noteAccess{}, false
 	}
-	if _, err := uuid.Parse(req.ID); err != nil {
-		http.Error(w, "invalid note id", http.StatusBadRequest)
-		return
+	h.pruneRevisions(existing.Owner, existing.ID)
+	a.note = note
+	return a, true
+}
+
+// applyChanges sets the fields of note given in changes, encrypting the
+// content and title afresh under key, indexing them again, and checking
+// that the notebook and tags are the owner's. The content size is checked
+// by the caller.
+func (h *Handler) applyChanges(w http.ResponseWriter, r *http.Request, note *storage.Note, changes noteChanges, key []byte) bool {
+	var ok bool
+	if changes.Content != nil {
+		if note.Nonce, note.Content, ok = h.encryptWith(w, r, key, *changes.Content); !ok {
+			return false
+		}
+	}
+	if changes.Title != nil {
+		title := *changes.Title
+		if len(title) > storage.MaxTitleSize {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Title too long")
+			return false
+		}
+		note.Title, note.TitleNonce = nil, nil
+		if title != "" {
+			if note.TitleNonce, note.Title, ok = h.encryptWith(w, r, key, title); !ok {
+				return false
+			}
+		}
 	}
-	existing, err := storage.GetNoteByID(req.ID)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Note not found", http.StatusNotFound)
+	if changes.Notebook != nil {
+		if id := *changes.Notebook; id != "" {
+			if _, ok := h.userNotebook(w, r, id, note.Owner, http.StatusBadRequest); !ok {
+				return false
+			}
+		}
+		note.Notebook = *changes.Notebook
+	}
+	if changes.Tags != nil {
+		tags, ok := h.userTags(w, r, note.Owner, *changes.Tags)
+		if !ok {
+			return false
+		}
+		note.Tags = tags
+	}
+	if changes.Content != nil || changes.Title != nil {
+		return h.indexNote(w, r, note, changes, key)
+	}
+	return true
+}
+
+func (h *Handler) trashNote(w http.ResponseWriter, r *http.Request, id, username string, revision int64) {
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
 		return
 	}
-	if err != nil {
-		log.Printf("GetNoteByID error: %v", err)
-		http.Error(w, "failed to query note", http.StatusInternalServerError)
+	if err := h.store.TrashNote(id, username, revision, time.Now().Unix()); err != nil {
+		if err != storage.ErrConflict && err != storage.ErrNotFound {
+			log.Printf("TrashNote error: %v", err)
+		}
+		revisionError(w, r, err, revision, "delete")
 		return
 	}
-	// ownership check
-	if existing.Owner != username {
-		http.Error(w, "Note not found", http.StatusNotFound)
+	api.JSON(w, http.StatusOK, map[string]string{"status": "trashed"})
+}
+
+// writeNote answers with the decrypted note and its ETag.
+func (h *Handler) writeNote(w http.ResponseWriter, r *http.Request, a noteAccess) {
+	note := a.note
+	content, ok := h.decryptWith(w, r, a.key, note.Nonce, note.Content)
+	if !ok {
 		return
 	}
-	if err := storage.DeleteNote(req.ID, username); err != nil {
-		log.Printf("DeleteNote error: %v", err)
-		http.Error(w, "failed to delete note", http.StatusInternalServerError)
+	title, ok := h.decryptTitle(w, r, a.key, note.TitleNonce, note.Title)
+	if !ok {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	w.Header().Set("ETag", etag(note.Revision))
+	api.JSON(w, http.StatusOK, a.resp(title, content))
+}
+
+func newNoteResp(note storage.Note, title, content string) noteResp {
+	tags := note.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return noteResp{
+		ID:       note.ID,
+		Owner:    note.Owner,
+		Title:    title,
+		Content:  content,
+		Notebook: note.Notebook,
+		Tags:     tags,
+		Created:  note.Created,
+		Modified: note.Modified,
+		Revision: note.Revision,
+	}
 }
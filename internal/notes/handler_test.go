@@ -0,0 +1,174 @@
+package notes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"scrypts/internal/auth"
+	"scrypts/internal/blob"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"testing"
+)
+
+const testPassword = "Tr0ub4dor&3-horse-battery"
+
+func TestMain(m *testing.M) {
+	config.JwtSecret = []byte("test-jwt-secret-test-jwt-secret-0123456789")
+	config.MasterKey = []byte("test-master-key-0123456789abcdef")
+	config.RegistrationMode = config.RegistrationOpen
+	config.SearchMode = config.SearchBlind
+	config.RevisionsKeep = 50
+	config.TrashDays = 30
+	config.LinkMaxDays = 30
+	config.MaxAttachmentMB = 1
+	os.Exit(m.Run())
+}
+
+// testServer serves the notes API, over a memory store unless made with
+// newTestServerOn.
+type testServer struct {
+	t     *testing.T
+	store storage.Store
+	auth  *auth.Service
+	mux   *http.ServeMux
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerOn(t, storage.NewMemoryStore())
+}
+
+func newTestServerOn(t *testing.T, store storage.Store) *testServer {
+	authSvc := auth.New(store)
+	h := NewHandler(store, authSvc)
+	h.Blobs = blob.Dir{Root: t.TempDir()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /notes", h.CreateNoteHandler)
+	mux.HandleFunc("GET /notes", h.GetNotesHandler)
+	mux.HandleFunc("GET /notes/shared", h.SharedNotesHandler)
+	mux.HandleFunc("GET /notes/search", h.SearchHandler)
+	mux.HandleFunc("GET /notes/{id}", h.GetNoteHandler)
+	mux.HandleFunc("PATCH /notes/{id}", h.PatchNoteHandler)
+	mux.HandleFunc("DELETE /notes/{id}", h.DeleteNoteHandler)
+	mux.HandleFunc("PUT /notes/{id}/shares/{user}", h.ShareNoteHandler)
+	mux.HandleFunc("DELETE /notes/{id}/shares/{user}", h.UnshareNoteHandler)
+	mux.HandleFunc("POST /notes/{id}/attachments", h.UploadAttachmentHandler)
+	mux.HandleFunc("GET /notes/{id}/attachments", h.ListAttachmentsHandler)
+	mux.HandleFunc("GET /notes/{id}/attachments/{aid}", h.DownloadAttachmentHandler)
+	mux.HandleFunc("DELETE /notes/{id}/attachments/{aid}", h.DeleteAttachmentHandler)
+	mux.HandleFunc("POST /notes/{id}/links", h.CreateLinkHandler)
+	mux.HandleFunc("GET /links/{id}", h.ViewLinkHandler)
+	mux.HandleFunc("GET /trash", h.ListTrashHandler)
+	mux.HandleFunc("DELETE /trash", h.EmptyTrashHandler)
+	mux.HandleFunc("POST /trash/{id}/restore", h.RestoreTrashHandler)
+	mux.HandleFunc("DELETE /trash/{id}", h.DeleteTrashHandler)
+	return &testServer{t: t, store: store, auth: authSvc, mux: mux}
+}
+
+// user registers username and returns a token of theirs.
+func (s *testServer) user(username string) string {
+	s.t.Helper()
+	body := auth.RegisterReq{Username: username, Password: testPassword}
+	if rec := s.call(http.MethodPost, "/register", "", body, s.auth.RegisterHandler); rec.Code != http.StatusCreated {
+		s.t.Fatalf("register %s: %d %s", username, rec.Code, rec.Body)
+	}
+	rec := s.call(http.MethodPost, "/login", "", auth.LoginReq{Username: username, Password: testPassword}, s.auth.LoginHandler)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		s.t.Fatalf("login %s: %d %s", username, rec.Code, rec.Body)
+	}
+	return resp.Token
+}
+
+// call sends body, as JSON unless nil, to h or else the notes API.
+func (s *testServer) call(method, path, token string, body any, h http.HandlerFunc) *httptest.ResponseRecorder {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	if h != nil {
+		h(rec, req)
+	} else {
+		s.mux.ServeHTTP(rec, req)
+	}
+	return rec
+}
+
+// do calls the notes API and decodes the JSON response into out, unless
+// it is nil, expecting status.
+func (s *testServer) do(method, path, token string, body any, status int, out any) {
+	s.t.Helper()
+	rec := s.call(method, path, token, body, nil)
+	if rec.Code != status {
+		s.t.Fatalf("%s %s: %d %s, want %d", method, path, rec.Code, rec.Body, status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+// createNote creates a note and returns its id.
+func (s *testServer) createNote(token, title, content string) string {
+	s.t.Helper()
+	var resp struct {
+		ID string `json:"id"`
+	}
+	s.do(http.MethodPost, "/notes", token, NoteReq{Title: title, Content: content}, http.StatusCreated, &resp)
+	return resp.ID
+}
+
+func TestNoteLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user("alice1")
+	bobby := s.user("bobby1")
+
+	id := s.createNote(alice, "groceries", "milk")
+	stored, err := s.store.GetNoteByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored.Content, []byte("milk")) || bytes.Contains(stored.Title, []byte("groceries")) {
+		t.Error("note stored in the clear")
+	}
+
+	var note noteResp
+	s.do(http.MethodGet, "/notes/"+id, alice, nil, http.StatusOK, &note)
+	if note.Title != "groceries" || note.Content != "milk" || note.Revision != 1 {
+		t.Errorf("GET /notes/{id} = %+v", note)
+	}
+	// other users can't tell the note exists
+	s.do(http.MethodGet, "/notes/"+id, bobby, nil, http.StatusNotFound, nil)
+	s.do(http.MethodGet, "/notes/"+id, "", nil, http.StatusUnauthorized, nil)
+
+	content := "milk, eggs"
+	s.do(http.MethodPatch, "/notes/"+id, alice, map[string]any{"content": content}, http.StatusPreconditionRequired, nil)
+	s.do(http.MethodPatch, "/notes/"+id, alice, map[string]any{"content": content, "revision": 1}, http.StatusOK, &note)
+	if note.Title != "groceries" || note.Content != content || note.Revision != 2 {
+		t.Errorf("PATCH /notes/{id} = %+v", note)
+	}
+	s.do(http.MethodPatch, "/notes/"+id, alice, map[string]any{"content": "bread", "revision": 1}, http.StatusPreconditionFailed, nil)
+
+	var list struct {
+		Notes []noteResp `json:"notes"`
+		Total int        `json:"total"`
+	}
+	s.do(http.MethodGet, "/notes", alice, nil, http.StatusOK, &list)
+	if len(list.Notes) != 1 || list.Notes[0].Content != content {
+		t.Errorf("GET /notes = %+v", list)
+	}
+	s.do(http.MethodGet, "/notes", bobby, nil, http.StatusOK, &list)
+	if len(list.Notes) != 0 {
+		t.Errorf("GET /notes listed %d notes of another user", len(list.Notes))
+	}
+}
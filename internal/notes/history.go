@@ -0,0 +1,340 @@
+package notes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"strconv"
+	"time"
+)
+
+// Every update keeps the content and title it replaces as an earlier revision of the
+// note, encrypted as it was. How many are kept, and for how long, is up to
+// each user within the limits of the server; revisions past the limits are
+// pruned when the note is next saved or its history looked at.
+
+// revisionResp describes an earlier revision without its content.
+type revisionResp struct {
+	Revision int64 `json:"revision"`
+	Modified int64 `json:"modified"`
+	Archived int64 `json:"archived"`
+	Size     int64 `json:"size"`
+}
+
+// ListRevisionsHandler lists the earlier revisions of a note, newest first:
+// GET /notes/{id}/revisions.
+func (h *Handler) ListRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.historyNote(w, r)
+	if !ok {
+		return
+	}
+	note := a.note
+	revs, err := h.store.ListNoteRevisions(note.ID, note.Owner)
+	if err != nil {
+		log.Printf("ListNoteRevisions error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch revisions")
+		return
+	}
+	resp := make([]revisionResp, 0, len(revs))
+	for _, rev := range revs {
+		resp = append(resp, revisionResp{Revision: rev.Revision, Modified: rev.Modified, Archived: rev.Archived, Size: rev.Size})
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"current": note.Revision, "revisions": resp})
+}
+
+// GetRevisionHandler returns the title and content of a note at an earlier
+// revision:
+// GET /notes/{id}/revisions/{rev}. The current revision is answered like
+// GET /notes/{id}.
+func (h *Handler) GetRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.historyNote(w, r)
+	if !ok {
+		return
+	}
+	note := a.note
+	rev, err := strconv.ParseInt(r.PathValue("rev"), 10, 64)
+	if err != nil || rev <= 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid revision")
+		return
+	}
+	if rev == note.Revision {
+		h.writeNote(w, r, a)
+		return
+	}
+	old, ok := h.revision(w, r, note, rev)
+	if !ok {
+		return
+	}
+	content, ok := h.decryptWith(w, r, a.key, old.Nonce, old.Content)
+	if !ok {
+		return
+	}
+	title, ok := h.decryptTitle(w, r, a.key, old.TitleNonce, old.Title)
+	if !ok {
+		return
+	}
+	api.JSON(w, http.StatusOK, struct {
+		ID      string `json:"id"`
+		Title   string `json:"title"`
+		Content string `json:"content"`
+		revisionResp
+	}{note.ID, title, content, revisionResp{Revision: old.Revision, Modified: old.Modified, Archived: old.Archived, Size: old.Size}})
+}
+
+// DiffHandler compares two revisions of a note as a unified diff:
+// GET /notes/{id}/diff?from=N&to=M, where to defaults to the current
+// revision.
+func (h *Handler) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.historyNote(w, r)
+	if !ok {
+		return
+	}
+	note := a.note
+	query := r.URL.Query()
+	from, err := strconv.ParseInt(query.Get("from"), 10, 64)
+	if err != nil || from <= 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid from revision")
+		return
+	}
+	to := note.Revision
+	if s := query.Get("to"); s != "" {
+		if to, err = strconv.ParseInt(s, 10, 64); err != nil || to <= 0 {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid to revision")
+			return
+		}
+	}
+	var texts [2]string
+	for i, rev := range []int64{from, to} {
+		nonce, ciphertext := note.Nonce, note.Content
+		if rev != note.Revision {
+			old, ok := h.revision(w, r, note, rev)
+			if !ok {
+				return
+			}
+			nonce, ciphertext = old.Nonce, old.Content
+		}
+		if texts[i], ok = h.decryptWith(w, r, a.key, nonce, ciphertext); !ok {
+			return
+		}
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"diff": unifiedDiff(fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to), texts[0], texts[1]),
+	})
+}
+
+// RestoreHandler makes the content and title of an earlier revision the
+// current ones: POST /notes/{id}/restore with {"revision": N}. Like any
+// update it needs If-Match with the current revision, and keeps what it
+// replaces. The notebook and tags of the note are left alone.
+func (h *Handler) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.historyNote(w, r)
+	if !ok {
+		return
+	}
+	note := a.note
+	var req struct {
+		Revision int64 `json:"revision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Revision <= 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	// the body names the revision to restore, so only If-Match can name the
+	// one the change is based on
+	if r.Header.Get("If-Match") == "" {
+		api.Error(w, r, http.StatusPreconditionRequired, api.CodeRevisionRequired, "If-Match is required")
+		return
+	}
+	base, ok := expectedRevision(w, r, 0)
+	if !ok {
+		return
+	}
+	if req.Revision == note.Revision {
+		api.Error(w, r, http.StatusConflict, api.CodeConflict, "Note is already at that revision")
+		return
+	}
+	old, ok := h.revision(w, r, note, req.Revision)
+	if !ok {
+		return
+	}
+	content, ok := h.decryptWith(w, r, a.key, old.Nonce, old.Content)
+	if !ok {
+		return
+	}
+	title, ok := h.decryptTitle(w, r, a.key, old.TitleNonce, old.Title)
+	if !ok {
+		return
+	}
+	// encrypted again rather than copied, so that the nonce isn't reused
+	restored, ok := h.saveChanges(w, r, a, noteChanges{Content: &content, Title: &title}, base)
+	if !ok {
+		return
+	}
+	h.writeNote(w, r, restored)
+}
+
+// RevisionSettingsHandler shows and changes how many earlier revisions the
+// caller keeps: GET and PUT /account/revisions with {"keep": N, "days": N}.
+// Zero selects the server's limit, which can't be exceeded.
+func (h *Handler) RevisionSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	max := serverRetention()
+	if r.Method == http.MethodPut {
+		var req struct {
+			Keep int `json:"keep"`
+			Days int `json:"days"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Keep < 0 || req.Days < 0 {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+			return
+		}
+		if max.Keep > 0 && req.Keep > max.Keep {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("At most %d revisions can be kept", max.Keep))
+			return
+		}
+		if max.Days > 0 && req.Days > max.Days {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, fmt.Sprintf("Revisions can be kept for at most %d days", max.Days))
+			return
+		}
+		ret := storage.RevisionRetention{Keep: req.Keep, Days: req.Days}
+		if err := h.store.SetRevisionRetention(username, ret); err != nil {
+			log.Printf("SetRevisionRetention error: %v", err)
+			api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save settings")
+			return
+		}
+		if err := h.store.PruneNoteRevisions(username, "", ret.Cap(max), time.Now().Unix()); err != nil {
+			log.Printf("PruneNoteRevisions error: %v", err)
+		}
+	}
+	ret, err := h.store.GetRevisionRetention(username)
+	if err != nil {
+		log.Printf("GetRevisionRetention error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch settings")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]int{
+		"keep":     ret.Keep,
+		"days":     ret.Days,
+		"max_keep": max.Keep,
+		"max_days": max.Days,
+	})
+}
+
+// serverRetention returns the limits set with SCRYPTS_REVISIONS_KEEP and
+// SCRYPTS_REVISIONS_DAYS.
+func serverRetention() storage.RevisionRetention {
+	return storage.RevisionRetention{Keep: config.RevisionsKeep, Days: config.RevisionsDays}
+}
+
+// pruneRevisions drops the revisions of the note that its owner no longer
+// keeps. Failing to is logged rather than reported, as nothing is lost.
+func (h *Handler) pruneRevisions(username, noteID string) {
+	ret, err := h.store.GetRevisionRetention(username)
+	if err == nil {
+		err = h.store.PruneNoteRevisions(username, noteID, ret.Cap(serverRetention()), time.Now().Unix())
+	}
+	if err != nil {
+		log.Printf("PruneNoteRevisions error: %v", err)
+	}
+}
+
+// historyNote fetches the caller's note named in the path, pruning its
+// history first so that nothing past the limits is shown. The history of a
+// shared note is its owner's only.
+func (h *Handler) historyNote(w http.ResponseWriter, r *http.Request) (noteAccess, bool) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return noteAccess{}, false
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return noteAccess{}, false
+	}
+	a, ok := h.ownedAccess(w, r, id, username)
+	if !ok {
+		return noteAccess{}, false
+	}
+	h.pruneRevisions(username, id)
+	return a, true
+}
+
+// revision fetches an earlier revision of the note.
+func (h *Handler) revision(w http.ResponseWriter, r *http.Request, note storage.Note, rev int64) (storage.NoteRevision, bool) {
+	old, err := h.store.GetNoteRevision(note.ID, note.Owner, rev)
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, fmt.Sprintf("Revision %d not found", rev))
+		return storage.NoteRevision{}, false
+	}
+	if err != nil {
+		log.Printf("GetNoteRevision error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch revision")
+		return storage.NoteRevision{}, false
+	}
+	return old, true
+}
+
+// encrypt encrypts text under the key of owner with a fresh nonce.
+func (h *Handler) encrypt(w http.ResponseWriter, r *http.Request, owner, text string) (nonce, ciphertext []byte, ok bool) {
+	userKey, err := h.auth.GetUserKey(owner)
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Missing encryption key")
+		return nil, nil, false
+	}
+	return h.encryptWith(w, r, userKey, text)
+}
+
+// encryptWith encrypts text under key with a fresh nonce.
+func (h *Handler) encryptWith(w http.ResponseWriter, r *http.Request, key []byte, text string) (nonce, ciphertext []byte, ok bool) {
+	var err error
+	if nonce, err = utils.GenerateNonce(12); err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to generate nonce")
+		return nil, nil, false
+	}
+	if ciphertext, err = utils.EncryptAESGCM(key, nonce, []byte(text)); err != nil {
+		log.Printf("EncryptAESGCM error : %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to encrypt note")
+		return nil, nil, false
+	}
+	return nonce, ciphertext, true
+}
+
+// decryptTitle decrypts the title of a note or revision under its key, ""
+// when it has none.
+func (h *Handler) decryptTitle(w http.ResponseWriter, r *http.Request, key, nonce, title []byte) (string, bool) {
+	if len(title) == 0 {
+		return "", true
+	}
+	return h.decryptWith(w, r, key, nonce, title)
+}
+
+// decrypt decrypts what was encrypted under the key of owner.
+func (h *Handler) decrypt(w http.ResponseWriter, r *http.Request, owner string, nonce, content []byte) (string, bool) {
+	userKey, ok := h.userKey(w, r, owner)
+	if !ok {
+		return "", false
+	}
+	return h.decryptWith(w, r, userKey, nonce, content)
+}
+
+// decryptWith decrypts the content of a note or revision under key.
+func (h *Handler) decryptWith(w http.ResponseWriter, r *http.Request, key, nonce, content []byte) (string, bool) {
+	pt, err := utils.DecryptAESGCM(key, nonce, content)
+	if err != nil {
+		log.Printf("DecryptAESGCM error :%v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to decrypt note")
+		return "", false
+	}
+	return string(pt), true
+}
@@ -0,0 +1,71 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// The handlers below serve the original routes, PUT and DELETE /notes with
+// the note id in the JSON body. They are registered only when
+// SCRYPTS_LEGACY_NOTE_ROUTES is set, and don't check revisions, which their
+// clients don't know about.
+
+// LegacyUpdateNoteHandler replaces the content of the note {"id"} of the
+// body, leaving its title, notebook and tags alone.
+func (h *Handler) LegacyUpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	// validate ID and content early
+	if _, err := uuid.Parse(req.ID); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid note id")
+		return
+	}
+	if len(req.Content) > storage.MaxNoteContentSize {
+		api.Error(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Note content too large")
+		return
+	}
+	existing, ok := h.ownedAccess(w, r, req.ID, username)
+	if !ok {
+		return
+	}
+	if _, ok := h.saveChanges(w, r, existing, noteChanges{Content: &req.Content}, 0); !ok {
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// LegacyDeleteNoteHandler moves the note {"id"} of the body to the trash.
+func (h *Handler) LegacyDeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	if _, err := uuid.Parse(req.ID); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid note id")
+		return
+	}
+	h.trashNote(w, r, req.ID, username, 0)
+}
@@ -0,0 +1,293 @@
+package notes
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Public links share a snapshot of a note with people without an account,
+// PrivateBin-style. The title and content are encrypted with AES-256-GCM
+// under a random key that is returned to the owner, once, to be put in the
+// fragment of the link's URL, which browsers don't send: the server keeps
+// only the ciphertext, and the page the link opens fetches it and decrypts
+// it in the browser. With a password the key is derived with
+// PBKDF2-SHA256 from the random key followed by the password, so neither
+// is enough alone.
+
+const (
+	defaultLinkLifetime = 24 * time.Hour
+	minLinkLifetime     = time.Minute
+	maxLinkPassword     = 1024
+	// OWASP's recommendation for PBKDF2-HMAC-SHA256
+	linkIterations = 600000
+)
+
+// linkSnapshot is what a link's ciphertext decrypts to, as JSON.
+type linkSnapshot struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type linkResp struct {
+	ID       string `json:"id"`
+	Note     string `json:"note"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+	MaxViews int64  `json:"max_views,omitempty"`
+	Views    int64  `json:"views"`
+	Password bool   `json:"password"`
+	// when the link is made only
+	Key string `json:"key,omitempty"`
+}
+
+func newLinkResp(l storage.Link) linkResp {
+	return linkResp{
+		ID:       l.ID,
+		Note:     l.NoteID,
+		Created:  l.Created,
+		Expires:  l.Expires,
+		MaxViews: l.MaxViews,
+		Views:    l.Views,
+		Password: l.Salt != nil,
+	}
+}
+
+// linkKey returns the key a link is encrypted under: key itself, or with a
+// password, derived from both.
+func linkKey(key []byte, password string, salt []byte, iterations int) ([]byte, error) {
+	if password == "" {
+		return key, nil
+	}
+	return pbkdf2.Key(sha256.New, string(key)+password, salt, iterations, 32)
+}
+
+// CreateLinkHandler makes a public link to a snapshot of one of the
+// caller's notes: POST /notes/{id}/links with {"expires_in": seconds,
+// "max_views": N, "password": "..."}, all optional. The key of the link is
+// in the answer and nowhere else.
+func (h *Handler) CreateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ExpiresIn int64  `json:"expires_in"`
+		MaxViews  int64  `json:"max_views"`
+		Password  string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	lifetime := defaultLinkLifetime
+	if req.ExpiresIn != 0 {
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+	}
+	maxLifetime := time.Duration(config.LinkMaxDays) * 24 * time.Hour
+	if req.ExpiresIn < 0 || lifetime < minLinkLifetime || lifetime > maxLifetime {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid expires_in")
+		return
+	}
+	if req.MaxViews < 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid max_views")
+		return
+	}
+	if len(req.Password) > maxLinkPassword {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Password too long")
+		return
+	}
+	a, ok := h.ownedAccess(w, r, id, username)
+	if !ok {
+		return
+	}
+	content, ok := h.decryptWith(w, r, a.key, a.note.Nonce, a.note.Content)
+	if !ok {
+		return
+	}
+	title, ok := h.decryptTitle(w, r, a.key, a.note.TitleNonce, a.note.Title)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	l := storage.Link{
+		ID:       uuid.New().String(),
+		NoteID:   id,
+		Owner:    username,
+		Created:  now.Unix(),
+		Expires:  now.Add(lifetime).Unix(),
+		MaxViews: req.MaxViews,
+	}
+	key, err := utils.GenerateNonce(32)
+	if err == nil && req.Password != "" {
+		l.Iterations = linkIterations
+		l.Salt, err = utils.GenerateNonce(16)
+	}
+	var encKey, snapshot []byte
+	if err == nil {
+		encKey, err = linkKey(key, req.Password, l.Salt, l.Iterations)
+	}
+	if err == nil {
+		snapshot, err = json.Marshal(linkSnapshot{Title: title, Content: content})
+	}
+	if err == nil {
+		l.Nonce, err = utils.GenerateNonce(12)
+	}
+	if err == nil {
+		l.Ciphertext, err = utils.EncryptAESGCM(encKey, l.Nonce, snapshot)
+	}
+	if err != nil {
+		log.Printf("link encryption error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to encrypt note")
+		return
+	}
+	if err := h.store.CreateLink(l); err == storage.ErrNotFound {
+		// trashed in the meantime
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	} else if err != nil {
+		log.Printf("CreateLink error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to save link")
+		return
+	}
+	resp := newLinkResp(l)
+	resp.Key = base64.RawURLEncoding.EncodeToString(key)
+	w.Header().Set("Location", api.Prefix+"/links/"+l.ID)
+	api.JSON(w, http.StatusCreated, resp)
+}
+
+// ListLinksHandler lists the links to one of the caller's notes that
+// haven't expired, newest first: GET /notes/{id}/links.
+func (h *Handler) ListLinksHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
+		return
+	}
+	links, err := h.store.ListLinks(id, time.Now().Unix())
+	if err != nil {
+		log.Printf("ListLinks error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch links")
+		return
+	}
+	resp := make([]linkResp, 0, len(links))
+	for _, l := range links {
+		resp = append(resp, newLinkResp(l))
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"links": resp})
+}
+
+// DeleteLinkHandler revokes a link to one of the caller's notes:
+// DELETE /notes/{id}/links/{lid}.
+func (h *Handler) DeleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := uuid.Parse(r.PathValue("lid")); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid link id")
+		return
+	}
+	if err := h.store.DeleteLink(r.PathValue("lid"), id, username); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Link not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteLink error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to delete link")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ViewLinkHandler answers with the ciphertext of a link, counting a view:
+// GET /links/{id}. It needs no token; links that don't exist, have expired
+// or have run out of views are all not found.
+func (h *Handler) ViewLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Link not found")
+		return
+	}
+	l, err := h.store.ViewLink(id, time.Now().Unix())
+	if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Link not found")
+		return
+	} else if err != nil {
+		log.Printf("ViewLink error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch link")
+		return
+	}
+	resp := struct {
+		Ciphertext []byte `json:"ciphertext"`
+		Nonce      []byte `json:"nonce"`
+		Salt       []byte `json:"salt,omitempty"`
+		Iterations int    `json:"iterations,omitempty"`
+		Expires    int64  `json:"expires"`
+		ViewsLeft  *int64 `json:"views_left,omitempty"`
+	}{Ciphertext: l.Ciphertext, Nonce: l.Nonce, Salt: l.Salt, Iterations: l.Iterations, Expires: l.Expires}
+	if l.MaxViews > 0 {
+		left := l.MaxViews - l.Views
+		resp.ViewsLeft = &left
+	}
+	// every answer is a view; nothing in between may keep a copy
+	w.Header().Set("Cache-Control", "no-store")
+	api.JSON(w, http.StatusOK, resp)
+}
+
+// LinkPurger deletes the links that have expired, every Interval.
+type LinkPurger struct {
+	Store    storage.Store
+	Interval time.Duration
+}
+
+// Start runs the purger in the background for the life of the process.
+func (p *LinkPurger) Start() {
+	go func() {
+		for {
+			if err := p.RunOnce(); err != nil {
+				log.Printf("link purge error: %v", err)
+			}
+			time.Sleep(p.Interval)
+		}
+	}()
+}
+
+// RunOnce purges expired links once.
+func (p *LinkPurger) RunOnce() error {
+	n, err := p.Store.PurgeLinks(time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("links: purged %d expired link(s)", n)
+	}
+	return nil
+}
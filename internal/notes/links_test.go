@@ -0,0 +1,48 @@
+package notes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"scrypts/internal/utils"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user("alice1")
+	id := s.createNote(alice, "recipe", "flour, water")
+
+	var link linkResp
+	s.do(http.MethodPost, "/notes/"+id+"/links", alice, map[string]any{"max_views": 1}, http.StatusCreated, &link)
+	key, err := base64.RawURLEncoding.DecodeString(link.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed struct {
+		Ciphertext []byte `json:"ciphertext"`
+		Nonce      []byte `json:"nonce"`
+		ViewsLeft  *int64 `json:"views_left"`
+	}
+	s.do(http.MethodGet, "/links/"+link.ID, "", nil, http.StatusOK, &sealed)
+	plain, err := utils.DecryptAESGCM(key, sealed.Nonce, sealed.Ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot linkSnapshot
+	if err := json.Unmarshal(plain, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Title != "recipe" || snapshot.Content != "flour, water" {
+		t.Errorf("link snapshot = %+v", snapshot)
+	}
+	if sealed.ViewsLeft == nil || *sealed.ViewsLeft != 0 {
+		t.Errorf("views_left = %v, want 0", sealed.ViewsLeft)
+	}
+	s.do(http.MethodGet, "/links/"+link.ID, "", nil, http.StatusNotFound, nil)
+
+	s.do(http.MethodPost, "/notes/"+id+"/links", alice, map[string]any{"expires_in": 1}, http.StatusBadRequest, nil)
+	bobby := s.user("bobby1")
+	s.do(http.MethodPost, "/notes/"+id+"/links", bobby, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,124 @@
+package notes
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"scrypts/internal/storage"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DefaultPageSize is the page size of the notes list when no limit is given.
+const DefaultPageSize = 50
+
+// parseListQuery reads the notes list parameters: limit, cursor, sort
+// (created or modified, and deleted for the trash), order (asc or desc) and
+// the time filters created_after, created_before, modified_after and
+// modified_before, given as Unix times. notebook keeps the notes directly
+// in a notebook, and tag, which can be repeated, those with all of the
+// tags. The trash is sorted by deletion time unless asked otherwise.
+func parseListQuery(v url.Values, trashed bool) (storage.NoteQuery, error) {
+	q := storage.NoteQuery{Limit: DefaultPageSize, SortBy: storage.SortCreated, Trashed: trashed}
+	if trashed {
+		q.SortBy = storage.SortDeleted
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > storage.MaxNotesPage {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+	switch s := v.Get("sort"); s {
+	case "":
+	case storage.SortCreated, storage.SortModified:
+		q.SortBy = s
+	case storage.SortDeleted:
+		if !trashed {
+			return q, errors.New("invalid sort")
+		}
+		q.SortBy = s
+	default:
+		return q, errors.New("invalid sort")
+	}
+	switch v.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return q, errors.New("invalid order")
+	}
+	for _, f := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"created_after", &q.CreatedAfter},
+		{"created_before", &q.CreatedBefore},
+		{"modified_after", &q.ModifiedAfter},
+		{"modified_before", &q.ModifiedBefore},
+	} {
+		if s := v.Get(f.name); s != "" {
+			t, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || t <= 0 {
+				return q, errors.New("invalid " + f.name)
+			}
+			*f.dst = t
+		}
+	}
+	if s := v.Get("notebook"); s != "" {
+		if _, err := uuid.Parse(s); err != nil {
+			return q, errors.New("invalid notebook")
+		}
+		q.Notebook = s
+	}
+	for _, s := range v["tag"] {
+		if _, err := uuid.Parse(s); err != nil {
+			return q, errors.New("invalid tag")
+		}
+		q.Tags = append(q.Tags, s)
+	}
+	if len(q.Tags) > storage.MaxNoteTags {
+		return q, errors.New("too many tags")
+	}
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(q, s)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		q.After = c
+	}
+	return q, nil
+}
+
+// A cursor also records the order it was made for, so that it isn't used
+// with another one.
+func cursorOrder(q storage.NoteQuery) string {
+	if q.Asc {
+		return q.SortBy + ".asc"
+	}
+	return q.SortBy + ".desc"
+}
+
+func encodeCursor(q storage.NoteQuery, c *storage.NoteCursor) string {
+	s := cursorOrder(q) + ":" + strconv.FormatInt(c.Time, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(q storage.NoteQuery, s string) (*storage.NoteCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(b), ":", 3)
+	if len(parts) != 3 || parts[0] != cursorOrder(q) {
+		return nil, errors.New("cursor doesn't match the order")
+	}
+	t, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.NoteCursor{Time: t, ID: parts[2]}, nil
+}
@@ -0,0 +1,276 @@
+package notes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notebooks hold notes and other notebooks. Their names are encrypted under
+// the owner's key like notes, and are unique among the notebooks of the same
+// parent. Deleting a notebook moves what it holds up to its parent.
+
+// notebookResp is a decrypted notebook as the API returns it.
+type notebookResp struct {
+	ID       string `json:"id"`
+	Parent   string `json:"parent,omitempty"`
+	Name     string `json:"name"`
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+}
+
+// CreateNotebookHandler adds a notebook: POST /notebooks with
+// {"name": "...", "parent": "<id>"}, the parent being optional.
+func (h *Handler) CreateNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		Name   string `json:"name"`
+		Parent string `json:"parent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	name, ok := checkName(w, r, req.Name)
+	if !ok {
+		return
+	}
+	if req.Parent != "" {
+		if _, ok := h.userNotebook(w, r, req.Parent, username, http.StatusBadRequest); !ok {
+			return
+		}
+	}
+	now := time.Now().Unix()
+	nb := storage.Notebook{ID: uuid.New().String(), Owner: username, Parent: req.Parent, Created: now, Modified: now}
+	if !h.uniqueNotebookName(w, r, nb, name) {
+		return
+	}
+	if nb.Nonce, nb.Name, ok = h.encrypt(w, r, username, name); !ok {
+		return
+	}
+	if err := h.store.CreateNotebook(nb); err != nil {
+		h.notebookError(w, r, err, "CreateNotebook", "Failed to create notebook")
+		return
+	}
+	w.Header().Set("Location", api.Prefix+"/notebooks/"+nb.ID)
+	api.JSON(w, http.StatusCreated, notebookResp{ID: nb.ID, Parent: nb.Parent, Name: name, Created: nb.Created, Modified: nb.Modified})
+}
+
+// ListNotebooksHandler lists all of the caller's notebooks, oldest first:
+// GET /notebooks. The hierarchy is given by their parents.
+func (h *Handler) ListNotebooksHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	notebooks, err := h.store.ListNotebooks(username)
+	if err != nil {
+		log.Printf("ListNotebooks error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch notebooks")
+		return
+	}
+	resp := make([]notebookResp, 0, len(notebooks))
+	for _, nb := range notebooks {
+		name, ok := h.decrypt(w, r, username, nb.Nonce, nb.Name)
+		if !ok {
+			return
+		}
+		resp = append(resp, notebookResp{ID: nb.ID, Parent: nb.Parent, Name: name, Created: nb.Created, Modified: nb.Modified})
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"notebooks": resp})
+}
+
+// GetNotebookHandler returns one of the caller's notebooks:
+// GET /notebooks/{id}.
+func (h *Handler) GetNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	nb, ok := h.userNotebook(w, r, r.PathValue("id"), username, http.StatusNotFound)
+	if !ok {
+		return
+	}
+	h.writeNotebook(w, r, nb)
+}
+
+// UpdateNotebookHandler renames a notebook or moves it:
+// PATCH /notebooks/{id} with {"name": "...", "parent": "<id>"}, either
+// being optional. A parent of "" moves the notebook to the top.
+func (h *Handler) UpdateNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		Name   *string `json:"name"`
+		Parent *string `json:"parent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	if req.Name == nil && req.Parent == nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Nothing to update")
+		return
+	}
+	nb, ok := h.userNotebook(w, r, r.PathValue("id"), username, http.StatusNotFound)
+	if !ok {
+		return
+	}
+	if req.Parent != nil {
+		if *req.Parent != "" {
+			if _, ok := h.userNotebook(w, r, *req.Parent, username, http.StatusBadRequest); !ok {
+				return
+			}
+		}
+		nb.Parent = *req.Parent
+	}
+	var name string
+	if req.Name != nil {
+		if name, ok = checkName(w, r, *req.Name); !ok {
+			return
+		}
+	} else if name, ok = h.decrypt(w, r, username, nb.Nonce, nb.Name); !ok {
+		return
+	}
+	if !h.uniqueNotebookName(w, r, nb, name) {
+		return
+	}
+	if req.Name != nil {
+		if nb.Nonce, nb.Name, ok = h.encrypt(w, r, username, name); !ok {
+			return
+		}
+	}
+	nb.Modified = time.Now().Unix()
+	if err := h.store.UpdateNotebook(nb); err != nil {
+		h.notebookError(w, r, err, "UpdateNotebook", "Failed to update notebook")
+		return
+	}
+	api.JSON(w, http.StatusOK, notebookResp{ID: nb.ID, Parent: nb.Parent, Name: name, Created: nb.Created, Modified: nb.Modified})
+}
+
+// DeleteNotebookHandler deletes a notebook, moving its notes and notebooks
+// up to its parent: DELETE /notebooks/{id}.
+func (h *Handler) DeleteNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid notebook id")
+		return
+	}
+	if err := h.store.DeleteNotebook(id, username); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Notebook not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteNotebook error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to delete notebook")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// userNotebook fetches the notebook id of username. A missing notebook, or
+// one of another user, is answered with status: 404 when it is the one the
+// path names, 400 when the body refers to it.
+func (h *Handler) userNotebook(w http.ResponseWriter, r *http.Request, id, username string, status int) (storage.Notebook, bool) {
+	msg, code := "Notebook not found", api.CodeNotFound
+	if status == http.StatusBadRequest {
+		msg, code = "Unknown notebook", api.CodeInvalidRequest
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid notebook id")
+		return storage.Notebook{}, false
+	}
+	nb, err := h.store.GetNotebook(id)
+	if err == storage.ErrNotFound || (err == nil && nb.Owner != username) {
+		api.Error(w, r, status, code, msg)
+		return storage.Notebook{}, false
+	}
+	if err != nil {
+		log.Printf("GetNotebook error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to query notebook")
+		return storage.Notebook{}, false
+	}
+	return nb, true
+}
+
+// uniqueNotebookName answers 409 when another notebook of the same parent
+// as nb is called name.
+func (h *Handler) uniqueNotebookName(w http.ResponseWriter, r *http.Request, nb storage.Notebook, name string) bool {
+	notebooks, err := h.store.ListNotebooks(nb.Owner)
+	if err != nil {
+		log.Printf("ListNotebooks error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch notebooks")
+		return false
+	}
+	for _, other := range notebooks {
+		if other.ID == nb.ID || other.Parent != nb.Parent {
+			continue
+		}
+		otherName, ok := h.decrypt(w, r, nb.Owner, other.Nonce, other.Name)
+		if !ok {
+			return false
+		}
+		if otherName == name {
+			api.Error(w, r, http.StatusConflict, api.CodeConflict, "A notebook with that name already exists there")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) writeNotebook(w http.ResponseWriter, r *http.Request, nb storage.Notebook) {
+	name, ok := h.decrypt(w, r, nb.Owner, nb.Nonce, nb.Name)
+	if !ok {
+		return
+	}
+	api.JSON(w, http.StatusOK, notebookResp{ID: nb.ID, Parent: nb.Parent, Name: name, Created: nb.Created, Modified: nb.Modified})
+}
+
+// notebookError answers for an error of CreateNotebook or UpdateNotebook.
+func (h *Handler) notebookError(w http.ResponseWriter, r *http.Request, err error, op, msg string) {
+	switch err {
+	case storage.ErrNotebookCycle:
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "A notebook can't be moved into itself or a notebook inside it")
+	case storage.ErrNotebookDepth:
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Notebooks nest too deeply")
+	case storage.ErrNotFound:
+		// deleted in the meantime
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Notebook not found")
+	default:
+		log.Printf("%s error: %v", op, err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, msg)
+	}
+}
+
+// checkName trims the name of a notebook or tag and checks it.
+func checkName(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Name is required")
+		return "", false
+	}
+	if len(name) > storage.MaxNameSize {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Name too long")
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,70 @@
+package notes
+
+import (
+	"fmt"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+	"strconv"
+	"strings"
+)
+
+// A note's ETag is its revision, quoted. Changes must name the revision they
+// are based on, in If-Match or in a revision field, so that two clients
+// editing the same note can't overwrite each other unnoticed: the second
+// one gets 412 and has to fetch the note again.
+
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// expectedRevision returns the revision a change is based on, taken from
+// If-Match or else from field, the revision given in the body or query (0
+// when absent). "If-Match: *" returns 0, which skips the check. It answers
+// 428 when there is neither and 412 when If-Match can't match any revision.
+func expectedRevision(w http.ResponseWriter, r *http.Request, field int64) (int64, bool) {
+	if field < 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid revision")
+		return 0, false
+	}
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		if field == 0 {
+			api.Error(w, r, http.StatusPreconditionRequired, api.CodeRevisionRequired,
+				"If-Match or revision is required")
+			return 0, false
+		}
+		return field, true
+	}
+	if match == "*" {
+		return field, true
+	}
+	if strings.Contains(match, ",") {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "If-Match takes a single entity tag")
+		return 0, false
+	}
+	// weak tags never match, as If-Match compares strongly
+	rev, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(match, `"`), `"`), 10, 64)
+	if err != nil || rev <= 0 || etag(rev) != match {
+		api.Error(w, r, http.StatusPreconditionFailed, api.CodeRevisionMismatch, "If-Match doesn't match the note")
+		return 0, false
+	}
+	if field != 0 && field != rev {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "If-Match and revision disagree")
+		return 0, false
+	}
+	return rev, true
+}
+
+// revisionError answers for an error of UpdateNote or DeleteNote.
+func revisionError(w http.ResponseWriter, r *http.Request, err error, revision int64, action string) {
+	switch err {
+	case storage.ErrNotFound:
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+	case storage.ErrConflict:
+		api.Error(w, r, http.StatusPreconditionFailed, api.CodeRevisionMismatch,
+			fmt.Sprintf("Note was changed since revision %d", revision))
+	default:
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to "+action+" note")
+	}
+}
@@ -0,0 +1,180 @@
+package notes
+
+import (
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/config"
+	"scrypts/internal/search"
+	"scrypts/internal/storage"
+	"strconv"
+)
+
+// By default notes are searched through a blind index: the server keeps a
+// keyed hash of each word of a note's title and content, under a key only
+// it can derive from the owner's, so it can find the notes with a query's
+// words without storing the words, and decrypts only those. The full-text
+// mode keeps such hashes in an FTS5 index instead; see search/fts.go.
+
+// SearchHandler returns the caller's notes with all of the words of q,
+// most recently modified first: GET /notes/search?q=...&limit=N. Words
+// match whole and regardless of case. With SCRYPTS_SEARCH=fts q may also
+// hold "phrases" and prefix* words, and notes come best first with a
+// snippet of each.
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	query := r.URL.Query()
+	limit := DefaultPageSize
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > storage.MaxNotesPage {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "invalid limit")
+			return
+		}
+	}
+	if config.SearchMode == config.SearchFTS {
+		h.searchFullText(w, r, username, query.Get("q"), limit)
+		return
+	}
+	words := search.Words(query.Get("q"))
+	if len(words) == 0 {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Nothing to search for")
+		return
+	}
+	if len(words) > storage.MaxSearchTerms {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Too many words")
+		return
+	}
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return
+	}
+	key, err := search.Key(userKey)
+	if err != nil {
+		log.Printf("search.Key error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return
+	}
+	found, err := h.store.SearchNotes(username, search.Terms(key, words), limit)
+	if err != nil {
+		log.Printf("SearchNotes error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to search notes")
+		return
+	}
+	resp, ok := h.noteResps(w, r, username, found)
+	if !ok {
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"notes": resp})
+}
+
+// indexNote sets the search terms of note, or its full-text entry, once
+// changes are applied to it, decrypting the title or content under key when
+// changes leave it as it was. The index is always under the owner's key.
+func (h *Handler) indexNote(w http.ResponseWriter, r *http.Request, note *storage.Note, changes noteChanges, key []byte) bool {
+	var title, content string
+	var ok bool
+	if changes.Title != nil {
+		title = *changes.Title
+	} else if title, ok = h.decryptTitle(w, r, key, note.TitleNonce, note.Title); !ok {
+		return false
+	}
+	if changes.Content != nil {
+		content = *changes.Content
+	} else if content, ok = h.decryptWith(w, r, key, note.Nonce, note.Content); !ok {
+		return false
+	}
+	userKey, err := h.auth.GetUserKey(note.Owner)
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Missing encryption key")
+		return false
+	}
+	if config.SearchMode == config.SearchFTS {
+		var doc storage.FTSDoc
+		if doc, err = search.Document(userKey, title, content); err == nil {
+			note.FTS = &doc
+		}
+	} else {
+		note.Terms, err = search.TextTerms(userKey, title, content)
+	}
+	if err != nil {
+		log.Printf("search index error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to index note")
+		return false
+	}
+	return true
+}
+
+// searchFullText answers GET /notes/search from the full-text index.
+func (h *Handler) searchFullText(w http.ResponseWriter, r *http.Request, username, q string, limit int) {
+	fq, err := search.ParseQuery(q)
+	if err == search.ErrEmptyQuery {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Nothing to search for")
+		return
+	} else if err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Too many words")
+		return
+	}
+	ft, ok := h.store.(storage.FullText)
+	if !ok {
+		api.Error(w, r, http.StatusNotImplemented, api.CodeNotImplemented, "Full-text search is not available")
+		return
+	}
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return
+	}
+	match, err := fq.Match(userKey)
+	if err != nil {
+		log.Printf("search.Match error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return
+	}
+	type hit struct {
+		noteResp
+		Snippet string `json:"snippet"`
+	}
+	// long prefixes are looked up by their beginning only, so some of the
+	// notes found may not match; more are fetched until there are enough.
+	// Only the best MaxNotesPage candidates are ever checked.
+	var resp []hit
+	for fetch := limit; ; fetch = min(fetch*4, storage.MaxNotesPage) {
+		found, err := ft.SearchNotesFTS(username, match, fetch)
+		if err != nil {
+			log.Printf("SearchNotesFTS error: %v", err)
+			api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to search notes")
+			return
+		}
+		notes, ok := h.noteResps(w, r, username, found)
+		if !ok {
+			return
+		}
+		resp = make([]hit, 0, limit)
+		for _, n := range notes {
+			if len(resp) == limit {
+				break
+			}
+			if fq.Matches(n.Title, n.Content) {
+				resp = append(resp, hit{n, fq.Snippet(n.Content)})
+			}
+		}
+		if len(resp) == limit || len(found) < fetch || fetch == storage.MaxNotesPage {
+			break
+		}
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"notes": resp})
+}
+
+// userKey fetches the key of username.
+func (h *Handler) userKey(w http.ResponseWriter, r *http.Request, username string) ([]byte, bool) {
+	userKey, err := h.auth.GetUserKey(username)
+	if err != nil {
+		log.Printf("Get User Key error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return nil, false
+	}
+	return userKey, true
+}
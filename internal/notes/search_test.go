@@ -0,0 +1,58 @@
+package notes
+
+import (
+	"net/http"
+	"path/filepath"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"testing"
+)
+
+func TestSearchFullText(t *testing.T) {
+	db, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "scrypts.db"), storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	config.SearchMode = config.SearchFTS
+	defer func() { config.SearchMode = config.SearchBlind }()
+	s := newTestServerOn(t, db)
+	alice := s.user("alice1")
+
+	// prefixes are looked up by their first 16 characters, which the titles
+	// share: they rank first and are then found not to match
+	for i := 0; i < 5; i++ {
+		s.createNote(alice, "internationalizable", "a word")
+	}
+	want := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		want[s.createNote(alice, "", "notes on internationalization")] = true
+	}
+
+	var resp struct {
+		Notes []struct {
+			ID      string `json:"id"`
+			Snippet string `json:"snippet"`
+		} `json:"notes"`
+	}
+	s.do(http.MethodGet, "/notes/search?q=internationalizat*&limit=2", alice, nil, http.StatusOK, &resp)
+	if len(resp.Notes) != 2 {
+		t.Fatalf("found %d notes, want 2", len(resp.Notes))
+	}
+	for _, n := range resp.Notes {
+		if !want[n.ID] || n.Snippet != "notes on <mark>internationalization</mark>" {
+			t.Errorf("found %+v", n)
+		}
+	}
+	s.do(http.MethodGet, "/notes/search?q=internationalizat*&limit=10", alice, nil, http.StatusOK, &resp)
+	if len(resp.Notes) != 3 {
+		t.Errorf("found %d notes, want 3", len(resp.Notes))
+	}
+	s.do(http.MethodGet, "/notes/search?q=internationaliza*", alice, nil, http.StatusOK, &resp)
+	if len(resp.Notes) != 8 {
+		t.Errorf("found %d notes by a 16 character prefix, want 8", len(resp.Notes))
+	}
+}
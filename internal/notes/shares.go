@@ -0,0 +1,414 @@
+package notes
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"time"
+)
+
+// A note is encrypted under its owner's key until it is first shared. It is
+// then given a key of its own, which its content, title and revisions are
+// encrypted under again: the owner keeps it wrapped under their key, and
+// each user it is shared with gets it sealed to their public key. The
+// private key of that keypair is wrapped under its user's key, so a note
+// key is only ever opened by its owner or one of its recipients. Search
+// terms stay under the owner's key, so shared notes aren't found by the
+// users they are shared with.
+
+// noteAccess is a note as the caller may see it, with the key it is
+// encrypted under.
+type noteAccess struct {
+	note       storage.Note
+	key        []byte
+	permission string // "" for the owner
+}
+
+// resp is the note in an answer, once decrypted. The notebook and tags of
+// a note shared with the caller are its owner's and are left out.
+func (a noteAccess) resp(title, content string) noteResp {
+	nr := newNoteResp(a.note, title, content)
+	if a.permission != "" {
+		nr.Notebook, nr.Tags = "", []string{}
+		nr.Permission = a.permission
+	}
+	return nr
+}
+
+// noteKeyUnder returns the key a note is encrypted under, given its owner's
+// key.
+func noteKeyUnder(userKey []byte, note storage.Note) ([]byte, error) {
+	if len(note.Key) == 0 {
+		return userKey, nil
+	}
+	return utils.UnwrapKey(userKey, note.KeyNonce, note.Key)
+}
+
+// ownerAccess returns the access of its owner to note.
+func (h *Handler) ownerAccess(w http.ResponseWriter, r *http.Request, note storage.Note) (noteAccess, bool) {
+	userKey, ok := h.userKey(w, r, note.Owner)
+	if !ok {
+		return noteAccess{}, false
+	}
+	key, err := noteKeyUnder(userKey, note)
+	if err != nil {
+		log.Printf("UnwrapKey error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return noteAccess{}, false
+	}
+	return noteAccess{note: note, key: key}, true
+}
+
+// ownedAccess fetches the note id of username, as ownedNote, with its key.
+func (h *Handler) ownedAccess(w http.ResponseWriter, r *http.Request, id, username string) (noteAccess, bool) {
+	note, ok := h.ownedNote(w, r, id, username)
+	if !ok {
+		return noteAccess{}, false
+	}
+	return h.ownerAccess(w, r, note)
+}
+
+// noteAccess fetches the note id of username, or a note shared with them,
+// which they must be allowed to write when write is set. Notes shared with
+// others, and shared notes in the trash, are reported as not found; a
+// note shared for reading only is forbidden to write.
+func (h *Handler) noteAccess(w http.ResponseWriter, r *http.Request, id, username string, write bool) (noteAccess, bool) {
+	sh, err := h.store.GetShare(id, username)
+	if err == storage.ErrNotFound {
+		return h.ownedAccess(w, r, id, username)
+	} else if err != nil {
+		log.Printf("GetShare error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to query note")
+		return noteAccess{}, false
+	}
+	note, ok := h.ownedNote(w, r, id, sh.Owner)
+	if !ok {
+		return noteAccess{}, false
+	}
+	if write && sh.Permission != storage.PermWrite {
+		api.Error(w, r, http.StatusForbidden, api.CodeForbidden, "Note is shared read-only")
+		return noteAccess{}, false
+	}
+	key, ok := h.sharedKey(w, r, sh)
+	if !ok {
+		return noteAccess{}, false
+	}
+	return noteAccess{note: note, key: key, permission: sh.Permission}, true
+}
+
+// sharedKey opens the note key of a share with its recipient's private key.
+func (h *Handler) sharedKey(w http.ResponseWriter, r *http.Request, sh storage.Share) ([]byte, bool) {
+	kp, ok := h.keyPair(w, r, sh.Recipient)
+	if !ok {
+		return nil, false
+	}
+	userKey, ok := h.userKey(w, r, sh.Recipient)
+	if !ok {
+		return nil, false
+	}
+	private, err := utils.UnwrapKey(userKey, kp.PrivateNonce, kp.PrivateKey)
+	if err == nil {
+		var key []byte
+		if key, err = utils.OpenKey(private, sh.Key); err == nil {
+			return key, true
+		}
+	}
+	log.Printf("shared key error: %v", err)
+	api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+	return nil, false
+}
+
+// keyPair fetches the keypair of username, making one the first time.
+func (h *Handler) keyPair(w http.ResponseWriter, r *http.Request, username string) (storage.KeyPair, bool) {
+	kp, err := h.store.GetKeyPair(username)
+	if err == nil {
+		return kp, true
+	} else if err != storage.ErrNotFound {
+		log.Printf("GetKeyPair error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return storage.KeyPair{}, false
+	}
+	userKey, ok := h.userKey(w, r, username)
+	if !ok {
+		return storage.KeyPair{}, false
+	}
+	public, private, err := utils.GenerateKeyPair()
+	if err == nil {
+		kp = storage.KeyPair{Username: username, PublicKey: public, Created: time.Now().Unix()}
+		if kp.PrivateKey, kp.PrivateNonce, err = utils.WrapKey(userKey, private); err == nil {
+			err = h.store.CreateKeyPair(kp)
+			if err == storage.ErrConflict {
+				// made by another request meanwhile
+				kp, err = h.store.GetKeyPair(username)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("key pair error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return storage.KeyPair{}, false
+	}
+	return kp, true
+}
+
+// keyNote gives the note of a a key of its own, unless it has one, encrypting
+// its content, title and revisions again under it.
+func (h *Handler) keyNote(w http.ResponseWriter, r *http.Request, a noteAccess) (noteAccess, bool) {
+	note := a.note
+	if len(note.Key) > 0 {
+		return a, true
+	}
+	userKey := a.key
+	key, err := utils.GenerateNonce(32)
+	if err != nil {
+		log.Printf("GenerateNonce error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return noteAccess{}, false
+	}
+	if note.Key, note.KeyNonce, err = utils.WrapKey(userKey, key); err != nil {
+		log.Printf("WrapKey error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Server error")
+		return noteAccess{}, false
+	}
+	var ok bool
+	if note.Nonce, note.Content, ok = h.reencrypt(w, r, userKey, key, note.Nonce, note.Content); !ok {
+		return noteAccess{}, false
+	}
+	if note.TitleNonce, note.Title, ok = h.reencrypt(w, r, userKey, key, note.TitleNonce, note.Title); !ok {
+		return noteAccess{}, false
+	}
+	infos, err := h.store.ListNoteRevisions(note.ID, note.Owner)
+	if err != nil {
+		log.Printf("ListNoteRevisions error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return noteAccess{}, false
+	}
+	revs := make([]storage.NoteRevision, 0, len(infos))
+	for _, info := range infos {
+		rev, err := h.store.GetNoteRevision(note.ID, note.Owner, info.Revision)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			log.Printf("GetNoteRevision error: %v", err)
+			api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+			return noteAccess{}, false
+		}
+		if rev.Nonce, rev.Content, ok = h.reencrypt(w, r, userKey, key, rev.Nonce, rev.Content); !ok {
+			return noteAccess{}, false
+		}
+		if rev.TitleNonce, rev.Title, ok = h.reencrypt(w, r, userKey, key, rev.TitleNonce, rev.Title); !ok {
+			return noteAccess{}, false
+		}
+		revs = append(revs, rev)
+	}
+	if err := h.store.SetNoteKey(note, revs); err == storage.ErrConflict {
+		api.Error(w, r, http.StatusConflict, api.CodeConflict, "Note changed meanwhile, try again")
+		return noteAccess{}, false
+	} else if err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return noteAccess{}, false
+	} else if err != nil {
+		log.Printf("SetNoteKey error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return noteAccess{}, false
+	}
+	return noteAccess{note: note, key: key}, true
+}
+
+// reencrypt decrypts what was encrypted under from and encrypts it under
+// to with a fresh nonce. Nothing, as for a note without a title, stays
+// nothing.
+func (h *Handler) reencrypt(w http.ResponseWriter, r *http.Request, from, to, nonce, ciphertext []byte) ([]byte, []byte, bool) {
+	if len(ciphertext) == 0 {
+		return nil, nil, true
+	}
+	text, ok := h.decryptWith(w, r, from, nonce, ciphertext)
+	if !ok {
+		return nil, nil, false
+	}
+	return h.encryptWith(w, r, to, text)
+}
+
+type shareResp struct {
+	Recipient  string `json:"recipient"`
+	Permission string `json:"permission"`
+	Created    int64  `json:"created"`
+}
+
+// ListSharesHandler lists whom one of the caller's notes is shared with:
+// GET /notes/{id}/shares.
+func (h *Handler) ListSharesHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := h.ownedNote(w, r, id, username); !ok {
+		return
+	}
+	shares, err := h.store.ListShares(id)
+	if err != nil {
+		log.Printf("ListShares error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch shares")
+		return
+	}
+	resp := make([]shareResp, 0, len(shares))
+	for _, sh := range shares {
+		resp = append(resp, shareResp{Recipient: sh.Recipient, Permission: sh.Permission, Created: sh.Created})
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"shares": resp})
+}
+
+// ShareNoteHandler shares one of the caller's notes with another user, or
+// changes what they may do with it: PUT /notes/{id}/shares/{user} with
+// {"permission": "read"|"write"}, read when left out.
+func (h *Handler) ShareNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Permission string `json:"permission"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = storage.PermRead
+	}
+	if req.Permission != storage.PermRead && req.Permission != storage.PermWrite {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid permission")
+		return
+	}
+	recipient := r.PathValue("user")
+	if recipient == username {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Can't share a note with yourself")
+		return
+	}
+	a, ok := h.ownedAccess(w, r, id, username)
+	if !ok {
+		return
+	}
+	if _, err := h.store.GetUser(recipient); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("GetUser error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return
+	}
+	kp, ok := h.keyPair(w, r, recipient)
+	if !ok {
+		return
+	}
+	if a, ok = h.keyNote(w, r, a); !ok {
+		return
+	}
+	sealed, err := utils.SealKey(kp.PublicKey, a.key)
+	if err != nil {
+		log.Printf("SealKey error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return
+	}
+	sh := storage.Share{NoteID: id, Owner: username, Recipient: recipient, Key: sealed, Permission: req.Permission, Created: time.Now().Unix()}
+	if err := h.store.ShareNote(sh); err == storage.ErrNotFound {
+		// trashed meanwhile
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	} else if err != nil {
+		log.Printf("ShareNote error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return
+	}
+	// a share that was there keeps when it was made
+	if sh, err = h.store.GetShare(id, recipient); err != nil {
+		log.Printf("GetShare error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to share note")
+		return
+	}
+	api.JSON(w, http.StatusOK, shareResp{Recipient: sh.Recipient, Permission: sh.Permission, Created: sh.Created})
+}
+
+// UnshareNoteHandler stops sharing a note with a user:
+// DELETE /notes/{id}/shares/{user}, by the owner of the note or by that
+// user, to leave it. The note keeps its key, so a share made again later
+// doesn't need to encrypt it once more.
+func (h *Handler) UnshareNoteHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	recipient := r.PathValue("user")
+	sh, err := h.store.GetShare(id, recipient)
+	if err != nil && err != storage.ErrNotFound {
+		log.Printf("GetShare error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to unshare note")
+		return
+	}
+	if err == storage.ErrNotFound || (sh.Owner != username && sh.Recipient != username) {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	}
+	if err := h.store.DeleteShare(id, recipient); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteShare error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to unshare note")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "unshared"})
+}
+
+// SharedNotesHandler lists the notes other users share with the caller,
+// last modified first: GET /notes/shared.
+func (h *Handler) SharedNotesHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	shared, err := h.store.ListSharedNotes(username)
+	if err != nil {
+		log.Printf("ListSharedNotes error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch notes")
+		return
+	}
+	resp := make([]noteResp, 0, len(shared))
+	for _, sn := range shared {
+		key, ok := h.sharedKey(w, r, sn.Share)
+		if !ok {
+			return
+		}
+		a := noteAccess{note: sn.Note, key: key, permission: sn.Share.Permission}
+		content, ok := h.decryptWith(w, r, key, sn.Note.Nonce, sn.Note.Content)
+		if !ok {
+			return
+		}
+		title, ok := h.decryptTitle(w, r, key, sn.Note.TitleNonce, sn.Note.Title)
+		if !ok {
+			return
+		}
+		resp = append(resp, a.resp(title, content))
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"notes": resp})
+}
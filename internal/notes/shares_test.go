@@ -0,0 +1,41 @@
+package notes
+
+import (
+	"net/http"
+	"scrypts/internal/storage"
+	"testing"
+)
+
+func TestShareNote(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user("alice1")
+	bobby := s.user("bobby1")
+	id := s.createNote(alice, "plans", "secret plans")
+
+	s.do(http.MethodPut, "/notes/"+id+"/shares/nobody1", alice, nil, http.StatusNotFound, nil)
+	s.do(http.MethodPut, "/notes/"+id+"/shares/bobby1", alice, map[string]string{"permission": "read"}, http.StatusOK, nil)
+
+	var note noteResp
+	s.do(http.MethodGet, "/notes/"+id, bobby, nil, http.StatusOK, &note)
+	if note.Content != "secret plans" || note.Permission != storage.PermRead {
+		t.Errorf("shared note = %+v", note)
+	}
+	var shared struct {
+		Notes []noteResp `json:"notes"`
+	}
+	s.do(http.MethodGet, "/notes/shared", bobby, nil, http.StatusOK, &shared)
+	if len(shared.Notes) != 1 || shared.Notes[0].ID != id {
+		t.Errorf("GET /notes/shared = %+v", shared)
+	}
+	s.do(http.MethodPatch, "/notes/"+id, bobby, map[string]any{"content": "mine", "revision": 1}, http.StatusForbidden, nil)
+
+	s.do(http.MethodPut, "/notes/"+id+"/shares/bobby1", alice, map[string]string{"permission": "write"}, http.StatusOK, nil)
+	s.do(http.MethodPatch, "/notes/"+id, bobby, map[string]any{"content": "better plans", "revision": 1}, http.StatusOK, &note)
+	s.do(http.MethodGet, "/notes/"+id, alice, nil, http.StatusOK, &note)
+	if note.Content != "better plans" || note.Title != "plans" {
+		t.Errorf("note after the recipient's change = %+v", note)
+	}
+
+	s.do(http.MethodDelete, "/notes/"+id+"/shares/bobby1", alice, nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/notes/"+id, bobby, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,211 @@
+package notes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/storage"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tags label notes across notebooks. Like notebooks their names are
+// encrypted, and each user's are unique.
+
+// tagResp is a decrypted tag as the API returns it.
+type tagResp struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Created int64  `json:"created"`
+}
+
+// CreateTagHandler adds a tag: POST /tags with {"name": "..."}.
+func (h *Handler) CreateTagHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	name, ok := checkName(w, r, req.Name)
+	if !ok {
+		return
+	}
+	t := storage.Tag{ID: uuid.New().String(), Owner: username, Created: time.Now().Unix()}
+	if !h.uniqueTagName(w, r, t, name) {
+		return
+	}
+	if t.Nonce, t.Name, ok = h.encrypt(w, r, username, name); !ok {
+		return
+	}
+	if err := h.store.CreateTag(t); err != nil {
+		log.Printf("CreateTag error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to create tag")
+		return
+	}
+	api.JSON(w, http.StatusCreated, tagResp{ID: t.ID, Name: name, Created: t.Created})
+}
+
+// ListTagsHandler lists all of the caller's tags, oldest first: GET /tags.
+func (h *Handler) ListTagsHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	tags, err := h.store.ListTags(username)
+	if err != nil {
+		log.Printf("ListTags error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch tags")
+		return
+	}
+	resp := make([]tagResp, 0, len(tags))
+	for _, t := range tags {
+		name, ok := h.decrypt(w, r, username, t.Nonce, t.Name)
+		if !ok {
+			return
+		}
+		resp = append(resp, tagResp{ID: t.ID, Name: name, Created: t.Created})
+	}
+	api.JSON(w, http.StatusOK, map[string]interface{}{"tags": resp})
+}
+
+// RenameTagHandler renames a tag: PATCH /tags/{id} with {"name": "..."}.
+func (h *Handler) RenameTagHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathTagID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid request")
+		return
+	}
+	name, ok := checkName(w, r, req.Name)
+	if !ok {
+		return
+	}
+	t := storage.Tag{ID: id, Owner: username}
+	if !h.uniqueTagName(w, r, t, name) {
+		return
+	}
+	if t.Nonce, t.Name, ok = h.encrypt(w, r, username, name); !ok {
+		return
+	}
+	if err := h.store.RenameTag(t); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Tag not found")
+		return
+	} else if err != nil {
+		log.Printf("RenameTag error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to rename tag")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"id": id, "name": name})
+}
+
+// DeleteTagHandler deletes a tag and takes it off its notes:
+// DELETE /tags/{id}.
+func (h *Handler) DeleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathTagID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteTag(id, username); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Tag not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteTag error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to delete tag")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func pathTagID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if _, err := uuid.Parse(id); err != nil {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Invalid tag id")
+		return "", false
+	}
+	return id, true
+}
+
+// uniqueTagName answers 409 when another tag of the owner of t is called
+// name.
+func (h *Handler) uniqueTagName(w http.ResponseWriter, r *http.Request, t storage.Tag, name string) bool {
+	tags, err := h.store.ListTags(t.Owner)
+	if err != nil {
+		log.Printf("ListTags error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch tags")
+		return false
+	}
+	for _, other := range tags {
+		if other.ID == t.ID {
+			continue
+		}
+		otherName, ok := h.decrypt(w, r, t.Owner, other.Nonce, other.Name)
+		if !ok {
+			return false
+		}
+		if otherName == name {
+			api.Error(w, r, http.StatusConflict, api.CodeConflict, "A tag with that name already exists")
+			return false
+		}
+	}
+	return true
+}
+
+// userTags checks that the tag ids given for a note are the user's, and
+// returns them without duplicates.
+func (h *Handler) userTags(w http.ResponseWriter, r *http.Request, username string, ids []string) ([]string, bool) {
+	if len(ids) == 0 {
+		return nil, true
+	}
+	tags, err := h.store.ListTags(username)
+	if err != nil {
+		log.Printf("ListTags error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to fetch tags")
+		return nil, false
+	}
+	owned := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		owned[t.ID] = true
+	}
+	res := make([]string, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !owned[id] {
+			api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Unknown tag")
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	if len(res) > storage.MaxNoteTags {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Too many tags")
+		return nil, false
+	}
+	return res, true
+}
@@ -0,0 +1,135 @@
+package notes
+
+import (
+	"log"
+	"net/http"
+	"scrypts/internal/api"
+	"scrypts/internal/config"
+	"scrypts/internal/storage"
+	"time"
+)
+
+// Deleting a note moves it to the trash, from which it can be restored
+// until it is deleted for good: by hand, by emptying the trash, or by the
+// TrashPurger once it has been there for SCRYPTS_TRASH_DAYS.
+
+// purgeTime returns when a note deleted at the given time will be purged,
+// or 0 if it won't be.
+func purgeTime(deleted int64) int64 {
+	if deleted == 0 || config.TrashDays == 0 {
+		return 0
+	}
+	return deleted + int64(config.TrashDays)*86400
+}
+
+// ListTrashHandler lists the notes in the caller's trash: GET /trash. It
+// takes the parameters of GET /notes, and sorts by deletion time unless
+// asked otherwise.
+func (h *Handler) ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	h.listNotes(w, r, true)
+}
+
+// RestoreTrashHandler takes a note out of the trash:
+// POST /trash/{id}/restore.
+func (h *Handler) RestoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	note, ok := h.userNote(w, r, id, username, true)
+	if !ok {
+		return
+	}
+	if err := h.store.RestoreNote(id, username); err == storage.ErrNotFound {
+		// restored or purged in the meantime
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	} else if err != nil {
+		log.Printf("RestoreNote error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to restore note")
+		return
+	}
+	note.Deleted = 0
+	a, ok := h.ownerAccess(w, r, note)
+	if !ok {
+		return
+	}
+	h.writeNote(w, r, a)
+}
+
+// DeleteTrashHandler deletes a note in the trash for good, with its
+// revisions: DELETE /trash/{id}.
+func (h *Handler) DeleteTrashHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, ok := pathNoteID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteNote(id, username); err == storage.ErrNotFound {
+		api.Error(w, r, http.StatusNotFound, api.CodeNotFound, "Note not found")
+		return
+	} else if err != nil {
+		log.Printf("DeleteNote error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to delete note")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// EmptyTrashHandler deletes every note in the caller's trash for good:
+// DELETE /trash.
+func (h *Handler) EmptyTrashHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := h.auth.GetUsernameFromJWT(r)
+	if err != nil {
+		api.Error(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	n, err := h.store.EmptyTrash(username)
+	if err != nil {
+		log.Printf("EmptyTrash error: %v", err)
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to empty trash")
+		return
+	}
+	api.JSON(w, http.StatusOK, map[string]int64{"deleted": n})
+}
+
+// TrashPurger deletes the notes that have been in the trash for longer than
+// Retention, every Interval.
+type TrashPurger struct {
+	Store     storage.Store
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// Start runs the purger in the background for the life of the process.
+func (p *TrashPurger) Start() {
+	go func() {
+		for {
+			if err := p.RunOnce(); err != nil {
+				log.Printf("trash purge error: %v", err)
+			}
+			time.Sleep(p.Interval)
+		}
+	}()
+}
+
+// RunOnce purges the trash once.
+func (p *TrashPurger) RunOnce() error {
+	n, err := p.Store.PurgeTrash(time.Now().Add(-p.Retention).Unix())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("trash: purged %d note(s)", n)
+	}
+	return nil
+}
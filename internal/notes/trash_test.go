@@ -0,0 +1,56 @@
+package notes
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTrash(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.user("alice1")
+	bobby := s.user("bobby1")
+	id := s.createNote(alice, "groceries", "milk")
+
+	var list struct {
+		Notes []noteResp `json:"notes"`
+	}
+	s.do(http.MethodDelete, "/notes/"+id, alice, nil, http.StatusPreconditionRequired, nil)
+	s.do(http.MethodDelete, "/notes/"+id+"?revision=2", alice, nil, http.StatusPreconditionFailed, nil)
+	s.do(http.MethodDelete, "/notes/"+id+"?revision=1", bobby, nil, http.StatusNotFound, nil)
+	s.do(http.MethodDelete, "/notes/"+id+"?revision=1", alice, nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/notes/"+id, alice, nil, http.StatusNotFound, nil)
+	s.do(http.MethodGet, "/notes", alice, nil, http.StatusOK, &list)
+	if len(list.Notes) != 0 {
+		t.Errorf("GET /notes listed %d trashed notes", len(list.Notes))
+	}
+	s.do(http.MethodGet, "/trash", alice, nil, http.StatusOK, &list)
+	if len(list.Notes) != 1 || list.Notes[0].ID != id {
+		t.Errorf("GET /trash = %+v", list)
+	}
+	s.do(http.MethodGet, "/trash", bobby, nil, http.StatusOK, &list)
+	if len(list.Notes) != 0 {
+		t.Errorf("GET /trash listed %d notes of another user", len(list.Notes))
+	}
+
+	s.do(http.MethodPost, "/trash/"+id+"/restore", bobby, nil, http.StatusNotFound, nil)
+	s.do(http.MethodPost, "/trash/"+id+"/restore", alice, nil, http.StatusOK, nil)
+	var note noteResp
+	s.do(http.MethodGet, "/notes/"+id, alice, nil, http.StatusOK, &note)
+	if note.Content != "milk" {
+		t.Errorf("restored note = %+v", note)
+	}
+
+	// notes are only deleted for good from the trash
+	s.do(http.MethodDelete, "/trash/"+id, alice, nil, http.StatusNotFound, nil)
+	other := s.createNote(alice, "todo", "call")
+	s.do(http.MethodDelete, "/notes/"+id+"?revision=1", alice, nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/notes/"+other+"?revision=1", alice, nil, http.StatusOK, nil)
+	s.do(http.MethodDelete, "/trash/"+id, bobby, nil, http.StatusNotFound, nil)
+	s.do(http.MethodDelete, "/trash/"+id, alice, nil, http.StatusOK, nil)
+	s.do(http.MethodPost, "/trash/"+id+"/restore", alice, nil, http.StatusNotFound, nil)
+	s.do(http.MethodDelete, "/trash", alice, nil, http.StatusOK, nil)
+	s.do(http.MethodGet, "/trash", alice, nil, http.StatusOK, &list)
+	if len(list.Notes) != 0 {
+		t.Errorf("GET /trash after emptying it = %+v", list)
+	}
+}
@@ -0,0 +1,109 @@
+package objstore_test
+
+import (
+	"bytes"
+	"io"
+	"scrypts/internal/objstore"
+	"scrypts/internal/objstore/objstoretest"
+	"strings"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	testBucket(t, objstore.Dir{Root: t.TempDir()}, objstoretest.Prefix())
+}
+
+func TestS3(t *testing.T) {
+	s := objstoretest.Bucket(t)
+	prefix := objstoretest.Prefix()
+	testBucket(t, s, prefix)
+
+	key := prefix + "ranged"
+	if err := s.Put(key, strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Delete(key)
+	if o, err := s.Stat(key); err != nil || o.Size != 10 || o.Modified.IsZero() {
+		t.Errorf("Stat = %+v, %v", o, err)
+	}
+	if _, err := s.Stat(prefix + "missing"); err != objstore.ErrNotExist {
+		t.Errorf("Stat of a missing object: %v, want ErrNotExist", err)
+	}
+	r, err := s.GetRange(key, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != "3456" {
+		t.Errorf("GetRange(3, 4) = %q, %v", got, err)
+	}
+}
+
+// testBucket runs the same checks against any Bucket, under prefix.
+func testBucket(t *testing.T, b objstore.Bucket, prefix string) {
+	put := func(key, data string) {
+		t.Helper()
+		if err := b.Put(key, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	get := func(key string) (string, error) {
+		r, err := b.Get(key)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+	keys := []string{prefix + "a/2", prefix + "a/1", prefix + "b/1", prefix + "a.txt"}
+	for _, key := range keys {
+		defer b.Delete(key)
+	}
+	put(keys[0], "two")
+	put(keys[1], "one")
+	put(keys[2], "")
+	put(keys[3], "text")
+
+	objs, err := b.List(prefix + "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].Key != prefix+"a/1" || objs[1].Key != prefix+"a/2" || objs[0].Size != 3 {
+		t.Errorf("List(a/) = %+v", objs)
+	}
+	if objs, err := b.List(prefix); err != nil || len(objs) != 4 {
+		t.Errorf("List of the prefix = %+v, %v", objs, err)
+	}
+
+	if data, err := get(keys[1]); err != nil || data != "one" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	put(keys[1], "uno")
+	if data, err := get(keys[1]); err != nil || data != "uno" {
+		t.Errorf("Get of a replaced object = %q, %v", data, err)
+	}
+	if data, err := get(keys[2]); err != nil || data != "" {
+		t.Errorf("Get of an empty object = %q, %v", data, err)
+	}
+	if _, err := get(prefix + "missing"); err != objstore.ErrNotExist {
+		t.Errorf("Get of a missing object: %v, want ErrNotExist", err)
+	}
+	if err := b.Put(prefix+"short", bytes.NewReader([]byte("abc")), 5); err == nil {
+		b.Delete(prefix + "short")
+		t.Error("Put accepted fewer bytes than announced")
+	}
+
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Delete(keys[0]); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+	if objs, err := b.List(prefix); err != nil || len(objs) != 0 {
+		t.Errorf("List after deleting everything = %+v, %v", objs, err)
+	}
+}
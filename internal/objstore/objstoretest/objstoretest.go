@@ -0,0 +1,38 @@
+// Package objstoretest gives tests a bucket of their own to run against.
+package objstoretest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"scrypts/internal/objstore"
+	"testing"
+)
+
+// Bucket returns the bucket named by SCRYPTS_TEST_S3_ENDPOINT,
+// SCRYPTS_TEST_S3_BUCKET, SCRYPTS_TEST_S3_ACCESS_KEY and
+// SCRYPTS_TEST_S3_SECRET_KEY, e.g. of a local MinIO, and skips the test
+// without them.
+func Bucket(t testing.TB) *objstore.S3 {
+	t.Helper()
+	endpoint := os.Getenv("SCRYPTS_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("SCRYPTS_TEST_S3_ENDPOINT not set")
+	}
+	return &objstore.S3{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    os.Getenv("SCRYPTS_TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("SCRYPTS_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("SCRYPTS_TEST_S3_SECRET_KEY"),
+		PathStyle: true,
+	}
+}
+
+// Prefix returns a random key prefix, so that tests sharing a bucket leave
+// each other's objects alone.
+func Prefix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "scrypts-test-" + hex.EncodeToString(b) + "/"
+}
@@ -0,0 +1,109 @@
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachList answers whether a password appears in a Have I Been Pwned style
+// dataset without sending anything over the network.
+//
+// Two layouts are understood:
+//   - a directory of k-anonymity range files, one per 5-hex-digit SHA-1 prefix
+//     (named "ABCDE" or "ABCDE.txt"), each holding "SUFFIX:COUNT" lines as
+//     served by the HIBP range API and written by its downloader. Files are
+//     read on demand, so the full dataset never has to fit in memory.
+//   - a single file of "HASH:COUNT" lines (full 40-digit SHA-1), loaded into
+//     memory; meant for curated lists of the most common passwords.
+type BreachList struct {
+	dir    string
+	hashes map[string]int
+}
+
+// LoadBreachList opens the dataset at path.
+func LoadBreachList(path string) (*BreachList, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &BreachList{dir: path}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hashes := make(map[string]int)
+	err = scanHashes(f, func(hash string, count int) bool {
+		if len(hash) != 40 {
+			return true
+		}
+		hashes[hash] = count
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &BreachList{hashes: hashes}, nil
+}
+
+// Count returns how many times password has been seen in breaches, or zero.
+func (b *BreachList) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if b.hashes != nil {
+		return b.hashes[hash], nil
+	}
+
+	prefix, suffix := hash[:5], hash[5:]
+	f, err := os.Open(filepath.Join(b.dir, prefix))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		// partial datasets are allowed; a missing range has no matches
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	count := 0
+	err = scanHashes(f, func(h string, c int) bool {
+		if h == suffix {
+			count = c
+			return false
+		}
+		return true
+	})
+	return count, err
+}
+
+// scanHashes calls fn for every "HASH:COUNT" line in r until fn returns false.
+// Hashes are upper-cased; padding entries with a zero count are skipped.
+func scanHashes(r io.Reader, fn func(hash string, count int) bool) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		hash, countStr, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count == 0 {
+			continue
+		}
+		if !fn(strings.ToUpper(hash), count) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
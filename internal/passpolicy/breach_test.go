@@ -0,0 +1,110 @@
+package passpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sha1Hex returns the upper-case SHA-1 of password, as HIBP writes it.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestBreachListDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines ...string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "password" is in a file named after its prefix, "letmein" in one with
+	// .txt, and "hunter2" shares its range file with garbage and padding
+	pw, lm, hu := sha1Hex("password"), sha1Hex("letmein"), sha1Hex("hunter2")
+	write(pw[:5], "0000000000000000000000000000000000A:3", pw[5:]+":9545824")
+	write(lm[:5]+".txt", strings.ToLower(lm[5:])+":1000")
+	write(hu[:5], "not a line", hu[5:]+":lots", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:0", hu[5:39]+"X:12")
+
+	b, err := LoadBreachList(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{"password", 9545824},
+		{"letmein", 1000},
+		{"hunter2", 0},                      // its only line has a malformed count
+		{"correct horse battery staple", 0}, // no range file at all
+	}
+	for _, tt := range tests {
+		if n, err := b.Count(tt.password); err != nil || n != tt.count {
+			t.Errorf("Count(%q) = %d, %v, want %d", tt.password, n, err, tt.count)
+		}
+	}
+}
+
+func TestBreachListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	lines := []string{
+		sha1Hex("password") + ":9545824",
+		strings.ToLower(sha1Hex("letmein")) + ":1000",
+		"malformed",
+		sha1Hex("hunter2") + ":many",
+		sha1Hex("dragon")[:20] + ":5",
+		sha1Hex("monkey") + ":0",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBreachList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{"password", 9545824},
+		{"letmein", 1000},
+		{"hunter2", 0}, // malformed count
+		{"dragon", 0},  // truncated hash
+		{"monkey", 0},  // padding
+		{"correct horse battery staple", 0},
+	}
+	for _, tt := range tests {
+		if n, err := b.Count(tt.password); err != nil || n != tt.count {
+			t.Errorf("Count(%q) = %d, %v, want %d", tt.password, n, err, tt.count)
+		}
+	}
+
+	if _, err := LoadBreachList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadBreachList of a missing path succeeded")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(sha1Hex("Tr0ub4dor&3-horse-battery")+":2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBreachList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Policy{MinLength: 8, MinScore: 3, Breaches: b}
+	for _, pw := range []string{"short", "Password1!", "Tr0ub4dor&3-horse-battery", strings.Repeat("correct horse ", 6)} {
+		if _, ok := p.Check(pw).(*RejectedError); !ok {
+			t.Errorf("Check(%q) accepted the password", pw)
+		}
+	}
+	if err := p.Check("correct horse battery staple"); err != nil {
+		t.Errorf("Check of a strong password: %v", err)
+	}
+}
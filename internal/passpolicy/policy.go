@@ -0,0 +1,52 @@
+// Package passpolicy decides whether a password is acceptable: long enough,
+// not in a known breach and estimated strong enough to resist guessing.
+package passpolicy
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxLength matches bcrypt's input limit; longer passwords would be
+// silently truncated.
+const MaxLength = 72
+
+// RejectedError explains why a password was refused. Its message is safe to
+// show to the user.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string { return e.Reason }
+
+// Policy holds the password requirements.
+type Policy struct {
+	MinLength int
+	MinScore  int         // minimum Estimate score, 0-4
+	Breaches  *BreachList // nil skips the breach check
+}
+
+// Check returns a *RejectedError if password doesn't meet the policy, or
+// another error if the breach dataset couldn't be read. userInputs are
+// values such as the username that make a password easy to guess.
+func (p *Policy) Check(password string, userInputs ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &RejectedError{fmt.Sprintf("Password must be at least %d characters", p.MinLength)}
+	}
+	if len(password) > MaxLength {
+		return &RejectedError{fmt.Sprintf("Password must be at most %d bytes", MaxLength)}
+	}
+	if p.Breaches != nil {
+		n, err := p.Breaches.Count(password)
+		if err != nil {
+			return fmt.Errorf("breach check: %w", err)
+		}
+		if n > 0 {
+			return &RejectedError{"This password has appeared in a data breach and can't be used"}
+		}
+	}
+	if res := Estimate(password, userInputs...); res.Score < p.MinScore {
+		return &RejectedError{"Password is too easy to guess. " + res.Feedback}
+	}
+	return nil
+}
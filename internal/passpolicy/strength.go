@@ -0,0 +1,486 @@
+package passpolicy
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The estimator follows the approach of Dropbox's zxcvbn: the password is
+// covered by the cheapest sequence of patterns (dictionary words, l33t and
+// capitalised variants, sequences, repeats, keyboard runs, dates and brute
+// force), and the guess count of that sequence decides the score. The word
+// list is much smaller than zxcvbn's, which is what the breach dataset is for.
+
+//go:embed common.txt
+var commonList string
+
+// commonRank maps lower-cased common passwords and words to their rank.
+var commonRank = func() map[string]int {
+	m := make(map[string]int)
+	for i, w := range strings.Fields(commonList) {
+		m[w] = i + 1
+	}
+	return m
+}()
+
+const (
+	bruteforceCardinality = 10
+	minSubmatchSingle     = 10
+	minSubmatchMulti      = 50
+	minYearSpace          = 20
+	minGuessesPerMatch    = 10000 // penalises splitting into many small matches
+	maxEstimateLen        = 100   // longer passwords are scored on their prefix
+)
+
+// Result is the outcome of Estimate.
+type Result struct {
+	Guesses  float64
+	Score    int    // 0 (trivially guessable) to 4 (very unguessable)
+	Feedback string // suggestion based on the weakest pattern found
+}
+
+type match struct {
+	i, j     int // rune offsets, inclusive
+	log10    float64
+	pattern  string
+	feedback string
+}
+
+// Estimate scores password. userInputs (such as the username) are treated as
+// the most likely dictionary words.
+func Estimate(password string, userInputs ...string) Result {
+	pw := []rune(password)
+	if len(pw) > maxEstimateLen {
+		pw = pw[:maxEstimateLen]
+	}
+	if len(pw) == 0 {
+		return Result{Guesses: 1, Score: 0, Feedback: "Enter a password."}
+	}
+	user := make(map[string]int)
+	for i, in := range userInputs {
+		if in = strings.ToLower(in); in != "" {
+			user[in] = i + 1
+		}
+	}
+	log10, seq := mostGuessable(pw, user)
+	return Result{
+		Guesses:  math.Pow(10, log10),
+		Score:    score(log10),
+		Feedback: feedback(seq),
+	}
+}
+
+func score(log10 float64) int {
+	switch {
+	case log10 < 3:
+		return 0
+	case log10 < 6:
+		return 1
+	case log10 < 8:
+		return 2
+	case log10 < 10:
+		return 3
+	}
+	return 4
+}
+
+// mostGuessable finds the sequence of non-overlapping matches covering pw with
+// the fewest total guesses, using zxcvbn's formula l! * prod(guesses) + D^(l-1).
+func mostGuessable(pw []rune, user map[string]int) (float64, []match) {
+	n := len(pw)
+	byEnd := make([][]match, n)
+	for _, m := range findMatches(pw, user) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	for j := 0; j < n; j++ {
+		for i := 0; i <= j; i++ {
+			byEnd[j] = append(byEnd[j], bruteforceMatch(i, j, n))
+		}
+	}
+
+	// best[k][l]: log10 of the smallest guess product covering pw[:k] with l matches
+	inf := math.Inf(1)
+	best := make([][]float64, n+1)
+	prev := make([][]*match, n+1)
+	for k := range best {
+		best[k] = make([]float64, n+1)
+		prev[k] = make([]*match, n+1)
+		for l := range best[k] {
+			best[k][l] = inf
+		}
+	}
+	best[0][0] = 0
+	for k := 1; k <= n; k++ {
+		for mi := range byEnd[k-1] {
+			m := &byEnd[k-1][mi]
+			for l := 1; l <= k; l++ {
+				if c := best[m.i][l-1] + m.log10; c < best[k][l] {
+					best[k][l] = c
+					prev[k][l] = m
+				}
+			}
+		}
+	}
+
+	bestTotal, bestL := inf, 0
+	logFact := 0.0
+	for l := 1; l <= n; l++ {
+		logFact += math.Log10(float64(l))
+		if math.IsInf(best[n][l], 1) {
+			continue
+		}
+		total := logAdd(logFact+best[n][l], float64(l-1)*math.Log10(minGuessesPerMatch))
+		if total < bestTotal {
+			bestTotal, bestL = total, l
+		}
+	}
+
+	seq := make([]match, 0, bestL)
+	for k, l := n, bestL; k > 0; l-- {
+		m := prev[k][l]
+		seq = append(seq, *m)
+		k = m.i
+	}
+	return bestTotal, seq
+}
+
+// logAdd returns log10(10^a + 10^b).
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+func bruteforceMatch(i, j, n int) match {
+	length := j - i + 1
+	g := float64(length) * math.Log10(bruteforceCardinality)
+	if length < n {
+		min := float64(minSubmatchMulti)
+		if length == 1 {
+			min = minSubmatchSingle
+		}
+		g = math.Max(g, math.Log10(min+1))
+	}
+	return match{i: i, j: j, log10: g, pattern: "bruteforce"}
+}
+
+func findMatches(pw []rune, user map[string]int) []match {
+	lower := make([]rune, len(pw))
+	for i, r := range pw {
+		lower[i] = unicode.ToLower(r)
+	}
+	var ms []match
+	ms = append(ms, dictionaryMatches(pw, lower, user)...)
+	ms = append(ms, sequenceMatches(lower)...)
+	ms = append(ms, repeatMatches(lower)...)
+	ms = append(ms, spatialMatches(lower)...)
+	ms = append(ms, dateMatches(pw)...)
+	return ms
+}
+
+var l33tTables = []map[rune]rune{
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+	{'4': 'a', '@': 'a', '3': 'e', '1': 'l', '|': 'l', '!': 'l', '0': 'o', '$': 's', '5': 's', '7': 't'},
+}
+
+func dictionaryMatches(pw, lower []rune, user map[string]int) []match {
+	var ms []match
+	lookup := func(word string) (int, string, string) {
+		if r, ok := user[word]; ok {
+			return r, "user_input", "Avoid using your username or other personal details."
+		}
+		if r, ok := commonRank[word]; ok {
+			return r, "dictionary", "Avoid common passwords and single dictionary words."
+		}
+		return 0, "", ""
+	}
+	n := len(pw)
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			orig := pw[i : j+1]
+			word := string(lower[i : j+1])
+			upper := uppercaseVariations(orig)
+			if rank, pattern, fb := lookup(word); rank > 0 {
+				ms = append(ms, match{i, j, math.Log10(float64(rank) * upper), pattern, fb})
+			}
+			if rank, pattern, fb := lookup(reverse(word)); rank > 0 {
+				ms = append(ms, match{i, j, math.Log10(float64(rank) * upper * 2), pattern, fb})
+			}
+			for _, table := range l33tTables {
+				sub, subs := unl33t(lower[i:j+1], table)
+				if subs == 0 {
+					continue
+				}
+				if rank, pattern, _ := lookup(sub); rank > 0 {
+					l33t := math.Pow(2, float64(subs))
+					ms = append(ms, match{i, j, math.Log10(float64(rank) * upper * l33t), pattern,
+						"Predictable substitutions like '@' for 'a' don't help much."})
+				}
+			}
+		}
+	}
+	return ms
+}
+
+func unl33t(word []rune, table map[rune]rune) (string, int) {
+	var b strings.Builder
+	subs := 0
+	for _, r := range word {
+		if s, ok := table[r]; ok {
+			b.WriteRune(s)
+			subs++
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), subs
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// uppercaseVariations counts the ways capitalisation could have been applied.
+func uppercaseVariations(word []rune) float64 {
+	var upper, lower int
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || unicode.IsUpper(word[0]) && upper == 1 || unicode.IsUpper(word[len(word)-1]) && upper == 1 {
+		return 2
+	}
+	v := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		v += binomial(upper+lower, k)
+	}
+	return v
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+// sequenceMatches finds runs like "abcd", "9753" or "xyz".
+func sequenceMatches(lower []rune) []match {
+	var ms []match
+	n := len(lower)
+	for i := 0; i+2 < n; {
+		delta := lower[i+1] - lower[i]
+		if delta == 0 || delta > 5 || delta < -5 || !sameClass(lower[i], lower[i+1]) {
+			i++
+			continue
+		}
+		j := i + 1
+		for j+1 < n && lower[j+1]-lower[j] == delta && sameClass(lower[j], lower[j+1]) {
+			j++
+		}
+		if j-i+1 >= 3 {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("az019", lower[i]):
+				base = 4
+			case unicode.IsDigit(lower[i]):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			ms = append(ms, match{i, j, math.Log10(base * float64(j-i+1)), "sequence",
+				"Avoid sequences like abc or 6543."})
+			i = j
+			continue
+		}
+		i++
+	}
+	return ms
+}
+
+func sameClass(a, b rune) bool {
+	return unicode.IsDigit(a) && unicode.IsDigit(b) || unicode.IsLetter(a) && unicode.IsLetter(b)
+}
+
+// repeatMatches finds a unit repeated at least twice, like "aaa" or "abcabc".
+func repeatMatches(lower []rune) []match {
+	var ms []match
+	n := len(lower)
+	unitGuesses := make(map[string]float64)
+	for i := 0; i < n; i++ {
+		for size := 1; i+2*size <= n; size++ {
+			unit := string(lower[i : i+size])
+			count := 1
+			for i+(count+1)*size <= n && string(lower[i+count*size:i+(count+1)*size]) == unit {
+				count++
+			}
+			if count < 2 || count*size < 3 {
+				continue
+			}
+			g, ok := unitGuesses[unit]
+			if !ok {
+				if size == 1 {
+					g = 1
+				} else {
+					g, _ = mostGuessable([]rune(unit), nil)
+				}
+				unitGuesses[unit] = g
+			}
+			ms = append(ms, match{i, i + count*size - 1, g + math.Log10(float64(count)*bruteforceCardinality), "repeat",
+				`Avoid repeated words and characters like "aaa" or "abcabc".`})
+		}
+	}
+	return ms
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+var keyPositions = func() map[rune][2]int {
+	m := make(map[rune][2]int)
+	for row, keys := range keyboardRows {
+		for col, k := range keys {
+			m[k] = [2]int{row, col}
+		}
+	}
+	return m
+}()
+
+// spatialMatches finds straight runs along a keyboard row such as "qwer" or "lkjh".
+func spatialMatches(lower []rune) []match {
+	var ms []match
+	n := len(lower)
+	for i := 0; i+2 < n; {
+		a, okA := keyPositions[lower[i]]
+		b, okB := keyPositions[lower[i+1]]
+		if !okA || !okB || a[0] != b[0] || (b[1]-a[1] != 1 && b[1]-a[1] != -1) {
+			i++
+			continue
+		}
+		dir := b[1] - a[1]
+		j := i + 1
+		for j+1 < n {
+			c, ok := keyPositions[lower[j+1]]
+			p := keyPositions[lower[j]]
+			if !ok || c[0] != p[0] || c[1]-p[1] != dir {
+				break
+			}
+			j++
+		}
+		if j-i+1 >= 3 {
+			// ~47 starting keys, times run length, times both directions
+			ms = append(ms, match{i, j, math.Log10(47 * 2 * float64(j-i+1)), "spatial",
+				"Avoid straight rows of keys like qwerty."})
+			i = j
+			continue
+		}
+		i++
+	}
+	return ms
+}
+
+var dateRe = regexp.MustCompile(`^(\d{1,4})[\s/\\_.-](\d{1,2})[\s/\\_.-](\d{1,4})$`)
+
+// dateMatches finds years (1900-2099) and day/month/year dates.
+func dateMatches(pw []rune) []match {
+	var ms []match
+	n := len(pw)
+	refYear := time.Now().Year()
+	yearSpace := func(y int) float64 {
+		return math.Max(math.Abs(float64(y-refYear)), minYearSpace)
+	}
+	const fb = "Avoid dates and years that are associated with you."
+	for i := 0; i < n; i++ {
+		for j := i + 3; j < n && j-i < 10; j++ {
+			s := string(pw[i : j+1])
+			if len(s) == 4 {
+				if y, err := strconv.Atoi(s); err == nil && y >= 1900 && y <= 2099 {
+					ms = append(ms, match{i, j, math.Log10(yearSpace(y)), "date", fb})
+				}
+			}
+			if y, ok := parseDate(s); ok {
+				g := 365 * yearSpace(y)
+				if len(s) > 8 || !isDigits(s) {
+					g *= 4 // separator choice
+				}
+				ms = append(ms, match{i, j, math.Log10(g), "date", fb})
+			}
+		}
+	}
+	return ms
+}
+
+// parseDate recognises d/m/y, m/d/y and y/m/d dates with or without
+// separators and returns the year.
+func parseDate(s string) (int, bool) {
+	var parts [][3]string
+	if m := dateRe.FindStringSubmatch(s); m != nil {
+		parts = [][3]string{{m[1], m[2], m[3]}}
+	} else if isDigits(s) && (len(s) == 6 || len(s) == 8) {
+		parts = [][3]string{{s[:2], s[2:4], s[4:]}}
+		if len(s) == 8 {
+			parts = append(parts, [3]string{s[:4], s[4:6], s[6:]})
+		}
+	}
+	for _, p := range parts {
+		a, _ := strconv.Atoi(p[0])
+		b, _ := strconv.Atoi(p[1])
+		c, _ := strconv.Atoi(p[2])
+		for _, dmy := range [][3]int{{a, b, c}, {b, a, c}, {c, b, a}} {
+			d, m, y := dmy[0], dmy[1], dmy[2]
+			if y < 100 && len(p[2]) == 2 {
+				y += 1900
+				if y < 1950 {
+					y += 100
+				}
+			}
+			if d >= 1 && d <= 31 && m >= 1 && m <= 12 && y >= 1900 && y <= 2099 {
+				return y, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func feedback(seq []match) string {
+	longest := -1
+	for k, m := range seq {
+		if m.pattern == "bruteforce" {
+			continue
+		}
+		if longest < 0 || m.j-m.i > seq[longest].j-seq[longest].i {
+			longest = k
+		}
+	}
+	if longest < 0 {
+		return "Add another word or two. Uncommon words are better."
+	}
+	return seq[longest].feedback
+}
@@ -0,0 +1,45 @@
+package passpolicy
+
+import "testing"
+
+func TestEstimate(t *testing.T) {
+	// the default SCRYPTS_PASSWORD_MIN_SCORE
+	const minScore = 3
+	tests := []struct {
+		password   string
+		userInputs []string
+		ok         bool
+	}{
+		{"", nil, false},
+		{"password", nil, false},
+		{"Password1!", nil, false},
+		{"P@ssw0rd", nil, false},
+		{"qwertyuiop", nil, false},
+		{"abcdefghijkl", nil, false},
+		{"aaaaaaaaaaaaaaaa", nil, false},
+		{"1990-04-12", nil, false},
+		{"drowssap", nil, false},
+		{"alice1990", []string{"alice"}, false},
+		{"correct horse battery staple", nil, true},
+		{"Tr0ub4dor&3-horse-battery", nil, true},
+		{"k8#Vq2!mZr", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			res := Estimate(tt.password, tt.userInputs...)
+			if ok := res.Score >= minScore; ok != tt.ok {
+				t.Errorf("Estimate = score %d, %.3g guesses (%s), accepted %v, want %v", res.Score, res.Guesses, res.Feedback, ok, tt.ok)
+			}
+			if !tt.ok && res.Feedback == "" {
+				t.Error("no feedback for a weak password")
+			}
+		})
+	}
+}
+
+func TestEstimateUserInputs(t *testing.T) {
+	const pw = "zebulon-quartz"
+	if with, without := Estimate(pw, "zebulon"), Estimate(pw); with.Guesses >= without.Guesses {
+		t.Errorf("the username doesn't make the password easier to guess: %.3g, %.3g guesses", with.Guesses, without.Guesses)
+	}
+}
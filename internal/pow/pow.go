@@ -0,0 +1,220 @@
+// Package pow implements hashcash-style proof-of-work challenges.
+//
+// A challenge is an HMAC-signed token naming its purpose, difficulty and
+// expiry. A client solves it by finding a string s such that
+// SHA-256(token + ":" + s) starts with at least difficulty zero bits.
+// Each token can be redeemed once.
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"scrypts/internal/api"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PurposeRegister = "register"
+	PurposeLogin    = "login"
+
+	maxSolutionLen = 64
+)
+
+var (
+	ErrMalformed = errors.New("malformed challenge")
+	ErrSignature = errors.New("invalid challenge signature")
+	ErrExpired   = errors.New("challenge expired")
+	ErrPurpose   = errors.New("challenge issued for another purpose")
+	ErrSolution  = errors.New("invalid proof of work")
+	ErrReplayed  = errors.New("challenge already used")
+)
+
+type Challenge struct {
+	Token      string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	Expires    int64  `json:"expires"`
+}
+
+// Issuer hands out and verifies challenges. Difficulty starts at the base
+// and grows by one bit each time the number of challenges issued in the last
+// minute doubles past loadThreshold, up to max.
+type Issuer struct {
+	secret        []byte
+	base, max     int
+	ttl           time.Duration
+	loadThreshold int
+
+	mu        sync.Mutex
+	used      map[string]int64 // redeemed token -> expiry
+	window    time.Time        // start of the current one-minute window
+	issued    int              // challenges issued in the current window
+	prevCount int              // challenges issued in the previous window
+}
+
+// NewIssuer creates an issuer signing challenges with secret.
+func NewIssuer(secret []byte, base, max int, ttl time.Duration, loadThreshold int) *Issuer {
+	if max < base {
+		max = base
+	}
+	return &Issuer{
+		secret:        secret,
+		base:          base,
+		max:           max,
+		ttl:           ttl,
+		loadThreshold: loadThreshold,
+		used:          make(map[string]int64),
+		window:        time.Now().Truncate(time.Minute),
+	}
+}
+
+// difficulty must be called with mu held.
+func (is *Issuer) difficulty(now time.Time) int {
+	if w := now.Truncate(time.Minute); w.After(is.window) {
+		if w.Sub(is.window) == time.Minute {
+			is.prevCount = is.issued
+		} else {
+			is.prevCount = 0
+		}
+		is.window, is.issued = w, 0
+		is.pruneLocked(now.Unix())
+	}
+	// weight the previous window by how much of it still overlaps the last minute
+	elapsed := now.Sub(is.window)
+	load := is.issued + int(float64(is.prevCount)*float64(time.Minute-elapsed)/float64(time.Minute))
+
+	d := is.base
+	if is.loadThreshold > 0 && load > is.loadThreshold {
+		d += bits.Len(uint(load / is.loadThreshold))
+	}
+	if d > is.max {
+		d = is.max
+	}
+	return d
+}
+
+// Issue returns a new challenge for purpose.
+func (is *Issuer) Issue(purpose string) (Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+	now := time.Now()
+
+	is.mu.Lock()
+	d := is.difficulty(now)
+	is.issued++
+	is.mu.Unlock()
+
+	expires := now.Add(is.ttl).Unix()
+	payload := fmt.Sprintf("%s:%d:%d:%s", purpose, d, expires, hex.EncodeToString(nonce))
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(is.sign(payload))
+	return Challenge{Token: token, Difficulty: d, Expires: expires}, nil
+}
+
+func (is *Issuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, is.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Verify checks that solution solves token for purpose and marks the token used.
+func (is *Issuer) Verify(token, solution, purpose string) error {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok || solution == "" || len(solution) > maxSolutionLen {
+		return ErrMalformed
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return ErrMalformed
+	}
+	payload := string(payloadBytes)
+	if !hmac.Equal(sig, is.sign(payload)) {
+		return ErrSignature
+	}
+	parts := strings.Split(payload, ":")
+	if len(parts) != 4 {
+		return ErrMalformed
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return ErrMalformed
+	}
+	if parts[0] != purpose {
+		return ErrPurpose
+	}
+	now := time.Now().Unix()
+	if now > expires {
+		return ErrExpired
+	}
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+	if leadingZeroBits(sum[:]) < difficulty {
+		return ErrSolution
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if _, seen := is.used[token]; seen {
+		return ErrReplayed
+	}
+	is.used[token] = expires
+	return nil
+}
+
+// pruneLocked forgets redeemed tokens that have expired anyway.
+func (is *Issuer) pruneLocked(now int64) {
+	for t, exp := range is.used {
+		if exp < now {
+			delete(is.used, t)
+		}
+	}
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// ChallengeHandler serves GET /pow/challenge?purpose=register|login.
+func (is *Issuer) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.Error(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	purpose := r.URL.Query().Get("purpose")
+	if purpose != PurposeRegister && purpose != PurposeLogin {
+		api.Error(w, r, http.StatusBadRequest, api.CodeInvalidRequest, "Purpose must be register or login")
+		return
+	}
+	c, err := is.Issue(purpose)
+	if err != nil {
+		api.Error(w, r, http.StatusInternalServerError, api.CodeInternal, "Failed to issue challenge")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	api.JSON(w, http.StatusOK, c)
+}
@@ -0,0 +1,420 @@
+// Package replica continuously copies a SQLite database to object storage so
+// that it can be restored as of any point in time, in the way Litestream
+// does.
+//
+// A replica is a series of generations. A generation starts with a snapshot
+// of the database file taken right after the WAL was checkpointed, and goes
+// on with segments: runs of committed WAL frames copied as they are written.
+// Objects are laid out as
+//
+//	<prefix><generation>/snapshot
+//	<prefix><generation>/wal/<sequence>-<time>
+//
+// where generation and time are UTC timestamps. Every object is a backup
+// archive (see package backup), compressed and encrypted with the backup key
+// when there is one.
+//
+// The replicator does the checkpointing itself, with SQLite's automatic
+// checkpoints off, so that no frame reaches the database file before it has
+// been shipped. A new generation starts whenever it loses track of the WAL,
+// for example when another process checkpoints it.
+package replica
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"scrypts/internal/backup"
+	"scrypts/internal/objstore"
+	"scrypts/internal/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stampFormat = "20060102T150405.000Z"
+
+// checkpointSize is the WAL size past which the replicator checkpoints,
+// matching SQLite's default of 1000 pages of 4 KiB.
+const checkpointSize = 4 << 20
+
+var errLostTrack = errors.New("WAL changed behind the replicator's back")
+
+// Replicator ships the WAL of a SQLite store to a bucket.
+type Replicator struct {
+	DB     *storage.SQLiteStore
+	Target objstore.Bucket
+	Prefix string
+	Key    []byte // backup key; nil uploads unencrypted
+
+	Interval         time.Duration // how often new frames are shipped
+	SnapshotInterval time.Duration // how often a new generation starts
+	Retention        time.Duration // how far back restores stay possible
+
+	// file reads the database for snapshots. It stays open because closing
+	// any descriptor of the file drops all of the process's POSIX locks on
+	// it, SQLite's included, which would let another process checkpoint and
+	// delete the WAL under the server.
+	file *os.File
+
+	gen      string
+	genStart time.Time
+	seq      int
+	hdr      *walHeader // nil until the WAL has been written to
+	offset   int64      // end of the frames shipped so far
+	s0, s1   uint32     // WAL checksum at offset
+}
+
+// Start takes the first snapshot and then replicates in the background for
+// the life of the process.
+func (r *Replicator) Start() {
+	if err := r.newGeneration(); err != nil {
+		log.Printf("replica error: %v", err)
+	}
+	go func() {
+		for range time.Tick(r.Interval) {
+			if err := r.tick(); err != nil {
+				log.Printf("replica error: %v", err)
+				// the next tick starts over with a new generation
+				r.gen = ""
+			}
+		}
+	}()
+}
+
+func (r *Replicator) tick() error {
+	if r.gen == "" || time.Since(r.genStart) >= r.SnapshotInterval {
+		if err := r.newGeneration(); err != nil {
+			return err
+		}
+		return r.prune()
+	}
+	if err := r.sync(); err != nil {
+		return err
+	}
+	if r.offset >= checkpointSize {
+		return r.checkpoint()
+	}
+	return nil
+}
+
+func (r *Replicator) walPath() string {
+	return r.DB.Path() + "-wal"
+}
+
+// sync ships the transactions committed to the WAL since the last call.
+func (r *Replicator) sync() error {
+	f, err := os.Open(r.walPath())
+	if errors.Is(err, os.ErrNotExist) {
+		if r.hdr != nil {
+			return errLostTrack
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, head); err != nil {
+		if r.hdr != nil {
+			return errLostTrack
+		}
+		// nothing written since the last checkpoint
+		return nil
+	}
+	if r.hdr == nil {
+		h, err := parseWALHeader(head)
+		if err != nil {
+			return err
+		}
+		r.hdr, r.offset, r.s0, r.s1 = h, walHeaderSize, h.s0, h.s1
+	} else if !bytes.Equal(head, r.hdr.raw) {
+		return errLostTrack
+	}
+
+	frames, err := io.ReadAll(io.NewSectionReader(f, r.offset, 1<<62))
+	if err != nil {
+		return err
+	}
+	n, s0, s1 := r.hdr.committed(frames, r.s0, r.s1)
+	if n == 0 {
+		return nil
+	}
+	segment := append(append([]byte(nil), r.hdr.raw...), frames[:n]...)
+	key := fmt.Sprintf("%s%s/wal/%08d-%s", r.Prefix, r.gen, r.seq+1, time.Now().UTC().Format(stampFormat))
+	if err := r.put(key, bytes.NewReader(segment)); err != nil {
+		return err
+	}
+	r.seq++
+	r.offset += int64(n)
+	r.s0, r.s1 = s0, s1
+	return nil
+}
+
+// checkpoint moves the WAL into the database file once it has all been
+// shipped, and empties it. Writes are held off meanwhile so that nothing
+// reaches the database file unshipped.
+func (r *Replicator) checkpoint() error {
+	return r.DB.LockWrites(func(conn *sql.Conn) error {
+		if err := r.sync(); err != nil {
+			return err
+		}
+		done, err := r.truncateWAL(conn)
+		if err != nil || !done {
+			// readers are still on old frames; try again next time
+			return err
+		}
+		r.hdr = nil
+		return nil
+	})
+}
+
+// truncateWAL checkpoints the whole WAL and truncates it. It reports false
+// when readers kept it from finishing. The caller must hold the write lock
+// and have shipped the WAL.
+func (r *Replicator) truncateWAL(conn *sql.Conn) (bool, error) {
+	// with writes locked out everything in the WAL is committed, so anything
+	// past what was shipped came from another process and would go into the
+	// database unshipped
+	if r.hdr != nil {
+		fi, err := os.Stat(r.walPath())
+		if err != nil {
+			return false, err
+		}
+		if fi.Size() != r.offset {
+			return false, errLostTrack
+		}
+	}
+	var busy, frames, moved int
+	err := conn.QueryRowContext(context.Background(), `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &frames, &moved)
+	if err != nil {
+		return false, err
+	}
+	return busy == 0, nil
+}
+
+// newGeneration starts a new generation with a snapshot of the database.
+func (r *Replicator) newGeneration() error {
+	start := time.Now().UTC()
+	tmp := r.DB.Path() + ".replica-snapshot"
+	defer os.Remove(tmp)
+	err := r.DB.LockWrites(func(conn *sql.Conn) error {
+		// after a full checkpoint the database file on its own is the
+		// snapshot, and the next frames start a new WAL
+		r.hdr = nil
+		done, err := r.truncateWAL(conn)
+		if err != nil {
+			return err
+		}
+		if !done {
+			return errors.New("checkpoint blocked by readers")
+		}
+		return r.copyDB(tmp)
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	// the copy is archived and uploaded once writes are flowing again
+	gen := start.Format(stampFormat)
+	archive := tmp + ".archive"
+	defer os.Remove(archive)
+	if err := encodeFile(archive, tmp, r.Key); err != nil {
+		return err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := r.Target.Put(r.Prefix+gen+"/snapshot", f, fi.Size()); err != nil {
+		return fmt.Errorf("uploading snapshot: %w", err)
+	}
+	r.gen, r.genStart, r.seq = gen, start, 0
+	log.Printf("replica: started generation %s", gen)
+	return nil
+}
+
+// put stores the archive of the segment read from in under key.
+func (r *Replicator) put(key string, in io.Reader) error {
+	var buf bytes.Buffer
+	if err := backup.Encode(&buf, in, backup.Options{Compress: true, Key: r.Key}); err != nil {
+		return err
+	}
+	return r.Target.Put(key, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// prune deletes generations no longer needed to restore to any time within
+// the retention period.
+func (r *Replicator) prune() error {
+	gens, err := Generations(r.Target, r.Prefix)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-r.Retention)
+	// keep the newest generation that started before the cutoff, as restoring
+	// to the cutoff starts from it
+	keepFrom := 0
+	for i, g := range gens {
+		if g.start.After(cutoff) {
+			break
+		}
+		keepFrom = i
+	}
+	for _, g := range gens[:keepFrom] {
+		if g.name == r.gen {
+			continue
+		}
+		for _, key := range g.keys {
+			if err := r.Target.Delete(key); err != nil {
+				return err
+			}
+		}
+		log.Printf("replica: deleted generation %s", g.name)
+	}
+	return nil
+}
+
+// encodeFile writes the archive of the database file src to dst.
+func encodeFile(dst, src string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	err = backup.Encode(out, in, backup.Options{Compress: true, Key: key})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyDB copies the database file to dst.
+func (r *Replicator) copyDB(dst string) error {
+	if r.file == nil {
+		f, err := os.Open(r.DB.Path())
+		if err != nil {
+			return err
+		}
+		r.file = f
+	}
+	fi, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(r.file, 0, fi.Size()))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Generation describes one generation of a replica.
+type Generation struct {
+	name     string
+	start    time.Time
+	snapshot string
+	segments []segment
+	keys     []string
+}
+
+type segment struct {
+	key  string
+	seq  int
+	time time.Time
+}
+
+// Name identifies the generation.
+func (g Generation) Name() string { return g.name }
+
+// Start is when the generation's snapshot was taken.
+func (g Generation) Start() time.Time { return g.start }
+
+// End is the latest time the generation can restore to.
+func (g Generation) End() time.Time {
+	if len(g.segments) == 0 {
+		return g.start
+	}
+	return g.segments[len(g.segments)-1].time
+}
+
+// Segments is the number of WAL segments in the generation.
+func (g Generation) Segments() int { return len(g.segments) }
+
+// Generations lists the generations of the replica under prefix, oldest
+// first. Generations whose snapshot is missing are left out.
+func Generations(b objstore.Bucket, prefix string) ([]Generation, error) {
+	objs, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*Generation{}
+	for _, o := range objs {
+		name, rest, ok := strings.Cut(strings.TrimPrefix(o.Key, prefix), "/")
+		if !ok {
+			continue
+		}
+		start, err := time.Parse(stampFormat, name)
+		if err != nil {
+			continue
+		}
+		g := byName[name]
+		if g == nil {
+			g = &Generation{name: name, start: start}
+			byName[name] = g
+		}
+		g.keys = append(g.keys, o.Key)
+		if rest == "snapshot" {
+			g.snapshot = o.Key
+			continue
+		}
+		num, stamp, _ := strings.Cut(strings.TrimPrefix(rest, "wal/"), "-")
+		seq, err := strconv.Atoi(num)
+		if err != nil || !strings.HasPrefix(rest, "wal/") {
+			continue
+		}
+		t, err := time.Parse(stampFormat, stamp)
+		if err != nil {
+			continue
+		}
+		g.segments = append(g.segments, segment{key: o.Key, seq: seq, time: t})
+	}
+
+	var gens []Generation
+	for _, g := range byName {
+		if g.snapshot == "" {
+			continue
+		}
+		sort.Slice(g.segments, func(i, j int) bool { return g.segments[i].seq < g.segments[j].seq })
+		// a missing segment ends what the generation can restore
+		for i, s := range g.segments {
+			if s.seq != i+1 {
+				g.segments = g.segments[:i]
+				break
+			}
+		}
+		gens = append(gens, *g)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].start.Before(gens[j].start) })
+	return gens, nil
+}
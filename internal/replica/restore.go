@@ -0,0 +1,81 @@
+package replica
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"scrypts/internal/backup"
+	"scrypts/internal/objstore"
+	"time"
+)
+
+// Restore rebuilds the database as it was at time at from the replica under
+// prefix and writes it to dst, which must not exist. It returns the time the
+// result is current as of, which is when the last segment it includes was
+// shipped.
+func Restore(b objstore.Bucket, prefix string, key []byte, at time.Time, dst string) (time.Time, error) {
+	gens, err := Generations(b, prefix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var gen *Generation
+	for i := range gens {
+		if !gens[i].start.After(at) {
+			gen = &gens[i]
+		}
+	}
+	if gen == nil {
+		return time.Time{}, fmt.Errorf("the replica has nothing from before %s", at.UTC().Format(time.RFC3339))
+	}
+
+	f, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ok := false
+	defer func() {
+		f.Close()
+		if !ok {
+			os.Remove(dst)
+		}
+	}()
+
+	if err := fetch(b, gen.snapshot, key, f); err != nil {
+		return time.Time{}, fmt.Errorf("snapshot of %s: %w", gen.name, err)
+	}
+	restored := gen.start
+	for _, s := range gen.segments {
+		if s.time.After(at) {
+			break
+		}
+		var buf bytes.Buffer
+		if err := fetch(b, s.key, key, &buf); err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", s.key, err)
+		}
+		if err := applyFrames(f, buf.Bytes()); err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", s.key, err)
+		}
+		restored = s.time
+	}
+	if err := f.Sync(); err != nil {
+		return time.Time{}, err
+	}
+	ok = true
+	return restored, nil
+}
+
+// fetch copies the contents of the archive at key to w.
+func fetch(b objstore.Bucket, key string, archiveKey []byte, w io.Writer) error {
+	rc, err := b.Get(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r, err := backup.Decode(rc, archiveKey)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,129 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SQLite's WAL file format, see https://www.sqlite.org/fileformat.html#the_write_ahead_log.
+// The file is a 32 byte header followed by frames, each a 24 byte header and
+// one page. A frame whose "database size" field is non-zero commits a
+// transaction. Frames are chained by a running checksum seeded from the
+// header, and carry the header's salts, so that frames left over from an
+// earlier use of the file are told apart from current ones.
+const (
+	walHeaderSize   = 32
+	frameHeaderSize = 24
+
+	walMagicLE = 0x377f0682
+	walMagicBE = 0x377f0683
+)
+
+type walHeader struct {
+	raw       []byte
+	bigEndian bool // byte order of the checksummed words
+	pageSize  int
+	salt      []byte
+	s0, s1    uint32 // checksum, which seeds that of the first frame
+}
+
+func parseWALHeader(b []byte) (*walHeader, error) {
+	if len(b) < walHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	h := &walHeader{raw: append([]byte(nil), b[:walHeaderSize]...)}
+	switch binary.BigEndian.Uint32(b[0:4]) {
+	case walMagicLE:
+	case walMagicBE:
+		h.bigEndian = true
+	default:
+		return nil, errors.New("not a WAL file")
+	}
+	h.pageSize = int(binary.BigEndian.Uint32(b[8:12]))
+	if h.pageSize < 512 || h.pageSize > 65536 || h.pageSize&(h.pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid WAL page size %d", h.pageSize)
+	}
+	h.salt = h.raw[16:24]
+	h.s0, h.s1 = h.checksum(0, 0, b[:24])
+	if h.s0 != binary.BigEndian.Uint32(b[24:28]) || h.s1 != binary.BigEndian.Uint32(b[28:32]) {
+		return nil, errors.New("WAL header checksum mismatch")
+	}
+	return h, nil
+}
+
+func (h *walHeader) frameSize() int {
+	return frameHeaderSize + h.pageSize
+}
+
+// checksum continues the WAL checksum s0, s1 over b.
+func (h *walHeader) checksum(s0, s1 uint32, b []byte) (uint32, uint32) {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if h.bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// committed returns the length of the longest prefix of frames that ends
+// with a commit and whose frames are all valid, given the checksum s0, s1
+// of the frames before them, and the checksum at the end of that prefix.
+func (h *walHeader) committed(frames []byte, s0, s1 uint32) (int, uint32, uint32) {
+	n, c0, c1 := 0, s0, s1
+	size := h.frameSize()
+	for off := 0; off+size <= len(frames); off += size {
+		fh := frames[off : off+frameHeaderSize]
+		if !bytes.Equal(fh[8:16], h.salt) {
+			break
+		}
+		s0, s1 = h.checksum(s0, s1, fh[:8])
+		s0, s1 = h.checksum(s0, s1, frames[off+frameHeaderSize:off+size])
+		if s0 != binary.BigEndian.Uint32(fh[16:20]) || s1 != binary.BigEndian.Uint32(fh[20:24]) {
+			break
+		}
+		if binary.BigEndian.Uint32(fh[4:8]) != 0 {
+			n, c0, c1 = off+size, s0, s1
+		}
+	}
+	return n, c0, c1
+}
+
+// applyFrames writes the pages of a segment (a WAL header followed by
+// committed frames) into the database file f, as a checkpoint would.
+func applyFrames(f *os.File, segment []byte) error {
+	h, err := parseWALHeader(segment)
+	if err != nil {
+		return err
+	}
+	frames := segment[walHeaderSize:]
+	size := h.frameSize()
+	if len(frames)%size != 0 {
+		return errors.New("segment has a partial frame")
+	}
+	for off := 0; off < len(frames); off += size {
+		fh := frames[off : off+frameHeaderSize]
+		if !bytes.Equal(fh[8:16], h.salt) {
+			return errors.New("segment frame salt mismatch")
+		}
+		pgno := int64(binary.BigEndian.Uint32(fh[0:4]))
+		if pgno == 0 {
+			return errors.New("segment frame for page 0")
+		}
+		if _, err := f.WriteAt(frames[off+frameHeaderSize:off+size], (pgno-1)*int64(h.pageSize)); err != nil {
+			return err
+		}
+		if commit := int64(binary.BigEndian.Uint32(fh[4:8])); commit != 0 {
+			if err := f.Truncate(commit * int64(h.pageSize)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
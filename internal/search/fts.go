@@ -0,0 +1,246 @@
+package search
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html"
+	"scrypts/internal/storage"
+	"strings"
+	"unicode/utf8"
+)
+
+// With SCRYPTS_SEARCH=fts, notes are kept in an SQLite FTS5 index instead
+// of the blind one. The index holds each word as a token, a keyed hash like
+// a term, in the order of the text so that phrases can be matched and hits
+// ranked, and the prefixes of each word as tokens of their own, so that
+// prefix queries work on tokens that no longer share one.
+
+const (
+	tokenSize    = 12 // bytes of HMAC kept per token, hex-encoded
+	maxPrefixLen = 16 // in runes; longer prefixes are matched on this many
+	snippetWords = 16 // words around the first hit in a snippet
+)
+
+// ErrEmptyQuery is returned by ParseQuery for a query without any word.
+var ErrEmptyQuery = errors.New("nothing to search for")
+
+// Query is a parsed full-text query: notes must match all of its clauses.
+type Query struct {
+	clauses []clause
+}
+
+// clause is a word, a prefix (word*) or a "phrase of words".
+type clause struct {
+	words  []string
+	prefix bool
+}
+
+// ParseQuery parses a full-text query: words, which must all be found,
+// "quoted phrases", whose words must be found in that order, and words
+// ending in *, which match the words they begin. Words are normalised as
+// by Words.
+func ParseQuery(q string) (Query, error) {
+	var res Query
+	for q != "" {
+		q = strings.TrimLeft(q, " \t\r\n")
+		if strings.HasPrefix(q, `"`) {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			q = rest
+			var words []string
+			for _, sp := range spans(phrase) {
+				words = append(words, sp.word)
+			}
+			if len(words) > 0 {
+				res.clauses = append(res.clauses, clause{words: words})
+			}
+			continue
+		}
+		end := strings.IndexAny(q, " \t\r\n\"")
+		if end < 0 {
+			end = len(q)
+		}
+		field := q[:end]
+		q = q[end:]
+		prefix := strings.HasSuffix(field, "*")
+		sps := spans(strings.TrimSuffix(field, "*"))
+		for i, sp := range sps {
+			// a star only makes a prefix of the last word of the field
+			res.clauses = append(res.clauses, clause{words: []string{sp.word}, prefix: prefix && i == len(sps)-1})
+		}
+	}
+	if len(res.clauses) == 0 {
+		return Query{}, ErrEmptyQuery
+	}
+	if len(res.clauses) > storage.MaxSearchTerms {
+		return Query{}, errors.New("too many words")
+	}
+	return res, nil
+}
+
+// token returns the index token of a word ("w") or prefix ("p").
+func token(key []byte, kind, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + ":" + s))
+	return hex.EncodeToString(mac.Sum(nil)[:tokenSize])
+}
+
+// cutPrefix returns the first maxPrefixLen runes of w.
+func cutPrefix(w string) string {
+	if utf8.RuneCountInString(w) <= maxPrefixLen {
+		return w
+	}
+	n := 0
+	for i := range w {
+		if n == maxPrefixLen {
+			return w[:i]
+		}
+		n++
+	}
+	return w
+}
+
+// Document returns what the full-text index holds of a note.
+func Document(userKey []byte, title, content string) (storage.FTSDoc, error) {
+	key, err := Key(userKey)
+	if err != nil {
+		return storage.FTSDoc{}, err
+	}
+	words := func(text string) string {
+		var b strings.Builder
+		for _, sp := range spans(text) {
+			b.WriteString(token(key, "w", sp.word))
+			b.WriteByte(' ')
+		}
+		return b.String()
+	}
+	var prefixes strings.Builder
+	seen := map[string]bool{}
+	for _, w := range Words(title + "\n" + content) {
+		w = cutPrefix(w)
+		for i := range w {
+			if i == 0 || utf8.RuneCountInString(w[:i]) < minWordLen {
+				continue
+			}
+			seen[w[:i]] = true
+		}
+		seen[w] = true
+	}
+	for p := range seen {
+		prefixes.WriteString(token(key, "p", p))
+		prefixes.WriteByte(' ')
+	}
+	return storage.FTSDoc{Title: words(title), Body: words(content), Prefixes: prefixes.String()}, nil
+}
+
+// Match returns the FTS5 query of q for the user. Prefixes longer than the
+// index keeps match more than they should until checked with Matches.
+func (q Query) Match(userKey []byte) (string, error) {
+	key, err := Key(userKey)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(q.clauses))
+	for _, c := range q.clauses {
+		if c.prefix {
+			parts = append(parts, `prefixes : "`+token(key, "p", cutPrefix(c.words[0]))+`"`)
+			continue
+		}
+		tokens := make([]string, len(c.words))
+		for i, w := range c.words {
+			tokens[i] = token(key, "w", w)
+		}
+		parts = append(parts, `{title body} : "`+strings.Join(tokens, " ")+`"`)
+	}
+	return strings.Join(parts, " AND "), nil
+}
+
+// at tells whether the clause matches the words of sps from i on.
+func (c clause) at(sps []span, i int) bool {
+	if c.prefix {
+		return strings.HasPrefix(sps[i].word, c.words[0])
+	}
+	if i+len(c.words) > len(sps) {
+		return false
+	}
+	for j, w := range c.words {
+		if sps[i+j].word != w {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches tells whether a note with this title and content matches q.
+func (q Query) Matches(title, content string) bool {
+	titleSpans, contentSpans := spans(title), spans(content)
+	found := func(c clause, sps []span) bool {
+		for i := range sps {
+			if c.at(sps, i) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range q.clauses {
+		if !found(c, titleSpans) && !found(c, contentSpans) {
+			return false
+		}
+	}
+	return true
+}
+
+// Snippet returns the passage of content around the first hit of q, as
+// HTML: the text escaped and the hits in <mark>. Without a hit in the
+// content it is the beginning of the content.
+func (q Query) Snippet(content string) string {
+	sps := spans(content)
+	hit := make([]int, len(sps)) // how many words of a hit start there
+	first := -1
+	for i := range sps {
+		for _, c := range q.clauses {
+			if c.at(sps, i) && len(c.words) > hit[i] {
+				hit[i] = len(c.words)
+			}
+		}
+		if hit[i] > 0 && first < 0 {
+			first = i
+		}
+	}
+	from := 0
+	if first > snippetWords/4 {
+		from = first - snippetWords/4
+	}
+	to := from + snippetWords
+	if to > len(sps) {
+		to = len(sps)
+	}
+	if len(sps) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	start, end := sps[from].start, sps[to-1].end
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for i := from; i < to; i++ {
+		if hit[i] == 0 {
+			continue
+		}
+		last := i + hit[i] - 1
+		if last >= to {
+			last = to - 1
+		}
+		b.WriteString(html.EscapeString(content[pos:sps[i].start]))
+		b.WriteString("<mark>" + html.EscapeString(content[sps[i].start:sps[last].end]) + "</mark>")
+		pos = sps[last].end
+		i = last
+	}
+	b.WriteString(html.EscapeString(content[pos:end]))
+	if to < len(sps) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+// Package search derives the blind index through which notes are searched
+// without the server storing their words: each word of a note becomes a
+// term, a keyed hash under a key derived from its owner's, so the same word
+// gives the same term for one user and unrelated ones for two users.
+package search
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"scrypts/internal/storage"
+	"scrypts/internal/utils"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	minWordLen = 2  // in runes; shorter words aren't indexed
+	maxWordLen = 64 // in bytes; longer words are cut
+	termSize   = 16 // bytes of HMAC kept per term
+)
+
+// Key derives the index key of a user from their note key, so that a
+// term can't be used to check guesses against the notes themselves.
+func Key(userKey []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, userKey, nil, "scrypts blind index v1", 32)
+}
+
+// Words returns the distinct words of text, normalised: lower case, split
+// at anything that isn't a letter or digit, and without those too short to
+// be worth indexing.
+func Words(text string) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, sp := range spans(text) {
+		if !seen[sp.word] {
+			seen[sp.word] = true
+			words = append(words, sp.word)
+		}
+	}
+	return words
+}
+
+// span is a word of a text and where it is.
+type span struct {
+	start, end int // byte offsets in the text
+	word       string
+}
+
+// spans returns the words of text in order, normalised as by Words.
+func spans(text string) []span {
+	var res []span
+	start := -1
+	for i, r := range text + " " {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if w := normalise(text[start:i]); w != "" {
+				res = append(res, span{start, i, w})
+			}
+			start = -1
+		}
+	}
+	return res
+}
+
+// normalise lower-cases a word and cuts it to maxWordLen, returning "" for
+// one too short.
+func normalise(w string) string {
+	if utf8.RuneCountInString(w) < minWordLen {
+		return ""
+	}
+	w = strings.ToLower(w)
+	if len(w) > maxWordLen {
+		w = w[:maxWordLen]
+		for !utf8.ValidString(w) {
+			w = w[:len(w)-1]
+		}
+	}
+	return w
+}
+
+// Terms returns the terms of words under key.
+func Terms(key []byte, words []string) [][]byte {
+	terms := make([][]byte, 0, len(words))
+	for _, w := range words {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(w))
+		terms = append(terms, mac.Sum(nil)[:termSize])
+	}
+	return terms
+}
+
+// Reindex rebuilds the index of every note from its title and content, for
+// notes written before the index existed or while the other one was used:
+// the full-text index when fullText is set, the blind one otherwise. It
+// returns how many notes were indexed and how many were skipped because
+// they don't decrypt, which `scrypts doctor` looks into.
+func Reindex(store storage.Store, masterKey []byte, fullText bool) (indexed, skipped int, err error) {
+	c, ok := store.(storage.Checker)
+	if !ok {
+		return 0, 0, errors.New("the store can't be reindexed")
+	}
+	ft, ok := store.(storage.FullText)
+	if fullText && !ok {
+		return 0, 0, storage.ErrNoFullText
+	}
+	users, err := c.AllUsers()
+	if err != nil {
+		return 0, 0, err
+	}
+	keys := map[string][]byte{}
+	for _, u := range users {
+		if k, err := utils.UnwrapKey(masterKey, u.WrappedNonce, u.WrappedKey); err == nil {
+			keys[u.Username] = k
+		}
+	}
+	// the ids first, as EachNote doesn't let the store be written meanwhile
+	var ids []string
+	err = c.EachNote(func(n storage.Note) error {
+		ids = append(ids, n.ID)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, id := range ids {
+		n, err := store.GetNoteByID(id)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return indexed, skipped, err
+		}
+		userKey := keys[n.Owner]
+		title, content, err := decryptNote(userKey, n)
+		if err != nil {
+			skipped++
+			continue
+		}
+		if fullText {
+			var doc storage.FTSDoc
+			if doc, err = Document(userKey, title, content); err == nil {
+				err = ft.SetNoteFTS(n.ID, n.Owner, doc)
+			}
+		} else {
+			var terms [][]byte
+			if terms, err = TextTerms(userKey, title, content); err == nil {
+				err = store.SetNoteTerms(n.ID, n.Owner, terms)
+			}
+		}
+		if err != nil && err != storage.ErrNotFound {
+			return indexed, skipped, err
+		}
+		indexed++
+	}
+	return indexed, skipped, nil
+}
+
+// decryptNote decrypts the title and content of a note with its owner's
+// key, or with its own key when it has one.
+func decryptNote(userKey []byte, n storage.Note) (title, content string, err error) {
+	if userKey == nil {
+		return "", "", errors.New("no key")
+	}
+	if len(n.Key) > 0 {
+		if userKey, err = utils.UnwrapKey(userKey, n.KeyNonce, n.Key); err != nil {
+			return "", "", err
+		}
+	}
+	c, err := utils.DecryptAESGCM(userKey, n.Nonce, n.Content)
+	if err != nil {
+		return "", "", err
+	}
+	var t []byte
+	if len(n.Title) > 0 {
+		if t, err = utils.DecryptAESGCM(userKey, n.TitleNonce, n.Title); err != nil {
+			return "", "", err
+		}
+	}
+	return string(t), string(c), nil
+}
+
+// TextTerms returns the terms of the words of a note's title and content.
+func TextTerms(userKey []byte, title, content string) ([][]byte, error) {
+	key, err := Key(userKey)
+	if err != nil {
+		return nil, err
+	}
+	return Terms(key, Words(title+"\n"+content)), nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+)
+
+// UserSummary is a user row together with usage figures for the admin API.
+type UserSummary struct {
+	Username       string
+	Role           string
+	Disabled       bool
+	CreatedAt      int64
+	NoteCount      int64
+	StorageBytes   int64 // total size of the user's encrypted notes and revisions
+	ActiveSessions int64
+}
+
+func (s *sqlStore) ListUserSummaries(now int64) ([]UserSummary, error) {
+	rows, err := s.query(`
+SELECT u.username, u.role, u.disabled, u.created_at,
+  (SELECT COUNT(*) FROM notes n WHERE n.owner = u.username),
+  (SELECT CAST(COALESCE(SUM(LENGTH(n.content)), 0) AS BIGINT) FROM notes n WHERE n.owner = u.username)
+    + (SELECT CAST(COALESCE(SUM(LENGTH(r.content)), 0) AS BIGINT) FROM note_revisions r WHERE r.owner = u.username),
+  (SELECT COUNT(*) FROM sessions x WHERE x.username = u.username AND x.revoked_at IS NULL AND x.expires_at >= ?)
+FROM users u ORDER BY u.username`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []UserSummary{}
+	for rows.Next() {
+		var sum UserSummary
+		if err := rows.Scan(&sum.Username, &sum.Role, &sum.Disabled, &sum.CreatedAt, &sum.NoteCount, &sum.StorageBytes, &sum.ActiveSessions); err != nil {
+			return nil, err
+		}
+		res = append(res, sum)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) SetUserRole(username, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return errors.New("invalid role")
+	}
+	return s.execOne(`UPDATE users SET role = ? WHERE username = ?`, role, username)
+}
+
+func (s *sqlStore) SetUserDisabled(username string, disabled bool) error {
+	return s.execOne(`UPDATE users SET disabled = ? WHERE username = ?`, disabled, username)
+}
+
+// RevokeUserSessions revokes every active session of the user.
+func (s *sqlStore) RevokeUserSessions(username string, now int64) error {
+	_, err := s.exec(`UPDATE sessions SET revoked_at = ? WHERE username = ? AND revoked_at IS NULL`, now, username)
+	return err
+}
+
+// DeleteUser removes the user along with their notes, revisions,
+// attachments, links, notebooks, tags, sessions and keypair, and the shares
+// of their notes and with them.
+func (s *sqlStore) DeleteUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(s.q(`DELETE FROM sessions WHERE username = ?`), username); err != nil {
+		return err
+	}
+	if err := s.releaseBlobs(tx, `owner = ?`, username); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM note_revisions WHERE owner = ?`,
+		`DELETE FROM note_tags WHERE note_id IN (SELECT id FROM notes WHERE owner = ?)`,
+		`DELETE FROM note_terms WHERE owner = ?`,
+		`DELETE FROM attachments WHERE owner = ?`,
+		`DELETE FROM note_shares WHERE owner = ?`,
+		`DELETE FROM note_shares WHERE recipient = ?`,
+		`DELETE FROM note_links WHERE owner = ?`,
+		`DELETE FROM user_keys WHERE username = ?`,
+		`DELETE FROM notes WHERE owner = ?`,
+		`DELETE FROM tags WHERE owner = ?`,
+		`DELETE FROM notebooks WHERE owner = ?`,
+	} {
+		if _, err := tx.Exec(s.q(query), username); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteFTS(tx, `owner = ?`, username); err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.q(`DELETE FROM users WHERE username = ?`), username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
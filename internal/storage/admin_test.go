@@ -0,0 +1,21 @@
+package storage
+
+import "testing"
+
+func TestStoreUserAdmin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		name := newUser(t, s)
+		if err := s.SetUserRole(name, RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetUserDisabled(name, true); err != nil {
+			t.Fatal(err)
+		}
+		if u, _ := s.GetUser(name); u.Role != RoleAdmin || !u.Disabled {
+			t.Errorf("after SetUserRole and SetUserDisabled: %+v", u)
+		}
+		if err := s.SetUserRole("test_missing", RoleAdmin); err != ErrNotFound {
+			t.Errorf("SetUserRole of a missing user: %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Attachment is a file attached to a note. Its contents live in a blob
+// store, encrypted under a key of their own; here are the key, wrapped
+// under the owner's key, and the file name, encrypted under it.
+type Attachment struct {
+	ID          string
+	NoteID      string
+	Owner       string
+	Blob        string // key of the contents in the blob store
+	Name        []byte
+	NameNonce   []byte
+	ContentType string
+	Size        int64 // of the file, not of its encryption
+	Key         []byte
+	KeyNonce    []byte
+	Created     int64
+}
+
+// Blob records the contents of attachments kept in the blob store, with
+// the number of attachments that refer to them. Those that none refer to
+// are collected.
+type Blob struct {
+	Key     string
+	Refs    int64
+	Created int64
+}
+
+// MaxContentTypeLen bounds the media type recorded for an attachment.
+const MaxContentTypeLen = 255
+
+func validateAttachment(a Attachment) error {
+	if err := validateUsername(a.Owner); err != nil {
+		return err
+	}
+	if !isValidUUID(a.ID) {
+		return errors.New("invalid attachment id")
+	}
+	if !isValidUUID(a.NoteID) {
+		return errors.New("invalid note id")
+	}
+	if len(a.Key) == 0 || len(a.KeyNonce) == 0 {
+		return errors.New("missing attachment key")
+	}
+	if a.ContentType == "" || len(a.ContentType) > MaxContentTypeLen {
+		return errors.New("invalid content type")
+	}
+	if len(a.Blob) < 3 || len(a.Blob) > 64 {
+		return errors.New("invalid blob key")
+	}
+	if a.Size < 0 {
+		return errors.New("invalid size")
+	}
+	return validateName(a.Name, a.NameNonce)
+}
+
+const attachmentColumns = `id, note_id, owner, blob, name, name_nonce, content_type, size, file_key, key_nonce, created`
+
+func scanAttachment(row interface{ Scan(...any) error }) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.NoteID, &a.Owner, &a.Blob, &a.Name, &a.NameNonce, &a.ContentType, &a.Size, &a.Key, &a.KeyNonce, &a.Created)
+	return a, err
+}
+
+// CreateAttachment records an attachment of one of the owner's notes, which
+// must not be in the trash, and counts it as a reference to its blob.
+func (s *sqlStore) CreateAttachment(a Attachment) error {
+	if err := validateAttachment(a); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ? AND deleted_at IS NULL`), a.NoteID, a.Owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO attachments(`+attachmentColumns+`) VALUES(?,?,?,?,?,?,?,?,?,?,?)`),
+		a.ID, a.NoteID, a.Owner, a.Blob, a.Name, a.NameNonce, a.ContentType, a.Size, a.Key, a.KeyNonce, a.Created)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO blobs(id, refs, created) VALUES(?, 1, ?) ON CONFLICT(id) DO UPDATE SET refs = blobs.refs + 1`), a.Blob, a.Created)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetAttachment(id string) (Attachment, error) {
+	a, err := scanAttachment(s.queryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+	if err != nil {
+		return Attachment{}, notFound(err)
+	}
+	return a, nil
+}
+
+// ListAttachments returns the attachments of a note, oldest first.
+func (s *sqlStore) ListAttachments(noteID string) ([]Attachment, error) {
+	rows, err := s.query(`SELECT `+attachmentColumns+` FROM attachments WHERE note_id = ? ORDER BY created, id`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// DeleteAttachment forgets an attachment. Its blob is left to be collected
+// once no attachment refers to it.
+func (s *sqlStore) DeleteAttachment(id, owner string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	cond := `id = ? AND owner = ?`
+	if err := s.releaseBlobs(tx, cond, id, owner); err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.q(`DELETE FROM attachments WHERE `+cond), id, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// releaseBlobs drops the references of the attachments that match cond to
+// their blobs, before they are deleted.
+func (s *sqlStore) releaseBlobs(tx *sql.Tx, cond string, args ...any) error {
+	_, err := tx.Exec(s.q(`UPDATE blobs SET refs = refs - (SELECT COUNT(*) FROM attachments WHERE blob = blobs.id AND `+cond+`)
+WHERE id IN (SELECT blob FROM attachments WHERE `+cond+`)`), append(args, args...)...)
+	return err
+}
+
+func (s *sqlStore) GetBlob(key string) (Blob, error) {
+	var b Blob
+	err := s.queryRow(`SELECT id, refs, created FROM blobs WHERE id = ?`, key).Scan(&b.Key, &b.Refs, &b.Created)
+	if err != nil {
+		return Blob{}, notFound(err)
+	}
+	return b, nil
+}
+
+// ForgetBlob deletes the record of a blob that no attachment refers to,
+// before the blob itself is deleted. It fails with ErrNotFound when there
+// is no such record, or when the blob is in use again.
+func (s *sqlStore) ForgetBlob(key string) error {
+	return s.execOne(`DELETE FROM blobs WHERE id = ? AND refs <= 0`, key)
+}
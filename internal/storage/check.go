@@ -0,0 +1,110 @@
+package storage
+
+// Checker is implemented by stores whose contents `scrypts doctor` can
+// examine and repair. Unlike the rest of the Store its methods don't
+// validate their arguments, since they exist to deal with invalid rows.
+type Checker interface {
+	// AllUsers returns every user, keys included.
+	AllUsers() ([]User, error)
+	// EachNote calls fn with every note, stopping at the first error. fn must
+	// not use the store.
+	EachNote(fn func(Note) error) error
+	// SetNoteID moves a note, its revisions, tags and search terms to a new
+	// id.
+	SetNoteID(oldID, newID string) error
+	// PurgeNote deletes a note, its revisions, tags and search terms whatever
+	// its owner.
+	PurgeNote(id string) error
+}
+
+func (s *sqlStore) AllUsers() ([]User, error) {
+	rows, err := s.query(`SELECT username, password_hash, wrapped_key, wrapped_nonce, created_at, role, disabled FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.WrappedKey, &u.WrappedNonce, &u.CreatedAt, &u.Role, &u.Disabled); err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) EachNote(fn func(Note) error) error {
+	rows, err := s.query(`SELECT id, owner, content, nonce, title, title_nonce, created, modified, note_key, note_key_nonce FROM notes ORDER BY owner, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n Note
+		if err := rows.Scan(&n.ID, &n.Owner, &n.Content, &n.Nonce, &n.Title, &n.TitleNonce, &n.Created, &n.Modified, &n.Key, &n.KeyNonce); err != nil {
+			return err
+		}
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) SetNoteID(oldID, newID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`UPDATE notes SET id = ? WHERE id = ?`), newID, oldID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	for _, table := range []string{"note_revisions", "note_tags", "note_terms", "attachments", "note_shares", "note_links"} {
+		if _, err := tx.Exec(s.q(`UPDATE `+table+` SET note_id = ? WHERE note_id = ?`), newID, oldID); err != nil {
+			return err
+		}
+	}
+	if s.dialect == dialectSQLite {
+		if _, err := tx.Exec(`UPDATE note_fts_docs SET note_id = ? WHERE note_id = ?`, newID, oldID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) PurgeNote(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`DELETE FROM notes WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if err := s.releaseBlobs(tx, `note_id = ?`, id); err != nil {
+		return err
+	}
+	for _, table := range []string{"note_revisions", "note_tags", "note_terms", "attachments", "note_shares", "note_links"} {
+		if _, err := tx.Exec(s.q(`DELETE FROM `+table+` WHERE note_id = ?`), id); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteFTS(tx, `note_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNoFullText is returned by stores without a full-text index.
+var ErrNoFullText = errors.New("the full-text index needs SQLite")
+
+// FTSDoc is a note as the full-text index holds it: each field a string of
+// tokens, separated by spaces, that the caller derives from the note.
+type FTSDoc struct {
+	Title    string
+	Body     string
+	Prefixes string
+}
+
+// FullText is implemented by the stores that can keep a full-text index.
+// Only SQLite ones have one; the others fail with ErrNoFullText.
+type FullText interface {
+	// SearchNotesFTS returns the owner's notes outside the trash that
+	// match, an FTS5 query over the tokens, best first.
+	SearchNotesFTS(owner, match string, limit int) ([]Note, error)
+	// SetNoteFTS replaces the entry of the owner's note, in or out of the
+	// trash.
+	SetNoteFTS(id, owner string, doc FTSDoc) error
+}
+
+// setNoteFTS replaces the full-text entry of a note.
+func (s *sqlStore) setNoteFTS(tx *sql.Tx, owner, noteID string, doc FTSDoc) error {
+	if s.dialect != dialectSQLite {
+		return ErrNoFullText
+	}
+	var rowid int64
+	err := tx.QueryRow(`SELECT id FROM note_fts_docs WHERE note_id = ?`, noteID).Scan(&rowid)
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := tx.Exec(`INSERT INTO note_fts_docs(note_id, owner) VALUES(?,?)`, noteID, owner)
+		if err != nil {
+			return err
+		}
+		if rowid, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if _, err := tx.Exec(`DELETE FROM note_fts WHERE rowid = ?`, rowid); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO note_fts(rowid, title, body, prefixes) VALUES(?,?,?,?)`, rowid, doc.Title, doc.Body, doc.Prefixes)
+	return err
+}
+
+// deleteFTS takes the notes whose note_fts_docs rows match cond out of the
+// full-text index.
+func (s *sqlStore) deleteFTS(tx *sql.Tx, cond string, args ...any) error {
+	if s.dialect != dialectSQLite {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM note_fts WHERE rowid IN (SELECT id FROM note_fts_docs WHERE `+cond+`)`, args...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM note_fts_docs WHERE `+cond, args...)
+	return err
+}
+
+func (s *sqlStore) SetNoteFTS(id, owner string, doc FTSDoc) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ?`), id, owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	if err := s.setNoteFTS(tx, owner, id, doc); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) SearchNotesFTS(owner, match string, limit int) ([]Note, error) {
+	if s.dialect != dialectSQLite {
+		return nil, ErrNoFullText
+	}
+	if limit < 1 || limit > MaxNotesPage {
+		return nil, errors.New("invalid limit")
+	}
+	// titles weigh more than bodies; prefixes only select. Ties are broken by
+	// id so that a larger limit returns the notes of a smaller one first.
+	rows, err := s.query(`SELECT `+noteColumns+` FROM notes JOIN (
+  SELECT d.note_id, bm25(note_fts, 10.0, 1.0, 0.0) AS score
+  FROM note_fts JOIN note_fts_docs d ON d.id = note_fts.rowid
+  WHERE note_fts MATCH ? AND d.owner = ?
+) m ON m.note_id = notes.id
+WHERE deleted_at IS NULL ORDER BY m.score, modified DESC, id LIMIT ?`, match, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := []Note{}
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrInvalidInvite is returned when an invitation code is unknown, expired or
+// already used.
+var ErrInvalidInvite = errors.New("invalid or expired invitation code")
+
+// Invite is a single-use invitation. Only a hash of the code is stored; the
+// hash doubles as the invitation's ID.
+type Invite struct {
+	ID        string
+	CreatedBy string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedBy    string // empty until redeemed
+	UsedAt    int64
+}
+
+func (s *sqlStore) CreateInvite(inv Invite) error {
+	if inv.ID == "" {
+		return errors.New("invite id required")
+	}
+	_, err := s.exec(`INSERT INTO invites(id,created_by,created_at,expires_at) VALUES (?,?,?,?)`,
+		inv.ID, inv.CreatedBy, inv.CreatedAt, inv.ExpiresAt)
+	return err
+}
+
+func (s *sqlStore) ListInvites() ([]Invite, error) {
+	rows, err := s.query(`SELECT id, created_by, created_at, expires_at, used_by, used_at FROM invites ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Invite{}
+	for rows.Next() {
+		var inv Invite
+		var usedBy sql.NullString
+		var usedAt sql.NullInt64
+		if err := rows.Scan(&inv.ID, &inv.CreatedBy, &inv.CreatedAt, &inv.ExpiresAt, &usedBy, &usedAt); err != nil {
+			return nil, err
+		}
+		inv.UsedBy = usedBy.String
+		inv.UsedAt = usedAt.Int64
+		res = append(res, inv)
+	}
+	return res, rows.Err()
+}
+
+// DeleteInvite revokes an unused invitation. It returns ErrNotFound when
+// there is no unused invitation with that ID.
+func (s *sqlStore) DeleteInvite(id string) error {
+	return s.execOne(`DELETE FROM invites WHERE id = ? AND used_by IS NULL`, id)
+}
+
+// CreateUserWithInvite creates u and redeems the invitation in one
+// transaction, so a code can never be used twice and is not spent when the
+// user can't be created.
+func (s *sqlStore) CreateUserWithInvite(u User, inviteID string, now int64) error {
+	if err := validateUsername(u.Username); err != nil {
+		return err
+	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`UPDATE invites SET used_by = ?, used_at = ? WHERE id = ? AND used_by IS NULL AND expires_at > ?`),
+		u.Username, now, inviteID, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidInvite
+	}
+	if _, err := tx.Exec(s.q(`INSERT INTO users(username,password_hash,wrapped_key,wrapped_nonce,created_at,role,disabled) VALUES (?,?,?,?,?,?,?)`),
+		u.Username, u.PasswordHash, u.WrappedKey, u.WrappedNonce, u.CreatedAt, u.Role, u.Disabled); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreInvites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		admin := newUser(t, s)
+		inv := Invite{ID: uuid.New().String(), CreatedBy: admin, CreatedAt: 1000, ExpiresAt: 5000}
+		if err := s.CreateInvite(inv); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 8)
+		rand.Read(b)
+		u := User{Username: "test_" + hex.EncodeToString(b), PasswordHash: "x", CreatedAt: 2000}
+
+		if err := s.CreateUserWithInvite(u, inv.ID, 6000); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("CreateUserWithInvite with an expired invite: %v, want ErrInvalidInvite", err)
+		}
+		if _, err := s.GetUser(u.Username); err != ErrNotFound {
+			t.Errorf("user created with an expired invite: %v", err)
+		}
+		if err := s.CreateUserWithInvite(u, inv.ID, 2000); err != nil {
+			t.Fatal(err)
+		}
+		u2 := u
+		u2.Username += "_2"
+		if err := s.CreateUserWithInvite(u2, inv.ID, 2000); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("CreateUserWithInvite with a used invite: %v, want ErrInvalidInvite", err)
+		}
+		if err := s.DeleteInvite(inv.ID); err != ErrNotFound {
+			t.Errorf("DeleteInvite of a used invite: %v, want ErrNotFound", err)
+		}
+		invites, err := s.ListInvites()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, i := range invites {
+			if i.ID == inv.ID {
+				found = true
+				if i.UsedBy != u.Username || i.UsedAt != 2000 {
+					t.Errorf("redeemed invite = %+v", i)
+				}
+			}
+		}
+		if !found {
+			t.Error("ListInvites left out the redeemed invite")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package storage
+
+import "errors"
+
+// Link is a public link to a snapshot of a note, for people without an
+// account. The snapshot is encrypted under a key given to the owner when
+// the link was made and not kept, or derived from it and a password with
+// Salt and Iterations; only the ciphertext is stored.
+type Link struct {
+	ID         string
+	NoteID     string
+	Owner      string
+	Ciphertext []byte
+	Nonce      []byte
+	Salt       []byte // nil without a password
+	Iterations int
+	Created    int64
+	Expires    int64
+	MaxViews   int64 // 0 for any number
+	Views      int64
+}
+
+func validateLink(l Link) error {
+	if err := validateUsername(l.Owner); err != nil {
+		return err
+	}
+	if !isValidUUID(l.ID) || !isValidUUID(l.NoteID) {
+		return errors.New("invalid id")
+	}
+	if len(l.Ciphertext) == 0 || len(l.Nonce) == 0 {
+		return errors.New("missing ciphertext")
+	}
+	if l.Expires <= l.Created || l.MaxViews < 0 {
+		return errors.New("invalid limits")
+	}
+	return nil
+}
+
+// CreateLink stores a link to a note of its owner, which must not be in the
+// trash.
+func (s *sqlStore) CreateLink(l Link) error {
+	if err := validateLink(l); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ? AND deleted_at IS NULL`), l.NoteID, l.Owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO note_links(`+linkColumns+`) VALUES(?,?,?,?,?,?,?,?,?,?,?)`),
+		l.ID, l.NoteID, l.Owner, l.Ciphertext, l.Nonce, l.Salt, l.Iterations, l.Created, l.Expires, l.MaxViews, l.Views)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const linkColumns = `id, note_id, owner, ciphertext, nonce, salt, iterations, created, expires, max_views, views`
+
+func scanLink(row interface{ Scan(...any) error }) (Link, error) {
+	var l Link
+	err := row.Scan(&l.ID, &l.NoteID, &l.Owner, &l.Ciphertext, &l.Nonce, &l.Salt, &l.Iterations, &l.Created, &l.Expires, &l.MaxViews, &l.Views)
+	return l, err
+}
+
+// ListLinks returns the links to a note that haven't expired, newest first.
+func (s *sqlStore) ListLinks(noteID string, now int64) ([]Link, error) {
+	rows, err := s.query(`SELECT `+linkColumns+` FROM note_links WHERE note_id = ? AND expires > ? ORDER BY created DESC, id`, noteID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Link{}
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) DeleteLink(id, noteID, owner string) error {
+	return s.execOne(`DELETE FROM note_links WHERE id = ? AND note_id = ? AND owner = ?`, id, noteID, owner)
+}
+
+// ViewLink counts a view of a link that hasn't expired or run out of views,
+// to a note that isn't in the trash, and returns it, with the view counted.
+// A link is deleted with its last view.
+func (s *sqlStore) ViewLink(id string, now int64) (Link, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Link{}, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`UPDATE note_links SET views = views + 1
+WHERE id = ? AND expires > ? AND (max_views = 0 OR views < max_views)
+AND note_id IN (SELECT id FROM notes WHERE deleted_at IS NULL)`), id, now)
+	if err != nil {
+		return Link{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Link{}, err
+	} else if n == 0 {
+		return Link{}, ErrNotFound
+	}
+	l, err := scanLink(tx.QueryRow(s.q(`SELECT `+linkColumns+` FROM note_links WHERE id = ?`), id))
+	if err != nil {
+		return Link{}, err
+	}
+	if l.MaxViews > 0 && l.Views >= l.MaxViews {
+		if _, err := tx.Exec(s.q(`DELETE FROM note_links WHERE id = ?`), id); err != nil {
+			return Link{}, err
+		}
+	}
+	return l, tx.Commit()
+}
+
+// PurgeLinks deletes the links that have expired by now and returns how
+// many there were.
+func (s *sqlStore) PurgeLinks(now int64) (int64, error) {
+	res, err := s.exec(`DELETE FROM note_links WHERE expires <= ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
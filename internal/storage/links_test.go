@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreLinks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := newUser(t, s)
+		n := newNote(t, s, owner, 1000)
+		link := func(maxViews, expires int64) Link {
+			l := Link{
+				ID:         uuid.New().String(),
+				NoteID:     n.ID,
+				Owner:      owner,
+				Ciphertext: []byte("ciphertext"),
+				Nonce:      []byte("nonce"),
+				Created:    1000,
+				Expires:    expires,
+				MaxViews:   maxViews,
+			}
+			if err := s.CreateLink(l); err != nil {
+				t.Fatal(err)
+			}
+			return l
+		}
+
+		limited := link(2, 5000)
+		for views := int64(1); views <= 2; views++ {
+			l, err := s.ViewLink(limited.ID, 2000)
+			if err != nil {
+				t.Fatalf("view %d: %v", views, err)
+			}
+			if l.Views != views || string(l.Ciphertext) != "ciphertext" {
+				t.Errorf("view %d = %+v", views, l)
+			}
+		}
+		if _, err := s.ViewLink(limited.ID, 2000); err != ErrNotFound {
+			t.Errorf("ViewLink past the last view: %v, want ErrNotFound", err)
+		}
+
+		expiring := link(0, 3000)
+		if _, err := s.ViewLink(expiring.ID, 3000); err != ErrNotFound {
+			t.Errorf("ViewLink of an expired link: %v, want ErrNotFound", err)
+		}
+		kept := link(0, 9000)
+		if links, err := s.ListLinks(n.ID, 2000); err != nil || len(links) != 2 {
+			t.Errorf("ListLinks listed %d links (%v), want 2", len(links), err)
+		}
+		if n, err := s.PurgeLinks(4000); err != nil || n < 1 {
+			t.Errorf("PurgeLinks = %d, %v", n, err)
+		}
+		if err := s.DeleteLink(kept.ID, n.ID, "test_missing"); err != ErrNotFound {
+			t.Errorf("DeleteLink by another user: %v, want ErrNotFound", err)
+		}
+		if err := s.DeleteLink(kept.ID, n.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if links, _ := s.ListLinks(n.ID, 2000); len(links) != 0 {
+			t.Errorf("ListLinks listed %d links after deleting them", len(links))
+		}
+
+		// links to a note in the trash are suspended until it is restored
+		kept = link(0, 9000)
+		if err := s.TrashNote(n.ID, owner, 0, 2000); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.ViewLink(kept.ID, 2000); err != ErrNotFound {
+			t.Errorf("ViewLink of a trashed note: %v, want ErrNotFound", err)
+		}
+		if err := s.RestoreNote(n.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if l, err := s.ViewLink(kept.ID, 2000); err != nil || l.Views != 1 {
+			t.Errorf("ViewLink of a restored note = %+v, %v", l, err)
+		}
+		if err := s.TrashNote(n.ID, owner, 0, 2000); err != nil {
+			t.Fatal(err)
+		}
+		l := limited
+		l.ID = uuid.New().String()
+		if err := s.CreateLink(l); err != ErrNotFound {
+			t.Errorf("CreateLink to a trashed note: %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package storage
+
+import "testing"
+
+func TestStoreListNotes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := newUser(t, s)
+		var ids []string
+		for i := int64(1); i <= 5; i++ {
+			ids = append(ids, newNote(t, s, owner, 1000*i).ID)
+		}
+		newNote(t, s, newUser(t, s), 1500)
+
+		// newest first, two at a time
+		var got []string
+		q := NoteQuery{Owner: owner, Limit: 2}
+		for {
+			p, err := s.ListNotes(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Total != 5 {
+				t.Errorf("Total = %d, want 5", p.Total)
+			}
+			for _, n := range p.Notes {
+				got = append(got, n.ID)
+			}
+			if p.Next == nil {
+				break
+			}
+			q.After = p.Next
+		}
+		want := []string{ids[4], ids[3], ids[2], ids[1], ids[0]}
+		if len(got) != len(want) {
+			t.Fatalf("listed %d notes, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("listed %v, want %v", got, want)
+			}
+		}
+
+		p, err := s.ListNotes(NoteQuery{Owner: owner, Limit: 10, Asc: true, CreatedAfter: 1000, CreatedBefore: 5000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Notes) != 3 || p.Notes[0].ID != ids[1] || p.Total != 3 {
+			t.Errorf("oldest first between 1000 and 5000: %d notes, total %d", len(p.Notes), p.Total)
+		}
+	})
+}
@@ -0,0 +1,1344 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps everything in process memory. Data is lost on restart,
+// so it is only meant for development and tests.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	users    map[string]User
+	notes    map[string]Note
+	sessions map[string]Session
+	invites  map[string]Invite
+	// earlier revisions by note id, oldest first
+	revisions map[string][]NoteRevision
+	retention map[string]RevisionRetention
+	notebooks map[string]Notebook
+	tags      map[string]Tag
+	terms     map[string][][]byte // by note id
+
+	attachments map[string]Attachment
+	blobs       map[string]Blob
+
+	keyPairs map[string]KeyPair
+	shares   map[shareKey]Share
+	links    map[string]Link
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:    map[string]User{},
+		notes:    map[string]Note{},
+		sessions: map[string]Session{},
+		invites:  map[string]Invite{},
+
+		revisions: map[string][]NoteRevision{},
+		retention: map[string]RevisionRetention{},
+		notebooks: map[string]Notebook{},
+		tags:      map[string]Tag{},
+		terms:     map[string][][]byte{},
+
+		attachments: map[string]Attachment{},
+		blobs:       map[string]Blob{},
+
+		keyPairs: map[string]KeyPair{},
+		shares:   map[shareKey]Share{},
+		links:    map[string]Link{},
+	}
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func cloneUser(u User) User {
+	u.WrappedKey = cloneBytes(u.WrappedKey)
+	u.WrappedNonce = cloneBytes(u.WrappedNonce)
+	return u
+}
+
+func cloneNote(n Note) Note {
+	n.Terms = nil // kept in MemoryStore.terms
+	n.FTS = nil
+	n.Content = cloneBytes(n.Content)
+	n.Nonce = cloneBytes(n.Nonce)
+	n.Title = cloneBytes(n.Title)
+	n.TitleNonce = cloneBytes(n.TitleNonce)
+	n.Key, n.KeyNonce = cloneBytes(n.Key), cloneBytes(n.KeyNonce)
+	if n.Tags != nil {
+		n.Tags = append([]string(nil), n.Tags...)
+		sort.Strings(n.Tags)
+	}
+	return n
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+func (m *MemoryStore) createUser(u User) error {
+	if err := validateUsername(u.Username); err != nil {
+		return err
+	}
+	if _, ok := m.users[u.Username]; ok {
+		return errors.New("user already exists")
+	}
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	m.users[u.Username] = cloneUser(u)
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.createUser(u)
+}
+
+func (m *MemoryStore) CreateUserWithInvite(u User, inviteID string, now int64) error {
+	if err := validateUsername(u.Username); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invites[inviteID]
+	if !ok || inv.UsedBy != "" || inv.ExpiresAt <= now {
+		return ErrInvalidInvite
+	}
+	if err := m.createUser(u); err != nil {
+		return err
+	}
+	inv.UsedBy = u.Username
+	inv.UsedAt = now
+	m.invites[inviteID] = inv
+	return nil
+}
+
+func (m *MemoryStore) GetUser(username string) (User, error) {
+	if err := validateUsername(username); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[username]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return cloneUser(u), nil
+}
+
+// updateUser applies fn to the stored user, returning ErrNotFound if there
+// is none.
+func (m *MemoryStore) updateUser(username string, fn func(*User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[username]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(&u)
+	m.users[username] = u
+	return nil
+}
+
+func (m *MemoryStore) SetPasswordHash(username, hash string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	return m.updateUser(username, func(u *User) { u.PasswordHash = hash })
+}
+
+func (m *MemoryStore) SaveWrappedKey(username string, wrapped, nonce []byte) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	return m.updateUser(username, func(u *User) {
+		u.WrappedKey = cloneBytes(wrapped)
+		u.WrappedNonce = cloneBytes(nonce)
+	})
+}
+
+func (m *MemoryStore) SetUserRole(username, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return errors.New("invalid role")
+	}
+	return m.updateUser(username, func(u *User) { u.Role = role })
+}
+
+func (m *MemoryStore) SetUserDisabled(username string, disabled bool) error {
+	return m.updateUser(username, func(u *User) { u.Disabled = disabled })
+}
+
+func (m *MemoryStore) DeleteUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrNotFound
+	}
+	for id, s := range m.sessions {
+		if s.Username == username {
+			delete(m.sessions, id)
+		}
+	}
+	for id, n := range m.notes {
+		if n.Owner == username {
+			delete(m.notes, id)
+			delete(m.revisions, id)
+			delete(m.terms, id)
+		}
+	}
+	for id, nb := range m.notebooks {
+		if nb.Owner == username {
+			delete(m.notebooks, id)
+		}
+	}
+	for id, t := range m.tags {
+		if t.Owner == username {
+			delete(m.tags, id)
+		}
+	}
+	for id, a := range m.attachments {
+		if a.Owner == username {
+			m.deleteAttachment(id)
+		}
+	}
+	for k, sh := range m.shares {
+		if sh.Owner == username || sh.Recipient == username {
+			delete(m.shares, k)
+		}
+	}
+	for id, l := range m.links {
+		if l.Owner == username {
+			delete(m.links, id)
+		}
+	}
+	delete(m.keyPairs, username)
+	delete(m.users, username)
+	delete(m.retention, username)
+	return nil
+}
+
+func (m *MemoryStore) ListUserSummaries(now int64) ([]UserSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byName := map[string]*UserSummary{}
+	res := make([]UserSummary, 0, len(m.users))
+	for _, u := range m.users {
+		res = append(res, UserSummary{Username: u.Username, Role: u.Role, Disabled: u.Disabled, CreatedAt: u.CreatedAt})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Username < res[j].Username })
+	for i := range res {
+		byName[res[i].Username] = &res[i]
+	}
+	for _, n := range m.notes {
+		if sum, ok := byName[n.Owner]; ok {
+			sum.NoteCount++
+			sum.StorageBytes += int64(len(n.Content))
+			for _, r := range m.revisions[n.ID] {
+				sum.StorageBytes += r.Size
+			}
+		}
+	}
+	for _, s := range m.sessions {
+		if sum, ok := byName[s.Username]; ok && s.RevokedAt == 0 && s.ExpiresAt >= now {
+			sum.ActiveSessions++
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) SaveNote(n Note) error {
+	if err := validateNote(n); err != nil {
+		return err
+	}
+	if n.FTS != nil {
+		return ErrNoFullText
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[n.Owner]; !ok {
+		return errors.New("note owner does not exist")
+	}
+	if _, ok := m.notes[n.ID]; ok {
+		return errors.New("note already exists")
+	}
+	if n.Terms != nil {
+		m.terms[n.ID] = cloneTerms(n.Terms)
+	}
+	n = cloneNote(n)
+	n.Revision = 1
+	n.Key, n.KeyNonce = nil, nil // set by SetNoteKey only
+	m.notes[n.ID] = n
+	return nil
+}
+
+func (m *MemoryStore) UpdateNote(n Note) (int64, error) {
+	if err := validateNote(n); err != nil {
+		return 0, err
+	}
+	if n.FTS != nil {
+		return 0, ErrNoFullText
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.notes[n.ID]
+	if !ok || existing.Owner != n.Owner || existing.Deleted != 0 {
+		return 0, ErrNotFound
+	}
+	if n.Revision != 0 && n.Revision != existing.Revision {
+		return 0, ErrConflict
+	}
+	m.revisions[n.ID] = append(m.revisions[n.ID], NoteRevision{
+		NoteID: n.ID, Owner: n.Owner, Revision: existing.Revision,
+		Content: existing.Content, Nonce: existing.Nonce,
+		Title: existing.Title, TitleNonce: existing.TitleNonce,
+		Modified: existing.Modified, Archived: n.Modified,
+		Size: int64(len(existing.Content)),
+	})
+	if n.Terms != nil {
+		m.terms[n.ID] = cloneTerms(n.Terms)
+	}
+	n = cloneNote(n)
+	existing.Content = n.Content
+	existing.Nonce = n.Nonce
+	existing.Title = n.Title
+	existing.TitleNonce = n.TitleNonce
+	existing.Notebook = n.Notebook
+	existing.Tags = n.Tags
+	existing.Modified = n.Modified
+	existing.Revision++
+	m.notes[n.ID] = existing
+	return existing.Revision, nil
+}
+
+func (m *MemoryStore) ListNotes(q NoteQuery) (NotePage, error) {
+	if err := validateNoteQuery(&q); err != nil {
+		return NotePage{}, err
+	}
+	outside := func(v, after, before int64) bool {
+		return (after != 0 && v <= after) || (before != 0 && v >= before)
+	}
+	// before reports whether a comes before b in the list
+	before := func(at int64, aID string, bt int64, bID string) bool {
+		if at != bt {
+			return (at < bt) == q.Asc
+		}
+		if aID != bID {
+			return (aID < bID) == q.Asc
+		}
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []Note
+	total := 0
+	hasTags := func(n Note) bool {
+		for _, want := range q.Tags {
+			found := false
+			for _, t := range n.Tags {
+				found = found || t == want
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	for _, n := range m.notes {
+		if n.Owner != q.Owner || (n.Deleted != 0) != q.Trashed ||
+			(q.Notebook != "" && n.Notebook != q.Notebook) || !hasTags(n) ||
+			outside(n.Created, q.CreatedAfter, q.CreatedBefore) ||
+			outside(n.Modified, q.ModifiedAfter, q.ModifiedBefore) {
+			continue
+		}
+		total++
+		if q.After != nil && !before(q.After.Time, q.After.ID, q.sortTime(n), n.ID) {
+			continue
+		}
+		res = append(res, cloneNote(n))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return before(q.sortTime(res[i]), res[i].ID, q.sortTime(res[j]), res[j].ID)
+	})
+	if len(res) > q.Limit+1 {
+		res = res[:q.Limit+1]
+	}
+	return q.page(res, total), nil
+}
+
+func (m *MemoryStore) GetNoteByID(id string) (Note, error) {
+	if !isValidUUID(id) {
+		return Note{}, errors.New("invalid note id format")
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.notes[id]
+	if !ok {
+		return Note{}, ErrNotFound
+	}
+	return cloneNote(n), nil
+}
+
+func (m *MemoryStore) CreateSession(sess Session) error {
+	if err := validateUsername(sess.Username); err != nil {
+		return err
+	}
+	if !isValidUUID(sess.ID) {
+		return errors.New("invalid session id format")
+	}
+	if len(sess.UserAgent) > MaxUserAgentLen {
+		sess.UserAgent = sess.UserAgent[:MaxUserAgentLen]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.Username == sess.Username && s.ExpiresAt < sess.CreatedAt {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[sess.ID] = sess
+	return nil
+}
+
+func (m *MemoryStore) GetSession(id string) (Session, error) {
+	if !isValidUUID(id) {
+		return Session{}, errors.New("invalid session id format")
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+func (m *MemoryStore) TouchSession(id string, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok && s.LastSeen < now-60 {
+		s.LastSeen = now
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListSessions(username string, now int64) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Session{}
+	for _, s := range m.sessions {
+		if s.Username == username && s.RevokedAt == 0 && s.ExpiresAt >= now {
+			res = append(res, s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LastSeen > res[j].LastSeen })
+	return res, nil
+}
+
+func (m *MemoryStore) RevokeSession(id, username string, now int64) error {
+	if !isValidUUID(id) {
+		return errors.New("invalid session id format")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.Username != username || s.RevokedAt != 0 {
+		return ErrNotFound
+	}
+	s.RevokedAt = now
+	m.sessions[id] = s
+	return nil
+}
+
+// revokeWhere revokes every active session for which match returns true.
+func (m *MemoryStore) revokeWhere(now int64, match func(Session) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.RevokedAt == 0 && match(s) {
+			s.RevokedAt = now
+			m.sessions[id] = s
+		}
+	}
+}
+
+func (m *MemoryStore) RevokeOtherSessions(username, keepID string, now int64) error {
+	m.revokeWhere(now, func(s Session) bool { return s.Username == username && s.ID != keepID })
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserSessions(username string, now int64) error {
+	m.revokeWhere(now, func(s Session) bool { return s.Username == username })
+	return nil
+}
+
+func (m *MemoryStore) CreateInvite(inv Invite) error {
+	if inv.ID == "" {
+		return errors.New("invite id required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.invites[inv.ID]; ok {
+		return errors.New("invite already exists")
+	}
+	inv.UsedBy, inv.UsedAt = "", 0
+	m.invites[inv.ID] = inv
+	return nil
+}
+
+func (m *MemoryStore) ListInvites() ([]Invite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]Invite, 0, len(m.invites))
+	for _, inv := range m.invites {
+		res = append(res, inv)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt > res[j].CreatedAt })
+	return res, nil
+}
+
+func (m *MemoryStore) DeleteInvite(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invites[id]
+	if !ok || inv.UsedBy != "" {
+		return ErrNotFound
+	}
+	delete(m.invites, id)
+	return nil
+}
+
+func (m *MemoryStore) AllUsers() ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var res []User
+	for _, u := range m.users {
+		res = append(res, cloneUser(u))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Username < res[j].Username })
+	return res, nil
+}
+
+func (m *MemoryStore) EachNote(fn func(Note) error) error {
+	m.mu.RLock()
+	notes := make([]Note, 0, len(m.notes))
+	for _, n := range m.notes {
+		notes = append(notes, cloneNote(n))
+	}
+	m.mu.RUnlock()
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].Owner != notes[j].Owner {
+			return notes[i].Owner < notes[j].Owner
+		}
+		return notes[i].ID < notes[j].ID
+	})
+	for _, n := range notes {
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetNoteID(oldID, newID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[oldID]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.notes, oldID)
+	n.ID = newID
+	m.notes[newID] = n
+	if revs, ok := m.revisions[oldID]; ok {
+		delete(m.revisions, oldID)
+		for i := range revs {
+			revs[i].NoteID = newID
+		}
+		m.revisions[newID] = revs
+	}
+	if terms, ok := m.terms[oldID]; ok {
+		delete(m.terms, oldID)
+		m.terms[newID] = terms
+	}
+	for id, a := range m.attachments {
+		if a.NoteID == oldID {
+			a.NoteID = newID
+			m.attachments[id] = a
+		}
+	}
+	for k, sh := range m.shares {
+		if sh.NoteID == oldID {
+			delete(m.shares, k)
+			sh.NoteID = newID
+			m.shares[shareKey{newID, sh.Recipient}] = sh
+		}
+	}
+	for id, l := range m.links {
+		if l.NoteID == oldID {
+			l.NoteID = newID
+			m.links[id] = l
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) PurgeNote(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.notes[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.notes, id)
+	delete(m.revisions, id)
+	delete(m.terms, id)
+	m.deleteAttachments(id)
+	m.deleteShares(id)
+	m.deleteLinks(id)
+	return nil
+}
+
+func (m *MemoryStore) ListNoteRevisions(noteID, owner string) ([]NoteRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []NoteRevision{}
+	revs := m.revisions[noteID]
+	for i := len(revs) - 1; i >= 0; i-- {
+		if r := revs[i]; r.Owner == owner {
+			r.Content, r.Nonce = nil, nil
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) GetNoteRevision(noteID, owner string, revision int64) (NoteRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.revisions[noteID] {
+		if r.Owner == owner && r.Revision == revision {
+			r.Content, r.Nonce = cloneBytes(r.Content), cloneBytes(r.Nonce)
+			return r, nil
+		}
+	}
+	return NoteRevision{}, ErrNotFound
+}
+
+func (m *MemoryStore) PruneNoteRevisions(owner, noteID string, r RevisionRetention, now int64) error {
+	if err := validateRetention(r); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, revs := range m.revisions {
+		if noteID != "" && id != noteID {
+			continue
+		}
+		kept := revs[:0]
+		for i, rev := range revs {
+			old := r.Days > 0 && rev.Archived < now-int64(r.Days)*86400
+			many := r.Keep > 0 && len(revs)-i > r.Keep
+			if rev.Owner != owner || !(old || many) {
+				kept = append(kept, rev)
+			}
+		}
+		if len(kept) == 0 {
+			delete(m.revisions, id)
+		} else {
+			m.revisions[id] = kept
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetRevisionRetention(username string) (RevisionRetention, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.users[username]; !ok {
+		return RevisionRetention{}, ErrNotFound
+	}
+	return m.retention[username], nil
+}
+
+func (m *MemoryStore) SetRevisionRetention(username string, r RevisionRetention) error {
+	if err := validateRetention(r); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrNotFound
+	}
+	m.retention[username] = r
+	return nil
+}
+
+func (m *MemoryStore) TrashNote(id, owner string, revision, now int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok || n.Owner != owner || n.Deleted != 0 {
+		return ErrNotFound
+	}
+	if revision != 0 && revision != n.Revision {
+		return ErrConflict
+	}
+	n.Deleted = now
+	m.notes[id] = n
+	return nil
+}
+
+func (m *MemoryStore) RestoreNote(id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok || n.Owner != owner || n.Deleted == 0 {
+		return ErrNotFound
+	}
+	n.Deleted = 0
+	m.notes[id] = n
+	return nil
+}
+
+func (m *MemoryStore) DeleteNote(id, owner string) error {
+	n, err := m.deleteTrashed(func(n Note) bool { return n.ID == id && n.Owner == owner })
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (m *MemoryStore) EmptyTrash(owner string) (int64, error) {
+	return m.deleteTrashed(func(n Note) bool { return n.Owner == owner })
+}
+
+func (m *MemoryStore) PurgeTrash(before int64) (int64, error) {
+	return m.deleteTrashed(func(n Note) bool { return n.Deleted < before })
+}
+
+func (m *MemoryStore) deleteTrashed(match func(Note) bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for id, n := range m.notes {
+		if n.Deleted != 0 && match(n) {
+			delete(m.notes, id)
+			delete(m.revisions, id)
+			delete(m.terms, id)
+			m.deleteAttachments(id)
+			m.deleteShares(id)
+			m.deleteLinks(id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func cloneNotebook(nb Notebook) Notebook {
+	nb.Name = cloneBytes(nb.Name)
+	nb.Nonce = cloneBytes(nb.Nonce)
+	return nb
+}
+
+// checkParent is sqlStore.checkParent; m.mu must be held.
+func (m *MemoryStore) checkParent(id, parent, owner string) error {
+	for depth := 0; parent != ""; depth++ {
+		if parent == id {
+			return ErrNotebookCycle
+		}
+		if depth == MaxNotebookDepth {
+			return ErrNotebookDepth
+		}
+		nb, ok := m.notebooks[parent]
+		if !ok || nb.Owner != owner {
+			return ErrNotFound
+		}
+		parent = nb.Parent
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateNotebook(nb Notebook) error {
+	if err := validateNotebook(nb); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.notebooks[nb.ID]; ok {
+		return errors.New("notebook already exists")
+	}
+	if err := m.checkParent(nb.ID, nb.Parent, nb.Owner); err != nil {
+		return err
+	}
+	m.notebooks[nb.ID] = cloneNotebook(nb)
+	return nil
+}
+
+func (m *MemoryStore) GetNotebook(id string) (Notebook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nb, ok := m.notebooks[id]
+	if !ok {
+		return Notebook{}, ErrNotFound
+	}
+	return cloneNotebook(nb), nil
+}
+
+func (m *MemoryStore) ListNotebooks(owner string) ([]Notebook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Notebook{}
+	for _, nb := range m.notebooks {
+		if nb.Owner == owner {
+			res = append(res, cloneNotebook(nb))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created != res[j].Created {
+			return res[i].Created < res[j].Created
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func (m *MemoryStore) UpdateNotebook(nb Notebook) error {
+	if err := validateNotebook(nb); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.notebooks[nb.ID]
+	if !ok || existing.Owner != nb.Owner {
+		return ErrNotFound
+	}
+	if err := m.checkParent(nb.ID, nb.Parent, nb.Owner); err != nil {
+		return err
+	}
+	nb.Created = existing.Created
+	m.notebooks[nb.ID] = cloneNotebook(nb)
+	return nil
+}
+
+func (m *MemoryStore) DeleteNotebook(id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nb, ok := m.notebooks[id]
+	if !ok || nb.Owner != owner {
+		return ErrNotFound
+	}
+	for cid, c := range m.notebooks {
+		if c.Parent == id {
+			c.Parent = nb.Parent
+			m.notebooks[cid] = c
+		}
+	}
+	for nid, n := range m.notes {
+		if n.Notebook == id {
+			n.Notebook = nb.Parent
+			m.notes[nid] = n
+		}
+	}
+	delete(m.notebooks, id)
+	return nil
+}
+
+func (m *MemoryStore) CreateTag(t Tag) error {
+	if err := validateTag(t); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tags[t.ID]; ok {
+		return errors.New("tag already exists")
+	}
+	t.Name, t.Nonce = cloneBytes(t.Name), cloneBytes(t.Nonce)
+	m.tags[t.ID] = t
+	return nil
+}
+
+func (m *MemoryStore) ListTags(owner string) ([]Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Tag{}
+	for _, t := range m.tags {
+		if t.Owner == owner {
+			t.Name, t.Nonce = cloneBytes(t.Name), cloneBytes(t.Nonce)
+			res = append(res, t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created != res[j].Created {
+			return res[i].Created < res[j].Created
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func (m *MemoryStore) RenameTag(t Tag) error {
+	if err := validateTag(t); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.tags[t.ID]
+	if !ok || existing.Owner != t.Owner {
+		return ErrNotFound
+	}
+	existing.Name, existing.Nonce = cloneBytes(t.Name), cloneBytes(t.Nonce)
+	m.tags[t.ID] = existing
+	return nil
+}
+
+func (m *MemoryStore) DeleteTag(id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tags[id]
+	if !ok || t.Owner != owner {
+		return ErrNotFound
+	}
+	delete(m.tags, id)
+	for nid, n := range m.notes {
+		for i, tid := range n.Tags {
+			if tid == id {
+				n.Tags = append(n.Tags[:i:i], n.Tags[i+1:]...)
+				m.notes[nid] = n
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func cloneTerms(terms [][]byte) [][]byte {
+	res := make([][]byte, len(terms))
+	for i, t := range terms {
+		res[i] = cloneBytes(t)
+	}
+	return res
+}
+
+func (m *MemoryStore) SetNoteTerms(id, owner string, terms [][]byte) error {
+	if err := validateTerms(terms); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[id]
+	if !ok || n.Owner != owner {
+		return ErrNotFound
+	}
+	m.terms[id] = cloneTerms(terms)
+	return nil
+}
+
+func (m *MemoryStore) SearchNotes(owner string, terms [][]byte, limit int) ([]Note, error) {
+	if len(terms) == 0 || len(terms) > MaxSearchTerms {
+		return nil, errors.New("invalid number of search terms")
+	}
+	if err := validateTerms(terms); err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > MaxNotesPage {
+		return nil, errors.New("invalid limit")
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Note{}
+	for id, n := range m.notes {
+		if n.Owner != owner || n.Deleted != 0 {
+			continue
+		}
+		has := map[string]bool{}
+		for _, t := range m.terms[id] {
+			has[string(t)] = true
+		}
+		all := true
+		for _, t := range terms {
+			all = all && has[string(t)]
+		}
+		if all {
+			res = append(res, cloneNote(n))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Modified != res[j].Modified {
+			return res[i].Modified > res[j].Modified
+		}
+		return res[i].ID > res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func cloneAttachment(a Attachment) Attachment {
+	a.Name, a.NameNonce = cloneBytes(a.Name), cloneBytes(a.NameNonce)
+	a.Key, a.KeyNonce = cloneBytes(a.Key), cloneBytes(a.KeyNonce)
+	return a
+}
+
+func (m *MemoryStore) CreateAttachment(a Attachment) error {
+	if err := validateAttachment(a); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[a.NoteID]
+	if !ok || n.Owner != a.Owner || n.Deleted != 0 {
+		return ErrNotFound
+	}
+	if _, ok := m.attachments[a.ID]; ok {
+		return errors.New("attachment already exists")
+	}
+	m.attachments[a.ID] = cloneAttachment(a)
+	b, ok := m.blobs[a.Blob]
+	if !ok {
+		b = Blob{Key: a.Blob, Created: a.Created}
+	}
+	b.Refs++
+	m.blobs[a.Blob] = b
+	return nil
+}
+
+func (m *MemoryStore) GetAttachment(id string) (Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.attachments[id]
+	if !ok {
+		return Attachment{}, ErrNotFound
+	}
+	return cloneAttachment(a), nil
+}
+
+func (m *MemoryStore) ListAttachments(noteID string) ([]Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Attachment{}
+	for _, a := range m.attachments {
+		if a.NoteID == noteID {
+			res = append(res, cloneAttachment(a))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created != res[j].Created {
+			return res[i].Created < res[j].Created
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func (m *MemoryStore) DeleteAttachment(id, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attachments[id]
+	if !ok || a.Owner != owner {
+		return ErrNotFound
+	}
+	m.deleteAttachment(id)
+	return nil
+}
+
+// deleteAttachments forgets the attachments of a note; m.mu must be held.
+func (m *MemoryStore) deleteAttachments(noteID string) {
+	for id, a := range m.attachments {
+		if a.NoteID == noteID {
+			m.deleteAttachment(id)
+		}
+	}
+}
+
+// deleteAttachment forgets an attachment and its reference to its blob;
+// m.mu must be held.
+func (m *MemoryStore) deleteAttachment(id string) {
+	a := m.attachments[id]
+	if b, ok := m.blobs[a.Blob]; ok {
+		b.Refs--
+		m.blobs[a.Blob] = b
+	}
+	delete(m.attachments, id)
+}
+
+func (m *MemoryStore) GetBlob(key string) (Blob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return Blob{}, ErrNotFound
+	}
+	return b, nil
+}
+
+func (m *MemoryStore) ForgetBlob(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[key]
+	if !ok || b.Refs > 0 {
+		return ErrNotFound
+	}
+	delete(m.blobs, key)
+	return nil
+}
+
+// shareKey identifies a share in MemoryStore.shares.
+type shareKey struct {
+	noteID, recipient string
+}
+
+func (m *MemoryStore) GetKeyPair(username string) (KeyPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	kp, ok := m.keyPairs[username]
+	if !ok {
+		return KeyPair{}, ErrNotFound
+	}
+	kp.PublicKey, kp.PrivateKey, kp.PrivateNonce = cloneBytes(kp.PublicKey), cloneBytes(kp.PrivateKey), cloneBytes(kp.PrivateNonce)
+	return kp, nil
+}
+
+func (m *MemoryStore) CreateKeyPair(kp KeyPair) error {
+	if err := validateUsername(kp.Username); err != nil {
+		return err
+	}
+	if len(kp.PublicKey) == 0 || len(kp.PrivateKey) == 0 || len(kp.PrivateNonce) == 0 {
+		return errors.New("missing key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[kp.Username]; !ok {
+		return errors.New("user does not exist")
+	}
+	if _, ok := m.keyPairs[kp.Username]; ok {
+		return ErrConflict
+	}
+	kp.PublicKey, kp.PrivateKey, kp.PrivateNonce = cloneBytes(kp.PublicKey), cloneBytes(kp.PrivateKey), cloneBytes(kp.PrivateNonce)
+	m.keyPairs[kp.Username] = kp
+	return nil
+}
+
+func (m *MemoryStore) SetNoteKey(n Note, revs []NoteRevision) error {
+	if err := validateNote(n); err != nil {
+		return err
+	}
+	if len(n.Key) == 0 || len(n.KeyNonce) == 0 {
+		return errors.New("missing note key")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.notes[n.ID]
+	if !ok || existing.Owner != n.Owner || existing.Deleted != 0 {
+		return ErrNotFound
+	}
+	if existing.Revision != n.Revision || existing.Key != nil {
+		return ErrConflict
+	}
+	n = cloneNote(n)
+	existing.Content, existing.Nonce = n.Content, n.Nonce
+	existing.Title, existing.TitleNonce = n.Title, n.TitleNonce
+	existing.Key, existing.KeyNonce = n.Key, n.KeyNonce
+	m.notes[n.ID] = existing
+	old := m.revisions[n.ID]
+	for _, rev := range revs {
+		for i := range old {
+			if old[i].Revision == rev.Revision {
+				old[i].Content, old[i].Nonce = cloneBytes(rev.Content), cloneBytes(rev.Nonce)
+				old[i].Title, old[i].TitleNonce = cloneBytes(rev.Title), cloneBytes(rev.TitleNonce)
+				old[i].Size = int64(len(rev.Content))
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ShareNote(sh Share) error {
+	if err := validateShare(sh); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[sh.NoteID]
+	if !ok || n.Owner != sh.Owner || n.Deleted != 0 || n.Key == nil {
+		return ErrNotFound
+	}
+	if _, ok := m.users[sh.Recipient]; !ok {
+		return errors.New("recipient does not exist")
+	}
+	k := shareKey{sh.NoteID, sh.Recipient}
+	if old, ok := m.shares[k]; ok {
+		sh.Created = old.Created
+	}
+	sh.Key = cloneBytes(sh.Key)
+	m.shares[k] = sh
+	return nil
+}
+
+func (m *MemoryStore) GetShare(noteID, recipient string) (Share, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sh, ok := m.shares[shareKey{noteID, recipient}]
+	if !ok {
+		return Share{}, ErrNotFound
+	}
+	sh.Key = cloneBytes(sh.Key)
+	return sh, nil
+}
+
+func (m *MemoryStore) ListShares(noteID string) ([]Share, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Share{}
+	for _, sh := range m.shares {
+		if sh.NoteID == noteID {
+			sh.Key = cloneBytes(sh.Key)
+			res = append(res, sh)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Recipient < res[j].Recipient })
+	return res, nil
+}
+
+func (m *MemoryStore) DeleteShare(noteID, recipient string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := shareKey{noteID, recipient}
+	if _, ok := m.shares[k]; !ok {
+		return ErrNotFound
+	}
+	delete(m.shares, k)
+	return nil
+}
+
+func (m *MemoryStore) ListSharedNotes(recipient string) ([]SharedNote, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []SharedNote{}
+	for _, sh := range m.shares {
+		n, ok := m.notes[sh.NoteID]
+		if sh.Recipient != recipient || !ok || n.Deleted != 0 {
+			continue
+		}
+		n = cloneNote(n)
+		n.Notebook, n.Tags = "", nil
+		sh.Key = cloneBytes(sh.Key)
+		res = append(res, SharedNote{Note: n, Share: sh})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Note.Modified != res[j].Note.Modified {
+			return res[i].Note.Modified > res[j].Note.Modified
+		}
+		return res[i].Note.ID > res[j].Note.ID
+	})
+	return res, nil
+}
+
+// deleteShares forgets the shares of a note; m.mu must be held.
+func (m *MemoryStore) deleteShares(noteID string) {
+	for k := range m.shares {
+		if k.noteID == noteID {
+			delete(m.shares, k)
+		}
+	}
+}
+
+func cloneLink(l Link) Link {
+	l.Ciphertext, l.Nonce, l.Salt = cloneBytes(l.Ciphertext), cloneBytes(l.Nonce), cloneBytes(l.Salt)
+	return l
+}
+
+func (m *MemoryStore) CreateLink(l Link) error {
+	if err := validateLink(l); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.notes[l.NoteID]
+	if !ok || n.Owner != l.Owner || n.Deleted != 0 {
+		return ErrNotFound
+	}
+	if _, ok := m.links[l.ID]; ok {
+		return errors.New("link already exists")
+	}
+	m.links[l.ID] = cloneLink(l)
+	return nil
+}
+
+func (m *MemoryStore) ListLinks(noteID string, now int64) ([]Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []Link{}
+	for _, l := range m.links {
+		if l.NoteID == noteID && l.Expires > now {
+			res = append(res, cloneLink(l))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created != res[j].Created {
+			return res[i].Created > res[j].Created
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+func (m *MemoryStore) DeleteLink(id, noteID, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.links[id]
+	if !ok || l.NoteID != noteID || l.Owner != owner {
+		return ErrNotFound
+	}
+	delete(m.links, id)
+	return nil
+}
+
+func (m *MemoryStore) ViewLink(id string, now int64) (Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.links[id]
+	if !ok || l.Expires <= now || (l.MaxViews > 0 && l.Views >= l.MaxViews) {
+		return Link{}, ErrNotFound
+	}
+	if n, ok := m.notes[l.NoteID]; !ok || n.Deleted != 0 {
+		return Link{}, ErrNotFound
+	}
+	l.Views++
+	if l.MaxViews > 0 && l.Views >= l.MaxViews {
+		delete(m.links, id)
+	} else {
+		m.links[id] = l
+	}
+	return cloneLink(l), nil
+}
+
+func (m *MemoryStore) PurgeLinks(now int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for id, l := range m.links {
+		if l.Expires <= now {
+			delete(m.links, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// deleteLinks forgets the links to a note; m.mu must be held.
+func (m *MemoryStore) deleteLinks(noteID string) {
+	for id, l := range m.links {
+		if l.NoteID == noteID {
+			delete(m.links, id)
+		}
+	}
+}
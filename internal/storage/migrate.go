@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.up.sql with a matching
+// .down.sql, left out for migrations that can't be reverted without losing
+// data. Both dialects use the same version numbers.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of Up, recorded when the migration is applied
+}
+
+// MigrationStatus describes a known or recorded migration.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt int64 // zero while pending
+	// Mismatch is set when the recorded checksum differs from the embedded
+	// migration, i.e. the file was edited after it was applied.
+	Mismatch bool
+	// Missing is set for recorded migrations this binary doesn't know about,
+	// usually because the database was migrated by a newer version.
+	Missing bool
+}
+
+// Migrator is implemented by stores with a versioned schema.
+type Migrator interface {
+	// MigrateUp applies every pending migration and returns their versions.
+	MigrateUp() ([]int, error)
+	// MigrateDown reverts the latest n applied migrations and returns their versions.
+	MigrateDown(n int) ([]int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// ErrChecksumMismatch is returned when an applied migration no longer
+// matches the embedded one.
+var ErrChecksumMismatch = errors.New("applied migration has been modified")
+
+// ErrIrreversible is returned when reverting would go past a migration
+// that has no down script.
+var ErrIrreversible = errors.New("migration can't be reverted")
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt int64
+}
+
+func (s *sqlStore) ensureMigrationsTable() error {
+	var exists bool
+	switch s.dialect {
+	case dialectPostgres:
+		if err := s.db.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+			return err
+		}
+	default:
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&n); err != nil {
+			return err
+		}
+		exists = n > 0
+	}
+	if exists {
+		return nil
+	}
+	if _, err := s.db.Exec(`CREATE TABLE schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  applied_at BIGINT NOT NULL
+)`); err != nil {
+		return err
+	}
+	return s.adoptLegacySchema()
+}
+
+// adoptLegacySchema records the migrations already reflected in a database
+// created before schema_migrations existed, when the schema was applied with
+// CREATE TABLE IF NOT EXISTS on every start. The later ones are idempotent,
+// but adding columns is not.
+func (s *sqlStore) adoptLegacySchema() error {
+	hasUsers, err := s.columnExists("users", "username")
+	if err != nil || !hasUsers {
+		return err
+	}
+	adopt := 1
+	if hasRole, err := s.columnExists("users", "role"); err != nil {
+		return err
+	} else if hasRole {
+		adopt = 3
+	}
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, m := range migrations {
+		if m.Version > adopt {
+			break
+		}
+		if _, err := s.exec(`INSERT INTO schema_migrations(version,name,checksum,applied_at) VALUES (?,?,?,?)`,
+			m.Version, m.Name, m.Checksum, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) columnExists(table, column string) (bool, error) {
+	var n int
+	var err error
+	switch s.dialect {
+	case dialectPostgres:
+		err = s.db.QueryRow(`SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`, table, column).Scan(&n)
+	default:
+		err = s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	}
+	return n > 0, err
+}
+
+func (s *sqlStore) appliedMigrations() (map[int]appliedMigration, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.query(`SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[int]appliedMigration{}
+	for rows.Next() {
+		var v int
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		res[v] = a
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			st.AppliedAt = a.appliedAt
+			st.Mismatch = a.checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		res = append(res, st)
+	}
+	for v, a := range applied {
+		res = append(res, MigrationStatus{Version: v, Name: a.name, AppliedAt: a.appliedAt, Missing: true})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+func (s *sqlStore) MigrateUp() ([]int, error) {
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	// refuse to build on top of a schema we can't vouch for
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && a.checksum != m.Checksum {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, ErrChecksumMismatch)
+		}
+	}
+	var done []int
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(s.q(`INSERT INTO schema_migrations(version,name,checksum,applied_at) VALUES (?,?,?,?)`),
+				m.Version, m.Name, m.Checksum, time.Now().Unix())
+			return err
+		}); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+func (s *sqlStore) MigrateDown(n int) ([]int, error) {
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	// every migration is checked before any is reverted, so that an
+	// irreversible one doesn't stop the schema halfway
+	var plan []Migration
+	for i := len(migrations) - 1; i >= 0 && len(plan) < n; i-- {
+		m := migrations[i]
+		a, ok := applied[m.Version]
+		if !ok {
+			continue
+		}
+		if a.checksum != m.Checksum {
+			return nil, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, ErrChecksumMismatch)
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s): %w; the schema can't go below version %d", m.Version, m.Name, ErrIrreversible, m.Version)
+		}
+		plan = append(plan, m)
+	}
+	var done []int
+	for _, m := range plan {
+		if err := s.runMigration(m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(s.q(`DELETE FROM schema_migrations WHERE version = ?`), m.Version)
+			return err
+		}); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// runMigration executes script and record in one transaction.
+func (s *sqlStore) runMigration(script string, record func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PendingMigrations returns how many migrations m has yet to apply.
+func PendingMigrations(m Migrator) (int, error) {
+	status, err := m.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, st := range status {
+		if st.AppliedAt == 0 {
+			n++
+		}
+	}
+	return n, nil
+}
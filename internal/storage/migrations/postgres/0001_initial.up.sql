@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS users (
+  username TEXT PRIMARY KEY,
+  password_hash TEXT NOT NULL,
+  wrapped_key BYTEA,
+  wrapped_nonce BYTEA,
+  created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notes (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL REFERENCES users(username),
+  content BYTEA NOT NULL,
+  nonce BYTEA NOT NULL,
+  created BIGINT NOT NULL,
+  modified BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notes_owner ON notes(owner);
@@ -0,0 +1,3 @@
+CREATE INDEX idx_notes_owner ON notes(owner);
+DROP INDEX idx_notes_owner_modified;
+DROP INDEX idx_notes_owner_created;
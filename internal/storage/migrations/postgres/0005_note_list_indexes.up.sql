@@ -0,0 +1,5 @@
+-- the notes list pages through a user's notes by (created, id) or
+-- (modified, id); both indexes also serve lookups by owner alone
+CREATE INDEX idx_notes_owner_created ON notes(owner, created, id);
+CREATE INDEX idx_notes_owner_modified ON notes(owner, modified, id);
+DROP INDEX idx_notes_owner;
@@ -0,0 +1,2 @@
+DROP INDEX idx_notes_deleted_at;
+ALTER TABLE notes DROP COLUMN deleted_at;
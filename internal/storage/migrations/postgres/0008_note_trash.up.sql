@@ -0,0 +1,4 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS deleted_at BIGINT;
+
+-- for the purger; the trash is a small part of the notes
+CREATE INDEX IF NOT EXISTS idx_notes_deleted_at ON notes(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,40 @@
+-- names and titles are encrypted under the owner's key like note content,
+-- each with its own nonce
+
+CREATE TABLE IF NOT EXISTS notebooks (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL REFERENCES users(username),
+  parent_id TEXT,
+  name BYTEA NOT NULL,
+  nonce BYTEA NOT NULL,
+  created BIGINT NOT NULL,
+  modified BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notebooks_owner ON notebooks(owner);
+
+CREATE TABLE IF NOT EXISTS tags (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL REFERENCES users(username),
+  name BYTEA NOT NULL,
+  nonce BYTEA NOT NULL,
+  created BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tags_owner ON tags(owner);
+
+CREATE TABLE IF NOT EXISTS note_tags (
+  note_id TEXT NOT NULL,
+  tag_id TEXT NOT NULL,
+  PRIMARY KEY (note_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag ON note_tags(tag_id);
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS title BYTEA;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS title_nonce BYTEA;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS notebook_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_notes_notebook ON notes(notebook_id) WHERE notebook_id IS NOT NULL;
+
+ALTER TABLE note_revisions ADD COLUMN IF NOT EXISTS title BYTEA;
+ALTER TABLE note_revisions ADD COLUMN IF NOT EXISTS title_nonce BYTEA;
@@ -0,0 +1,12 @@
+-- blind search index: each term is a keyed hash of a word of a note's title
+-- or content, under a key derived from the owner's, so the server can match
+-- a query's words without storing the words themselves
+
+CREATE TABLE IF NOT EXISTS note_terms (
+  owner TEXT NOT NULL,
+  term BYTEA NOT NULL,
+  note_id TEXT NOT NULL,
+  PRIMARY KEY (owner, term, note_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_terms_note ON note_terms(note_id);
@@ -0,0 +1,18 @@
+-- files attached to notes: the contents are kept encrypted in a blob store
+-- under a key of their own, stored here wrapped under the owner's key; the
+-- file name is encrypted under the owner's key like a note title
+
+CREATE TABLE IF NOT EXISTS attachments (
+  id TEXT PRIMARY KEY,
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL REFERENCES users(username),
+  name BYTEA NOT NULL,
+  name_nonce BYTEA NOT NULL,
+  content_type TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  file_key BYTEA NOT NULL,
+  key_nonce BYTEA NOT NULL,
+  created BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_note ON attachments(note_id);
@@ -0,0 +1,4 @@
+DROP INDEX idx_attachments_blob;
+ALTER TABLE attachments DROP COLUMN blob;
+
+DROP TABLE blobs;
@@ -0,0 +1,17 @@
+-- attachment contents are stored by the SHA-256 of their encryption and
+-- counted here by the attachments that refer to them, so that those no
+-- longer used can be collected
+
+CREATE TABLE IF NOT EXISTS blobs (
+  id TEXT PRIMARY KEY,
+  refs BIGINT NOT NULL,
+  created BIGINT NOT NULL
+);
+
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS blob TEXT NOT NULL DEFAULT '';
+
+-- attachments from before keep their contents under their own id
+UPDATE attachments SET blob = id;
+INSERT INTO blobs(id, refs, created) SELECT id, 1, created FROM attachments;
+
+CREATE INDEX IF NOT EXISTS idx_attachments_blob ON attachments(blob);
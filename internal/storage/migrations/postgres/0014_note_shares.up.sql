@@ -0,0 +1,27 @@
+-- sharing notes: each user gets an X25519 keypair, the private key wrapped
+-- under their user key; a shared note gets a key of its own, wrapped under
+-- its owner's key, and each share holds it sealed to the recipient's public
+-- key
+
+CREATE TABLE IF NOT EXISTS user_keys (
+  username TEXT PRIMARY KEY REFERENCES users(username),
+  public_key BYTEA NOT NULL,
+  private_key BYTEA NOT NULL,
+  private_nonce BYTEA NOT NULL,
+  created BIGINT NOT NULL
+);
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_key BYTEA;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_key_nonce BYTEA;
+
+CREATE TABLE IF NOT EXISTS note_shares (
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL REFERENCES users(username),
+  recipient TEXT NOT NULL REFERENCES users(username),
+  note_key BYTEA NOT NULL,
+  permission TEXT NOT NULL,
+  created BIGINT NOT NULL,
+  PRIMARY KEY(note_id, recipient)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_shares_recipient ON note_shares(recipient);
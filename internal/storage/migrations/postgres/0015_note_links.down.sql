@@ -0,0 +1,3 @@
+DROP INDEX idx_note_links_expires;
+DROP INDEX idx_note_links_note;
+DROP TABLE note_links;
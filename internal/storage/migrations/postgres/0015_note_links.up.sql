@@ -0,0 +1,19 @@
+-- public links to snapshots of notes, encrypted under keys the server
+-- doesn't keep
+
+CREATE TABLE IF NOT EXISTS note_links (
+  id TEXT PRIMARY KEY,
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL REFERENCES users(username),
+  ciphertext BYTEA NOT NULL,
+  nonce BYTEA NOT NULL,
+  salt BYTEA,
+  iterations INTEGER NOT NULL DEFAULT 0,
+  created BIGINT NOT NULL,
+  expires BIGINT NOT NULL,
+  max_views BIGINT NOT NULL DEFAULT 0,
+  views BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_note ON note_links(note_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_expires ON note_links(expires);
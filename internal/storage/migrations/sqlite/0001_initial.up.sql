@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS users (
+  username TEXT PRIMARY KEY,
+  password_hash TEXT NOT NULL,
+  wrapped_key BLOB,
+  wrapped_nonce BLOB,
+  created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notes (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL,
+  content BLOB NOT NULL,
+  nonce BLOB NOT NULL,
+  created INTEGER NOT NULL,
+  modified INTEGER NOT NULL,
+  FOREIGN KEY(owner) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notes_owner ON notes(owner);
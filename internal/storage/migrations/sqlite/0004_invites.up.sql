@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS invites (
+  id TEXT PRIMARY KEY,
+  created_by TEXT NOT NULL,
+  created_at INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL,
+  used_by TEXT,
+  used_at INTEGER
+);
@@ -0,0 +1 @@
+ALTER TABLE notes ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN revisions_days;
+ALTER TABLE users DROP COLUMN revisions_keep;
+DROP TABLE note_revisions;
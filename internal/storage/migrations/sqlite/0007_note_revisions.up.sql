@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS note_revisions (
+  note_id TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  owner TEXT NOT NULL,
+  content BLOB NOT NULL,
+  nonce BLOB NOT NULL,
+  modified INTEGER NOT NULL,
+  archived INTEGER NOT NULL,
+  PRIMARY KEY (note_id, revision)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_revisions_owner ON note_revisions(owner, archived);
+
+ALTER TABLE users ADD COLUMN revisions_keep INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN revisions_days INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+ALTER TABLE note_revisions DROP COLUMN title_nonce;
+ALTER TABLE note_revisions DROP COLUMN title;
+
+DROP INDEX idx_notes_notebook;
+ALTER TABLE notes DROP COLUMN notebook_id;
+ALTER TABLE notes DROP COLUMN title_nonce;
+ALTER TABLE notes DROP COLUMN title;
+
+DROP TABLE note_tags;
+DROP TABLE tags;
+DROP TABLE notebooks;
@@ -0,0 +1,42 @@
+-- names and titles are encrypted under the owner's key like note content,
+-- each with its own nonce
+
+CREATE TABLE IF NOT EXISTS notebooks (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL,
+  parent_id TEXT,
+  name BLOB NOT NULL,
+  nonce BLOB NOT NULL,
+  created INTEGER NOT NULL,
+  modified INTEGER NOT NULL,
+  FOREIGN KEY(owner) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notebooks_owner ON notebooks(owner);
+
+CREATE TABLE IF NOT EXISTS tags (
+  id TEXT PRIMARY KEY,
+  owner TEXT NOT NULL,
+  name BLOB NOT NULL,
+  nonce BLOB NOT NULL,
+  created INTEGER NOT NULL,
+  FOREIGN KEY(owner) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_tags_owner ON tags(owner);
+
+CREATE TABLE IF NOT EXISTS note_tags (
+  note_id TEXT NOT NULL,
+  tag_id TEXT NOT NULL,
+  PRIMARY KEY (note_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag ON note_tags(tag_id);
+
+ALTER TABLE notes ADD COLUMN title BLOB;
+ALTER TABLE notes ADD COLUMN title_nonce BLOB;
+ALTER TABLE notes ADD COLUMN notebook_id TEXT;
+CREATE INDEX idx_notes_notebook ON notes(notebook_id) WHERE notebook_id IS NOT NULL;
+
+ALTER TABLE note_revisions ADD COLUMN title BLOB;
+ALTER TABLE note_revisions ADD COLUMN title_nonce BLOB;
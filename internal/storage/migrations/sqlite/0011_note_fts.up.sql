@@ -0,0 +1,14 @@
+-- full-text index, used with SCRYPTS_SEARCH=fts: the words of notes are
+-- stored as tokens, keyed hashes under a key derived from the owner's, in
+-- an FTS5 table whose rows note_fts_docs ties to the notes. PostgreSQL has
+-- no equivalent, so this migration is SQLite's only.
+
+CREATE TABLE IF NOT EXISTS note_fts_docs (
+  id INTEGER PRIMARY KEY,
+  note_id TEXT NOT NULL UNIQUE,
+  owner TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_fts_docs_owner ON note_fts_docs(owner);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS note_fts USING fts5(title, body, prefixes, tokenize = 'ascii');
@@ -0,0 +1,19 @@
+-- files attached to notes: the contents are kept encrypted in a blob store
+-- under a key of their own, stored here wrapped under the owner's key; the
+-- file name is encrypted under the owner's key like a note title
+
+CREATE TABLE IF NOT EXISTS attachments (
+  id TEXT PRIMARY KEY,
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL,
+  name BLOB NOT NULL,
+  name_nonce BLOB NOT NULL,
+  content_type TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  file_key BLOB NOT NULL,
+  key_nonce BLOB NOT NULL,
+  created INTEGER NOT NULL,
+  FOREIGN KEY(owner) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_note ON attachments(note_id);
@@ -0,0 +1,30 @@
+-- sharing notes: each user gets an X25519 keypair, the private key wrapped
+-- under their user key; a shared note gets a key of its own, wrapped under
+-- its owner's key, and each share holds it sealed to the recipient's public
+-- key
+
+CREATE TABLE IF NOT EXISTS user_keys (
+  username TEXT PRIMARY KEY,
+  public_key BLOB NOT NULL,
+  private_key BLOB NOT NULL,
+  private_nonce BLOB NOT NULL,
+  created INTEGER NOT NULL,
+  FOREIGN KEY(username) REFERENCES users(username)
+);
+
+ALTER TABLE notes ADD COLUMN note_key BLOB;
+ALTER TABLE notes ADD COLUMN note_key_nonce BLOB;
+
+CREATE TABLE IF NOT EXISTS note_shares (
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL,
+  recipient TEXT NOT NULL,
+  note_key BLOB NOT NULL,
+  permission TEXT NOT NULL,
+  created INTEGER NOT NULL,
+  PRIMARY KEY(note_id, recipient),
+  FOREIGN KEY(owner) REFERENCES users(username),
+  FOREIGN KEY(recipient) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_shares_recipient ON note_shares(recipient);
@@ -0,0 +1,20 @@
+-- public links to snapshots of notes, encrypted under keys the server
+-- doesn't keep
+
+CREATE TABLE IF NOT EXISTS note_links (
+  id TEXT PRIMARY KEY,
+  note_id TEXT NOT NULL,
+  owner TEXT NOT NULL,
+  ciphertext BLOB NOT NULL,
+  nonce BLOB NOT NULL,
+  salt BLOB,
+  iterations INTEGER NOT NULL DEFAULT 0,
+  created INTEGER NOT NULL,
+  expires INTEGER NOT NULL,
+  max_views INTEGER NOT NULL DEFAULT 0,
+  views INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(owner) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_note ON note_links(note_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_expires ON note_links(expires);
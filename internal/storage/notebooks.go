@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNotebookCycle is returned when a notebook would be moved into itself or
+// one of its descendants.
+var ErrNotebookCycle = errors.New("a notebook can't be moved into itself")
+
+// ErrNotebookDepth is returned when notebooks would nest deeper than
+// MaxNotebookDepth.
+var ErrNotebookDepth = errors.New("notebooks nest too deeply")
+
+// MaxNotebookDepth bounds how deeply notebooks nest.
+const MaxNotebookDepth = 32
+
+// Notebook holds notes and other notebooks. Its name is encrypted under the
+// owner's key like the content of a note.
+type Notebook struct {
+	ID       string
+	Owner    string
+	Parent   string // id of the enclosing notebook, "" at the top
+	Name     []byte
+	Nonce    []byte
+	Created  int64
+	Modified int64
+}
+
+// Tag is a label given to notes, with its name encrypted like a notebook's.
+type Tag struct {
+	ID      string
+	Owner   string
+	Name    []byte
+	Nonce   []byte
+	Created int64
+}
+
+func validateName(name, nonce []byte) error {
+	if len(name) == 0 || len(nonce) == 0 {
+		return errors.New("missing name or nonce")
+	}
+	if len(name) > MaxNameSize+gcmOverhead {
+		return errors.New("name too long")
+	}
+	return nil
+}
+
+func validateNotebook(nb Notebook) error {
+	if err := validateUsername(nb.Owner); err != nil {
+		return err
+	}
+	if !isValidUUID(nb.ID) {
+		return errors.New("invalid notebook id")
+	}
+	if nb.Parent != "" && !isValidUUID(nb.Parent) {
+		return errors.New("invalid parent notebook id")
+	}
+	if nb.Parent == nb.ID {
+		return ErrNotebookCycle
+	}
+	return validateName(nb.Name, nb.Nonce)
+}
+
+func validateTag(t Tag) error {
+	if err := validateUsername(t.Owner); err != nil {
+		return err
+	}
+	if !isValidUUID(t.ID) {
+		return errors.New("invalid tag id")
+	}
+	return validateName(t.Name, t.Nonce)
+}
+
+// checkParent makes sure that the owner's notebook parent exists and that
+// putting notebook id in it neither makes a cycle nor nests too deeply.
+func (s *sqlStore) checkParent(tx *sql.Tx, id, parent, owner string) error {
+	for depth := 0; parent != ""; depth++ {
+		if parent == id {
+			return ErrNotebookCycle
+		}
+		if depth == MaxNotebookDepth {
+			return ErrNotebookDepth
+		}
+		var next sql.NullString
+		err := tx.QueryRow(s.q(`SELECT parent_id FROM notebooks WHERE id = ? AND owner = ?`), parent, owner).Scan(&next)
+		if err != nil {
+			return notFound(err)
+		}
+		parent = next.String
+	}
+	return nil
+}
+
+// CreateNotebook adds a notebook. It fails with ErrNotFound when the parent
+// doesn't exist.
+func (s *sqlStore) CreateNotebook(nb Notebook) error {
+	if err := validateNotebook(nb); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.checkParent(tx, nb.ID, nb.Parent, nb.Owner); err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO notebooks(id, owner, parent_id, name, nonce, created, modified) VALUES(?,?,?,?,?,?,?)`),
+		nb.ID, nb.Owner, nullIfEmpty(nb.Parent), nb.Name, nb.Nonce, nb.Created, nb.Modified)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const notebookColumns = `id, owner, COALESCE(parent_id, ''), name, nonce, created, modified`
+
+func scanNotebook(row interface{ Scan(...any) error }) (Notebook, error) {
+	var nb Notebook
+	err := row.Scan(&nb.ID, &nb.Owner, &nb.Parent, &nb.Name, &nb.Nonce, &nb.Created, &nb.Modified)
+	return nb, err
+}
+
+func (s *sqlStore) GetNotebook(id string) (Notebook, error) {
+	nb, err := scanNotebook(s.queryRow(`SELECT `+notebookColumns+` FROM notebooks WHERE id = ?`, id))
+	if err != nil {
+		return Notebook{}, notFound(err)
+	}
+	return nb, nil
+}
+
+// ListNotebooks returns all of the owner's notebooks, oldest first.
+func (s *sqlStore) ListNotebooks(owner string) ([]Notebook, error) {
+	rows, err := s.query(`SELECT `+notebookColumns+` FROM notebooks WHERE owner = ? ORDER BY created, id`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Notebook{}
+	for rows.Next() {
+		nb, err := scanNotebook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, nb)
+	}
+	return res, rows.Err()
+}
+
+// UpdateNotebook renames a notebook or moves it to another parent. It fails
+// with ErrNotebookCycle when the new parent is inside the notebook.
+func (s *sqlStore) UpdateNotebook(nb Notebook) error {
+	if err := validateNotebook(nb); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.checkParent(tx, nb.ID, nb.Parent, nb.Owner); err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.q(`UPDATE notebooks SET parent_id = ?, name = ?, nonce = ?, modified = ? WHERE id = ? AND owner = ?`),
+		nullIfEmpty(nb.Parent), nb.Name, nb.Nonce, nb.Modified, nb.ID, nb.Owner)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// DeleteNotebook deletes a notebook. The notes and notebooks in it move up
+// to its parent.
+func (s *sqlStore) DeleteNotebook(id, owner string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var parent sql.NullString
+	err = tx.QueryRow(s.q(`SELECT parent_id FROM notebooks WHERE id = ? AND owner = ?`), id, owner).Scan(&parent)
+	if err != nil {
+		return notFound(err)
+	}
+	if _, err := tx.Exec(s.q(`UPDATE notebooks SET parent_id = ? WHERE parent_id = ? AND owner = ?`), parent, id, owner); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.q(`UPDATE notes SET notebook_id = ? WHERE notebook_id = ? AND owner = ?`), parent, id, owner); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.q(`DELETE FROM notebooks WHERE id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) CreateTag(t Tag) error {
+	if err := validateTag(t); err != nil {
+		return err
+	}
+	_, err := s.exec(`INSERT INTO tags(id, owner, name, nonce, created) VALUES(?,?,?,?,?)`, t.ID, t.Owner, t.Name, t.Nonce, t.Created)
+	return err
+}
+
+// ListTags returns all of the owner's tags, oldest first.
+func (s *sqlStore) ListTags(owner string) ([]Tag, error) {
+	rows, err := s.query(`SELECT id, owner, name, nonce, created FROM tags WHERE owner = ? ORDER BY created, id`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Owner, &t.Name, &t.Nonce, &t.Created); err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, rows.Err()
+}
+
+// RenameTag replaces the name of a tag.
+func (s *sqlStore) RenameTag(t Tag) error {
+	if err := validateTag(t); err != nil {
+		return err
+	}
+	return s.execOne(`UPDATE tags SET name = ?, nonce = ? WHERE id = ? AND owner = ?`, t.Name, t.Nonce, t.ID, t.Owner)
+}
+
+// DeleteTag deletes a tag and takes it off the notes that had it.
+func (s *sqlStore) DeleteTag(id, owner string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`DELETE FROM tags WHERE id = ? AND owner = ?`), id, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(s.q(`DELETE FROM note_tags WHERE tag_id = ?`), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresStore is a Store backed by PostgreSQL, for deployments that outgrow
+// a single SQLite file.
+type PostgresStore struct {
+	sqlStore
+}
+
+// OpenPostgres connects to the database named by dsn, a libpq connection
+// string or postgres:// URL.
+func OpenPostgres(dsn string, opts Options) (*PostgresStore, error) {
+	if opts.WriteConns <= 0 {
+		opts.WriteConns = 10
+	}
+	db, err := openPostgresPool(dsn, opts.WriteConns)
+	if err != nil {
+		return nil, err
+	}
+	s := &PostgresStore{sqlStore{db: db, dialect: dialectPostgres}}
+	if opts.ReadConns > 0 {
+		// pgx passes unknown connection parameters on to the server as settings
+		readDSN := dsn + " default_transaction_read_only=on"
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			sep := "?"
+			if strings.Contains(dsn, "?") {
+				sep = "&"
+			}
+			readDSN = dsn + sep + "default_transaction_read_only=on"
+		}
+		rdb, err := openPostgresPool(readDSN, opts.ReadConns)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		s.rdb = rdb
+	}
+	return s, nil
+}
+
+func openPostgresPool(dsn string, conns int) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(conns)
+	db.SetMaxIdleConns(conns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
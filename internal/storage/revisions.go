@@ -0,0 +1,125 @@
+package storage
+
+import "errors"
+
+// NoteRevision is an earlier content and title of a note, encrypted as they
+// were.
+type NoteRevision struct {
+	NoteID     string
+	Owner      string
+	Revision   int64
+	Content    []byte // left empty by ListNoteRevisions, like the title
+	Nonce      []byte
+	Title      []byte
+	TitleNonce []byte
+	Modified   int64 // when this revision was written
+	Archived   int64 // when it was replaced by the next one
+	Size       int64 // length of the encrypted content
+}
+
+// RevisionRetention limits how many earlier revisions of each note are kept
+// (Keep) and for how many days after they were replaced (Days). Zero means
+// no limit.
+type RevisionRetention struct {
+	Keep int
+	Days int
+}
+
+// Cap returns r with each limit lowered to the one of max where max has
+// one.
+func (r RevisionRetention) Cap(max RevisionRetention) RevisionRetention {
+	lower := func(v, max int) int {
+		if max > 0 && (v == 0 || v > max) {
+			return max
+		}
+		return v
+	}
+	return RevisionRetention{Keep: lower(r.Keep, max.Keep), Days: lower(r.Days, max.Days)}
+}
+
+func validateRetention(r RevisionRetention) error {
+	if r.Keep < 0 || r.Days < 0 {
+		return errors.New("retention limits can't be negative")
+	}
+	return nil
+}
+
+// ListNoteRevisions returns the earlier revisions of a note, newest first,
+// without their content.
+func (s *sqlStore) ListNoteRevisions(noteID, owner string) ([]NoteRevision, error) {
+	rows, err := s.query(`SELECT revision, modified, archived, LENGTH(content) FROM note_revisions WHERE note_id = ? AND owner = ? ORDER BY revision DESC`,
+		noteID, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []NoteRevision{}
+	for rows.Next() {
+		r := NoteRevision{NoteID: noteID, Owner: owner}
+		if err := rows.Scan(&r.Revision, &r.Modified, &r.Archived, &r.Size); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) GetNoteRevision(noteID, owner string, revision int64) (NoteRevision, error) {
+	r := NoteRevision{NoteID: noteID, Owner: owner, Revision: revision}
+	err := s.queryRow(`SELECT content, nonce, title, title_nonce, modified, archived FROM note_revisions WHERE note_id = ? AND owner = ? AND revision = ?`,
+		noteID, owner, revision).Scan(&r.Content, &r.Nonce, &r.Title, &r.TitleNonce, &r.Modified, &r.Archived)
+	if err != nil {
+		return NoteRevision{}, notFound(err)
+	}
+	r.Size = int64(len(r.Content))
+	return r, nil
+}
+
+// PruneNoteRevisions deletes the revisions of the owner's note that r no
+// longer keeps, or those of all their notes when noteID is "".
+func (s *sqlStore) PruneNoteRevisions(owner, noteID string, r RevisionRetention, now int64) error {
+	if err := validateRetention(r); err != nil {
+		return err
+	}
+	where := `owner = ?`
+	args := []any{owner}
+	if noteID != "" {
+		where += ` AND note_id = ?`
+		args = append(args, noteID)
+	}
+	if r.Days > 0 {
+		_, err := s.exec(`DELETE FROM note_revisions WHERE `+where+` AND archived < ?`,
+			append(args, now-int64(r.Days)*86400)...)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Keep > 0 {
+		_, err := s.exec(`
+DELETE FROM note_revisions WHERE `+where+` AND (note_id, revision) IN (
+  SELECT note_id, revision FROM (
+    SELECT note_id, revision, ROW_NUMBER() OVER (PARTITION BY note_id ORDER BY revision DESC) AS n
+    FROM note_revisions WHERE `+where+`
+  ) ranked WHERE n > ?)`, append(append(args, args...), r.Keep)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) GetRevisionRetention(username string) (RevisionRetention, error) {
+	var r RevisionRetention
+	err := s.queryRow(`SELECT revisions_keep, revisions_days FROM users WHERE username = ?`, username).Scan(&r.Keep, &r.Days)
+	if err != nil {
+		return RevisionRetention{}, notFound(err)
+	}
+	return r, nil
+}
+
+func (s *sqlStore) SetRevisionRetention(username string, r RevisionRetention) error {
+	if err := validateRetention(r); err != nil {
+		return err
+	}
+	return s.execOne(`UPDATE users SET revisions_keep = ?, revisions_days = ? WHERE username = ?`, r.Keep, r.Days, username)
+}
@@ -0,0 +1,49 @@
+package storage
+
+import "testing"
+
+func TestStoreUpdateNote(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := newUser(t, s)
+		n := newNote(t, s, owner, 1000)
+
+		n.Content = []byte("changed")
+		n.Modified = 2000
+		rev, err := s.UpdateNote(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev != 2 {
+			t.Errorf("UpdateNote returned revision %d, want 2", rev)
+		}
+		// n.Revision is still 1
+		if _, err := s.UpdateNote(n); err != ErrConflict {
+			t.Errorf("UpdateNote at a stale revision: %v, want ErrConflict", err)
+		}
+
+		got, err := s.GetNoteByID(n.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Content) != "changed" || got.Revision != 2 || got.Modified != 2000 {
+			t.Errorf("GetNoteByID = %+v", got)
+		}
+		revs, err := s.ListNoteRevisions(n.ID, owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revs) != 1 || revs[0].Revision != 1 || revs[0].Archived != 2000 {
+			t.Errorf("ListNoteRevisions = %+v", revs)
+		}
+		if r, err := s.GetNoteRevision(n.ID, owner, 1); err != nil || string(r.Content) != "content" {
+			t.Errorf("GetNoteRevision = %+v, %v", r, err)
+		}
+
+		other := newUser(t, s)
+		n.Owner = other
+		n.Revision = 0
+		if _, err := s.UpdateNote(n); err != ErrNotFound {
+			t.Errorf("UpdateNote of another user's note: %v, want ErrNotFound", err)
+		}
+	})
+}
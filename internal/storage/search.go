@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// The search index maps terms, which the caller derives from the words of a
+// note in a way the store knows nothing about, to the notes that have them.
+// Notes in the trash keep their terms but aren't found.
+
+// MaxTermSize bounds the length of a search term, and MaxSearchTerms the
+// number of terms of a search.
+const (
+	MaxTermSize    = 64
+	MaxSearchTerms = 32
+)
+
+func validateTerms(terms [][]byte) error {
+	for _, t := range terms {
+		if len(t) == 0 || len(t) > MaxTermSize {
+			return errors.New("invalid search term")
+		}
+	}
+	return nil
+}
+
+// setNoteTerms replaces the search terms of a note.
+func (s *sqlStore) setNoteTerms(tx *sql.Tx, owner, noteID string, terms [][]byte) error {
+	if _, err := tx.Exec(s.q(`DELETE FROM note_terms WHERE note_id = ?`), noteID); err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(s.q(`INSERT INTO note_terms(owner, term, note_id) VALUES(?,?,?) ON CONFLICT DO NOTHING`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range terms {
+		if _, err := stmt.Exec(owner, t, noteID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetNoteTerms replaces the search terms of the owner's note, in or out of
+// the trash.
+func (s *sqlStore) SetNoteTerms(id, owner string, terms [][]byte) error {
+	if err := validateTerms(terms); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ?`), id, owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	if err := s.setNoteTerms(tx, owner, id, terms); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SearchNotes returns the owner's notes outside the trash that have all of
+// the terms, most recently modified first.
+func (s *sqlStore) SearchNotes(owner string, terms [][]byte, limit int) ([]Note, error) {
+	if len(terms) == 0 || len(terms) > MaxSearchTerms {
+		return nil, errors.New("invalid number of search terms")
+	}
+	if err := validateTerms(terms); err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > MaxNotesPage {
+		return nil, errors.New("invalid limit")
+	}
+	// a note has all of the terms when it has as many distinct ones
+	args := []any{owner}
+	seen := map[string]bool{}
+	for _, t := range terms {
+		if !seen[string(t)] {
+			seen[string(t)] = true
+			args = append(args, t)
+		}
+	}
+	n := len(seen)
+	args = append(args, n, owner, limit)
+	rows, err := s.query(`SELECT `+noteColumns+` FROM notes WHERE id IN (
+  SELECT note_id FROM note_terms WHERE owner = ? AND term IN (?`+strings.Repeat(",?", n-1)+`)
+  GROUP BY note_id HAVING COUNT(*) = ?
+) AND owner = ? AND deleted_at IS NULL ORDER BY modified DESC, id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	res := []Note{}
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.loadTags(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
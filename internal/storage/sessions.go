@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+)
+
+const MaxUserAgentLen = 255
+
+// Session records a single login. Its ID is the jti claim of the token
+// issued for that login.
+type Session struct {
+	ID        string
+	Username  string
+	UserAgent string
+	IP        string
+	CreatedAt int64
+	LastSeen  int64
+	ExpiresAt int64
+	RevokedAt int64 // zero while the session is active
+}
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var s Session
+	var revoked sql.NullInt64
+	if err := row.Scan(&s.ID, &s.Username, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeen, &s.ExpiresAt, &revoked); err != nil {
+		return Session{}, err
+	}
+	s.RevokedAt = revoked.Int64
+	return s, nil
+}
+
+// CreateSession stores a new session and drops the user's expired ones.
+func (s *sqlStore) CreateSession(sess Session) error {
+	if err := validateUsername(sess.Username); err != nil {
+		return err
+	}
+	if !isValidUUID(sess.ID) {
+		return errors.New("invalid session id format")
+	}
+	if len(sess.UserAgent) > MaxUserAgentLen {
+		sess.UserAgent = sess.UserAgent[:MaxUserAgentLen]
+	}
+	if _, err := s.exec(`DELETE FROM sessions WHERE username = ? AND expires_at < ?`, sess.Username, sess.CreatedAt); err != nil {
+		return err
+	}
+	_, err := s.exec(`INSERT INTO sessions(id,username,user_agent,ip,created_at,last_seen,expires_at) VALUES (?,?,?,?,?,?,?)`,
+		sess.ID, sess.Username, sess.UserAgent, sess.IP, sess.CreatedAt, sess.LastSeen, sess.ExpiresAt)
+	return err
+}
+
+func (s *sqlStore) GetSession(id string) (Session, error) {
+	if !isValidUUID(id) {
+		return Session{}, errors.New("invalid session id format")
+	}
+	row := s.queryRow(`SELECT id, username, user_agent, ip, created_at, last_seen, expires_at, revoked_at FROM sessions WHERE id = ?`, id)
+	sess, err := scanSession(row)
+	return sess, notFound(err)
+}
+
+// TouchSession bumps last_seen, skipping the write if it was updated less
+// than a minute ago so that every authenticated request doesn't hit the disk.
+func (s *sqlStore) TouchSession(id string, now int64) error {
+	_, err := s.exec(`UPDATE sessions SET last_seen = ? WHERE id = ? AND last_seen < ?`, now, id, now-60)
+	return err
+}
+
+// ListSessions returns the user's sessions that are neither expired nor revoked,
+// most recently used first.
+func (s *sqlStore) ListSessions(username string, now int64) ([]Session, error) {
+	rows, err := s.query(`SELECT id, username, user_agent, ip, created_at, last_seen, expires_at, revoked_at FROM sessions
+WHERE username = ? AND revoked_at IS NULL AND expires_at >= ? ORDER BY last_seen DESC`, username, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sess)
+	}
+	return res, rows.Err()
+}
+
+// RevokeSession marks an active session as revoked. It returns ErrNotFound
+// when the user has no active session with that ID.
+func (s *sqlStore) RevokeSession(id, username string, now int64) error {
+	if !isValidUUID(id) {
+		return errors.New("invalid session id format")
+	}
+	return s.execOne(`UPDATE sessions SET revoked_at = ? WHERE id = ? AND username = ? AND revoked_at IS NULL`, now, id, username)
+}
+
+// RevokeOtherSessions revokes every active session of the user except keepID.
+func (s *sqlStore) RevokeOtherSessions(username, keepID string, now int64) error {
+	_, err := s.exec(`UPDATE sessions SET revoked_at = ? WHERE username = ? AND id != ? AND revoked_at IS NULL`, now, username, keepID)
+	return err
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		name := newUser(t, s)
+		sess := Session{ID: uuid.New().String(), Username: name, CreatedAt: 1000, LastSeen: 1000, ExpiresAt: 5000}
+		if err := s.CreateSession(sess); err != nil {
+			t.Fatal(err)
+		}
+		other := sess
+		other.ID = uuid.New().String()
+		if err := s.CreateSession(other); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.GetSession(sess.ID); err != nil || got != sess {
+			t.Errorf("GetSession = %+v, %v", got, err)
+		}
+		if list, err := s.ListSessions(name, 2000); err != nil || len(list) != 2 {
+			t.Errorf("ListSessions listed %d sessions (%v), want 2", len(list), err)
+		}
+		if list, _ := s.ListSessions(name, 6000); len(list) != 0 {
+			t.Errorf("ListSessions listed %d expired sessions", len(list))
+		}
+
+		if err := s.RevokeSession(sess.ID, "test_missing", 2000); err != ErrNotFound {
+			t.Errorf("RevokeSession of another user's session: %v, want ErrNotFound", err)
+		}
+		if err := s.RevokeSession(sess.ID, name, 2000); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RevokeSession(sess.ID, name, 2000); err != ErrNotFound {
+			t.Errorf("RevokeSession of a revoked session: %v, want ErrNotFound", err)
+		}
+		if got, _ := s.GetSession(sess.ID); got.RevokedAt != 2000 {
+			t.Errorf("RevokedAt = %d, want 2000", got.RevokedAt)
+		}
+		if list, _ := s.ListSessions(name, 2000); len(list) != 1 || list[0].ID != other.ID {
+			t.Errorf("ListSessions after revoking = %+v", list)
+		}
+		if err := s.RevokeUserSessions(name, 3000); err != nil {
+			t.Fatal(err)
+		}
+		if list, _ := s.ListSessions(name, 3000); len(list) != 0 {
+			t.Errorf("ListSessions listed %d sessions after RevokeUserSessions", len(list))
+		}
+	})
+}
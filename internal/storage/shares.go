@@ -0,0 +1,218 @@
+package storage
+
+import "errors"
+
+// KeyPair is the X25519 keypair of a user, through which notes are shared
+// with them: the private key is wrapped under the user's key.
+type KeyPair struct {
+	Username     string
+	PublicKey    []byte
+	PrivateKey   []byte
+	PrivateNonce []byte
+	Created      int64
+}
+
+// Share permissions.
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+)
+
+// Share lets a user other than its owner read, or read and write, a note.
+// Key is the note's key sealed to the recipient's public key.
+type Share struct {
+	NoteID     string
+	Owner      string
+	Recipient  string
+	Key        []byte
+	Permission string // PermRead or PermWrite
+	Created    int64
+}
+
+// SharedNote is a note shared with a user, with the share.
+type SharedNote struct {
+	Note  Note
+	Share Share
+}
+
+func validateShare(sh Share) error {
+	if err := validateUsername(sh.Owner); err != nil {
+		return err
+	}
+	if err := validateUsername(sh.Recipient); err != nil {
+		return err
+	}
+	if sh.Owner == sh.Recipient {
+		return errors.New("a note can't be shared with its owner")
+	}
+	if !isValidUUID(sh.NoteID) {
+		return errors.New("invalid note id")
+	}
+	if len(sh.Key) == 0 {
+		return errors.New("missing note key")
+	}
+	if sh.Permission != PermRead && sh.Permission != PermWrite {
+		return errors.New("invalid permission")
+	}
+	return nil
+}
+
+func (s *sqlStore) GetKeyPair(username string) (KeyPair, error) {
+	kp := KeyPair{Username: username}
+	err := s.queryRow(`SELECT public_key, private_key, private_nonce, created FROM user_keys WHERE username = ?`, username).
+		Scan(&kp.PublicKey, &kp.PrivateKey, &kp.PrivateNonce, &kp.Created)
+	if err != nil {
+		return KeyPair{}, notFound(err)
+	}
+	return kp, nil
+}
+
+// CreateKeyPair stores the keypair of a user, failing with ErrConflict when
+// they already have one.
+func (s *sqlStore) CreateKeyPair(kp KeyPair) error {
+	if err := validateUsername(kp.Username); err != nil {
+		return err
+	}
+	if len(kp.PublicKey) == 0 || len(kp.PrivateKey) == 0 || len(kp.PrivateNonce) == 0 {
+		return errors.New("missing key")
+	}
+	res, err := s.exec(`INSERT INTO user_keys(username, public_key, private_key, private_nonce, created) VALUES(?,?,?,?,?) ON CONFLICT(username) DO NOTHING`,
+		kp.Username, kp.PublicKey, kp.PrivateKey, kp.PrivateNonce, kp.Created)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// SetNoteKey gives a note a key of its own: n has the key, wrapped under
+// the owner's key, and the content and title encrypted under it, and revs
+// the revisions of the note encrypted under it. It fails with ErrConflict
+// when the note is no longer at n.Revision or already has a key.
+func (s *sqlStore) SetNoteKey(n Note, revs []NoteRevision) error {
+	if err := validateNote(n); err != nil {
+		return err
+	}
+	if len(n.Key) == 0 || len(n.KeyNonce) == 0 {
+		return errors.New("missing note key")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.q(`UPDATE notes SET content = ?, nonce = ?, title = ?, title_nonce = ?, note_key = ?, note_key_nonce = ?
+WHERE id = ? AND owner = ? AND revision = ? AND deleted_at IS NULL AND note_key IS NULL`),
+		n.Content, n.Nonce, n.Title, n.TitleNonce, n.Key, n.KeyNonce, n.ID, n.Owner, n.Revision)
+	if err != nil {
+		return err
+	}
+	if k, err := res.RowsAffected(); err != nil {
+		return err
+	} else if k == 0 {
+		return s.noteConflict(tx, n.ID, n.Owner)
+	}
+	// revisions pruned meanwhile are simply gone; none can be added without
+	// changing the revision of the note
+	for _, rev := range revs {
+		_, err := tx.Exec(s.q(`UPDATE note_revisions SET content = ?, nonce = ?, title = ?, title_nonce = ? WHERE note_id = ? AND owner = ? AND revision = ?`),
+			rev.Content, rev.Nonce, rev.Title, rev.TitleNonce, n.ID, n.Owner, rev.Revision)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ShareNote shares a note of its owner, which must not be in the trash, or
+// changes how it is shared.
+func (s *sqlStore) ShareNote(sh Share) error {
+	if err := validateShare(sh); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var one int
+	err = tx.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ? AND deleted_at IS NULL AND note_key IS NOT NULL`), sh.NoteID, sh.Owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO note_shares(note_id, owner, recipient, note_key, permission, created) VALUES(?,?,?,?,?,?)
+ON CONFLICT(note_id, recipient) DO UPDATE SET note_key = excluded.note_key, permission = excluded.permission`),
+		sh.NoteID, sh.Owner, sh.Recipient, sh.Key, sh.Permission, sh.Created)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const shareColumns = `note_id, owner, recipient, note_key, permission, created`
+
+func scanShare(row interface{ Scan(...any) error }) (Share, error) {
+	var sh Share
+	err := row.Scan(&sh.NoteID, &sh.Owner, &sh.Recipient, &sh.Key, &sh.Permission, &sh.Created)
+	return sh, err
+}
+
+func (s *sqlStore) GetShare(noteID, recipient string) (Share, error) {
+	sh, err := scanShare(s.queryRow(`SELECT `+shareColumns+` FROM note_shares WHERE note_id = ? AND recipient = ?`, noteID, recipient))
+	if err != nil {
+		return Share{}, notFound(err)
+	}
+	return sh, nil
+}
+
+// ListShares returns the shares of a note, by recipient.
+func (s *sqlStore) ListShares(noteID string) ([]Share, error) {
+	rows, err := s.query(`SELECT `+shareColumns+` FROM note_shares WHERE note_id = ? ORDER BY recipient`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Share{}
+	for rows.Next() {
+		sh, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sh)
+	}
+	return res, rows.Err()
+}
+
+func (s *sqlStore) DeleteShare(noteID, recipient string) error {
+	return s.execOne(`DELETE FROM note_shares WHERE note_id = ? AND recipient = ?`, noteID, recipient)
+}
+
+// ListSharedNotes returns the notes shared with recipient that aren't in
+// the trash, last modified first. Their notebooks and tags are left out,
+// being their owners'.
+func (s *sqlStore) ListSharedNotes(recipient string) ([]SharedNote, error) {
+	rows, err := s.query(`SELECT n.id, n.owner, n.content, n.nonce, n.created, n.modified, n.revision, n.title, n.title_nonce, n.note_key, n.note_key_nonce,
+  sh.note_id, sh.owner, sh.recipient, sh.note_key, sh.permission, sh.created
+FROM note_shares sh JOIN notes n ON n.id = sh.note_id AND n.owner = sh.owner
+WHERE sh.recipient = ? AND n.deleted_at IS NULL ORDER BY n.modified DESC, n.id DESC`, recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []SharedNote{}
+	for rows.Next() {
+		var sn SharedNote
+		n, sh := &sn.Note, &sn.Share
+		err := rows.Scan(&n.ID, &n.Owner, &n.Content, &n.Nonce, &n.Created, &n.Modified, &n.Revision, &n.Title, &n.TitleNonce, &n.Key, &n.KeyNonce,
+			&sh.NoteID, &sh.Owner, &sh.Recipient, &sh.Key, &sh.Permission, &sh.Created)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sn)
+	}
+	return res, rows.Err()
+}
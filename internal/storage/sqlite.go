@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is the default Store, backed by a single SQLite file.
+//
+// Writes go through a single connection since SQLite allows one writer at a
+// time; reads use a separate pool of query-only connections, which WAL mode
+// lets run alongside the writer.
+type SQLiteStore struct {
+	sqlStore
+	path string
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path.
+func OpenSQLite(path string, opts Options) (*SQLiteStore, error) {
+	if opts.BusyTimeout == 0 {
+		opts.BusyTimeout = 5 * time.Second
+	}
+	switch opts.Synchronous = strings.ToUpper(opts.Synchronous); opts.Synchronous {
+	case "":
+		opts.Synchronous = "FULL"
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return nil, fmt.Errorf("invalid synchronous mode %q", opts.Synchronous)
+	}
+
+	// pragmas are given in the DSN so that they apply to every connection of
+	// the pool, not just the first
+	pragmas := url.Values{"_pragma": {
+		fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()),
+		"foreign_keys(1)",
+		"synchronous(" + opts.Synchronous + ")",
+	}}
+	if opts.NoAutoCheckpoint {
+		pragmas.Add("_pragma", "wal_autocheckpoint(0)")
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	// take the write lock up front so that a transaction that reads before
+	// writing can't fail with SQLITE_BUSY when upgrading its lock
+	db, err := sql.Open("sqlite", path+sep+pragmas.Encode()+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStore{sqlStore: sqlStore{db: db, dialect: dialectSQLite}, path: path}
+	// journal_mode is stored in the file and must be set outside a transaction
+	if _, err := db.Exec(`PRAGMA journal_mode = WAL;`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// an in-memory database is private to its connection, so it can't be shared
+	if opts.ReadConns > 0 && path != ":memory:" && !strings.Contains(path, "mode=memory") {
+		pragmas.Add("_pragma", "query_only(1)")
+		rdb, err := sql.Open("sqlite", path+sep+pragmas.Encode())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		rdb.SetMaxOpenConns(opts.ReadConns)
+		rdb.SetMaxIdleConns(opts.ReadConns)
+		s.rdb = rdb
+	}
+	return s, nil
+}
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist, while the database stays online. Unlike copying the file it can't
+// pick up a half-written WAL.
+func (s *SQLiteStore) Snapshot(path string) error {
+	_, err := s.db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// Path returns the path of the database file. The WAL is Path()+"-wal".
+func (s *SQLiteStore) Path() string {
+	p, _, _ := strings.Cut(s.path, "?")
+	return strings.TrimPrefix(p, "file:")
+}
+
+// LockWrites runs fn on the writer connection. As there is only one, no
+// other statement of the store can write until fn returns.
+func (s *SQLiteStore) LockWrites(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// IntegrityCheck runs SQLite's integrity_check and returns the problems it
+// reports, if any.
+func (s *SQLiteStore) IntegrityCheck() ([]string, error) {
+	rows, err := s.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	return problems, rows.Err()
+}
@@ -3,18 +3,161 @@ package storage
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"regexp"
-
-	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var db *sql.DB
-
 const (
 	MaxNoteContentSize = 1 << 20 // 1 MiB
 	MaxUsernameLen     = 255
+	MaxTitleSize       = 1024 // of a note title, in bytes
+	MaxNameSize        = 256  // of a notebook or tag name, in bytes
+	MaxNoteTags        = 100
 )
 
+// gcmOverhead is what AES-GCM adds to the size of what it encrypts.
+const gcmOverhead = 16
+
+// ErrNotFound is returned when the requested row doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a note has moved past the revision a change
+// was based on.
+var ErrConflict = errors.New("note was changed since the given revision")
+
+// Store is the persistence layer used by the HTTP handlers.
+type Store interface {
+	// users and their wrapped keys
+	CreateUser(u User) error
+	CreateUserWithInvite(u User, inviteID string, now int64) error
+	GetUser(username string) (User, error)
+	SetPasswordHash(username, hash string) error
+	SaveWrappedKey(username string, wrapped, nonce []byte) error
+	SetUserRole(username, role string) error
+	SetUserDisabled(username string, disabled bool) error
+	DeleteUser(username string) error
+	ListUserSummaries(now int64) ([]UserSummary, error)
+
+	// notes; see Note.Revision for UpdateNote and TrashNote
+	SaveNote(n Note) error
+	UpdateNote(n Note) (revision int64, err error)
+	ListNotes(q NoteQuery) (NotePage, error)
+	GetNoteByID(id string) (Note, error)
+
+	// the trash; see Note.Deleted
+	TrashNote(id, owner string, revision, now int64) error
+	RestoreNote(id, owner string) error
+	DeleteNote(id, owner string) error
+	EmptyTrash(owner string) (int64, error)
+	PurgeTrash(before int64) (int64, error)
+
+	// notebooks and tags, named like notes are written: encrypted
+	CreateNotebook(nb Notebook) error
+	GetNotebook(id string) (Notebook, error)
+	ListNotebooks(owner string) ([]Notebook, error)
+	UpdateNotebook(nb Notebook) error
+	DeleteNotebook(id, owner string) error
+	CreateTag(t Tag) error
+	ListTags(owner string) ([]Tag, error)
+	RenameTag(t Tag) error
+	DeleteTag(id, owner string) error
+
+	// files attached to notes, and the references to their blobs, which
+	// are kept elsewhere
+	CreateAttachment(a Attachment) error
+	GetAttachment(id string) (Attachment, error)
+	ListAttachments(noteID string) ([]Attachment, error)
+	DeleteAttachment(id, owner string) error
+	GetBlob(key string) (Blob, error)
+	ForgetBlob(key string) error
+
+	// sharing notes with other users
+	GetKeyPair(username string) (KeyPair, error)
+	CreateKeyPair(kp KeyPair) error
+	SetNoteKey(n Note, revs []NoteRevision) error
+	ShareNote(sh Share) error
+	GetShare(noteID, recipient string) (Share, error)
+	ListShares(noteID string) ([]Share, error)
+	DeleteShare(noteID, recipient string) error
+	ListSharedNotes(recipient string) ([]SharedNote, error)
+
+	// public links to snapshots of notes
+	CreateLink(l Link) error
+	ListLinks(noteID string, now int64) ([]Link, error)
+	DeleteLink(id, noteID, owner string) error
+	ViewLink(id string, now int64) (Link, error)
+	PurgeLinks(now int64) (int64, error)
+
+	// the search index; see Note.Terms
+	SearchNotes(owner string, terms [][]byte, limit int) ([]Note, error)
+	SetNoteTerms(id, owner string, terms [][]byte) error
+
+	// earlier revisions of notes, kept by UpdateNote
+	ListNoteRevisions(noteID, owner string) ([]NoteRevision, error)
+	GetNoteRevision(noteID, owner string, revision int64) (NoteRevision, error)
+	PruneNoteRevisions(owner, noteID string, r RevisionRetention, now int64) error
+	GetRevisionRetention(username string) (RevisionRetention, error)
+	SetRevisionRetention(username string, r RevisionRetention) error
+
+	// sessions
+	CreateSession(s Session) error
+	GetSession(id string) (Session, error)
+	TouchSession(id string, now int64) error
+	ListSessions(username string, now int64) ([]Session, error)
+	RevokeSession(id, username string, now int64) error
+	RevokeOtherSessions(username, keepID string, now int64) error
+	RevokeUserSessions(username string, now int64) error
+
+	// invitations
+	CreateInvite(inv Invite) error
+	ListInvites() ([]Invite, error)
+	DeleteInvite(id string) error
+
+	Close() error
+}
+
+// Snapshotter is implemented by stores that can take an online backup.
+type Snapshotter interface {
+	// Snapshot writes a consistent copy of the database to the file at
+	// path, which must not exist.
+	Snapshot(path string) error
+}
+
+// Options tunes the connections of the SQL stores. Zero values select the
+// defaults.
+type Options struct {
+	// BusyTimeout is how long SQLite waits for a lock before failing.
+	BusyTimeout time.Duration
+	// Synchronous is SQLite's synchronous pragma: OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	// ReadConns sizes the read-only pool. Reads share the write pool when it
+	// is zero.
+	ReadConns int
+	// WriteConns sizes the write pool. SQLite allows a single writer, so it
+	// is ignored there.
+	WriteConns int
+	// NoAutoCheckpoint stops SQLite from checkpointing the WAL on its own,
+	// for when a replicator does it after shipping the frames.
+	NoAutoCheckpoint bool
+}
+
+// Open returns the store for driver ("sqlite", "postgres" or "memory"). SQL
+// stores come up with whatever schema the database has; see Migrator.
+func Open(driver, dsn string, opts Options) (Store, error) {
+	switch driver {
+	case "", "sqlite":
+		return OpenSQLite(dsn, opts)
+	case "postgres":
+		return OpenPostgres(dsn, opts)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
+
 var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{4,255}$`)
 
 func isValidUUID(s string) bool {
@@ -47,205 +190,570 @@ func validateUsername(u string) error {
 	return nil
 }
 
-func Init(path string) error {
-	var err error
-	db, err = sql.Open("sqlite", path)
-	if err != nil {
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+type User struct {
+	Username     string
+	PasswordHash string
+	WrappedKey   []byte
+	WrappedNonce []byte
+	CreatedAt    int64
+	Role         string
+	Disabled     bool
+}
+
+type Note struct {
+	ID       string
+	Owner    string
+	Content  []byte
+	Nonce    []byte
+	Created  int64
+	Modified int64
+	// Revision starts at 1 and goes up with every update. UpdateNote and
+	// TrashNote only act on a note still at the revision they are given,
+	// and fail with ErrConflict otherwise; revision 0 skips the check.
+	Revision int64
+	// Deleted is when the note was moved to the trash, 0 while it isn't.
+	// Notes in the trash can't be updated and are only listed with
+	// NoteQuery.Trashed; DeleteNote removes them for good.
+	Deleted int64
+	// Title is encrypted like Content, with a nonce of its own; both are
+	// empty when the note has no title.
+	Title      []byte
+	TitleNonce []byte
+	Notebook   string   // id of the notebook holding the note, "" for none
+	Tags       []string // ids of the note's tags, in no particular order
+	// Key is the note's own key, wrapped under its owner's key, for notes
+	// that have been shared; the title and content of the others, and of
+	// their revisions, are encrypted under the owner's key. SetNoteKey
+	// sets it.
+	Key      []byte
+	KeyNonce []byte
+	// Terms are the note's entries in the search index, which SaveNote and
+	// UpdateNote replace with them unless they are nil. Notes read back
+	// don't have them.
+	Terms [][]byte
+	// FTS is the same for the full-text index of SQLite stores.
+	FTS *FTSDoc
+}
+
+// Orders of the notes list.
+const (
+	SortCreated  = "created"
+	SortModified = "modified"
+	SortDeleted  = "deleted" // the trash only
+)
+
+// MaxNotesPage caps NoteQuery.Limit.
+const MaxNotesPage = 200
+
+// NoteQuery selects a page of a user's notes. Time bounds are Unix times
+// and exclusive; zero leaves them open.
+type NoteQuery struct {
+	Owner  string
+	SortBy string // SortCreated (the default), SortModified or SortDeleted
+	Asc    bool   // oldest first rather than newest first
+	// Trashed lists the notes in the trash instead of the others.
+	Trashed bool
+	// Notebook keeps the notes directly in that notebook, and Tags those
+	// with all of these tags.
+	Notebook string
+	Tags     []string
+
+	CreatedAfter, CreatedBefore   int64
+	ModifiedAfter, ModifiedBefore int64
+
+	After *NoteCursor // continue after this position; nil starts at the top
+	Limit int
+}
+
+// NoteCursor is a position in the notes list: the sort time and id of the
+// last note returned.
+type NoteCursor struct {
+	Time int64
+	ID   string
+}
+
+// NotePage is one page of the notes list.
+type NotePage struct {
+	Notes []Note
+	Total int         // notes matching the filters, on all pages
+	Next  *NoteCursor // nil on the last page
+}
+
+func validateNoteQuery(q *NoteQuery) error {
+	if err := validateUsername(q.Owner); err != nil {
 		return err
 	}
-	db.SetMaxOpenConns(1)
-	// enable foreign keys and set WAL journal mode (journal_mode must be set outside a transaction)
-	if _, err = db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
-		return err
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortCreated
+	case SortCreated, SortModified:
+	case SortDeleted:
+		if !q.Trashed {
+			return errors.New("only the trash can be sorted by deletion time")
+		}
+	default:
+		return errors.New("invalid sort order")
+	}
+	if q.Limit <= 0 || q.Limit > MaxNotesPage {
+		return fmt.Errorf("limit must be between 1 and %d", MaxNotesPage)
+	}
+	if q.Notebook != "" && !isValidUUID(q.Notebook) {
+		return errors.New("invalid notebook id")
+	}
+	if len(q.Tags) > MaxNoteTags {
+		return errors.New("too many tags")
+	}
+	for _, t := range q.Tags {
+		if !isValidUUID(t) {
+			return errors.New("invalid tag id")
+		}
+	}
+	return nil
+}
+
+// sortTime returns n's time in the order of q.
+func (q NoteQuery) sortTime(n Note) int64 {
+	switch q.SortBy {
+	case SortModified:
+		return n.Modified
+	case SortDeleted:
+		return n.Deleted
+	}
+	return n.Created
+}
+
+// sortColumn returns the column of q.SortBy.
+func (q NoteQuery) sortColumn() string {
+	if q.SortBy == SortDeleted {
+		return "deleted_at"
+	}
+	return q.SortBy
+}
+
+// page trims notes, sorted and fetched with one extra, to a page.
+func (q NoteQuery) page(notes []Note, total int) NotePage {
+	p := NotePage{Notes: notes, Total: total}
+	if len(notes) > q.Limit {
+		p.Notes = notes[:q.Limit]
+		last := p.Notes[q.Limit-1]
+		p.Next = &NoteCursor{Time: q.sortTime(last), ID: last.ID}
+	}
+	if p.Notes == nil {
+		// an empty slice rather than nil so that JSON encoding yields []
+		p.Notes = []Note{}
+	}
+	return p
+}
+
+func validateNote(n Note) error {
+	if n.Owner == "" {
+		return errors.New("note owner required")
 	}
-	if _, err = db.Exec(`PRAGMA journal_mode = WAL;`); err != nil {
+	if err := validateUsername(n.Owner); err != nil {
 		return err
 	}
+	if !isValidUUID(n.ID) {
+		return errors.New("invalid note id format")
+	}
+	if len(n.Content) > MaxNoteContentSize {
+		return errors.New("note content too large")
+	}
+	if len(n.Nonce) == 0 {
+		return errors.New("missing nonce")
+	}
+	if len(n.Title) > MaxTitleSize+gcmOverhead {
+		return errors.New("note title too long")
+	}
+	if (len(n.Title) == 0) != (len(n.TitleNonce) == 0) {
+		return errors.New("title and title nonce go together")
+	}
+	if n.Notebook != "" && !isValidUUID(n.Notebook) {
+		return errors.New("invalid notebook id")
+	}
+	if len(n.Tags) > MaxNoteTags {
+		return errors.New("too many tags")
+	}
+	seen := map[string]bool{}
+	for _, t := range n.Tags {
+		if !isValidUUID(t) {
+			return errors.New("invalid tag id")
+		}
+		if seen[t] {
+			return errors.New("duplicate tag")
+		}
+		seen[t] = true
+	}
+	return validateTerms(n.Terms)
+}
+
+// nullIfEmpty stores "" as NULL.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+// sqlStore implements Store over database/sql. Queries are written with ?
+// placeholders and rewritten for drivers that number them.
+type sqlStore struct {
+	db      *sql.DB
+	rdb     *sql.DB // read-only pool; nil to read through db
+	dialect string  // also names the migrations directory
+}
+
+// q adapts a query's placeholders to the driver.
+func (s *sqlStore) q(query string) string {
+	if s.dialect != dialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.db.Exec(s.q(query), args...)
+}
+
+// reader returns the pool for queries outside a transaction.
+func (s *sqlStore) reader() *sql.DB {
+	if s.rdb != nil {
+		return s.rdb
+	}
+	return s.db
+}
+
+func (s *sqlStore) queryRow(query string, args ...any) *sql.Row {
+	return s.reader().QueryRow(s.q(query), args...)
+}
+
+func (s *sqlStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.reader().Query(s.q(query), args...)
+}
 
-	// create schema
-	_, err = db.Exec(`
-CREATE TABLE IF NOT EXISTS users (
-  username TEXT PRIMARY KEY,
-  password_hash TEXT NOT NULL,
-  wrapped_key BLOB,
-  wrapped_nonce BLOB,
-  created_at INTEGER NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS notes (
-  id TEXT PRIMARY KEY,
-  owner TEXT NOT NULL,
-  content BLOB NOT NULL,
-  nonce BLOB NOT NULL,
-  created INTEGER NOT NULL,
-  modified INTEGER NOT NULL,
-  FOREIGN KEY(owner) REFERENCES users(username)
-);
-
-CREATE INDEX IF NOT EXISTS idx_notes_owner ON notes(owner);
-
-`)
+// execOne runs a statement that must affect exactly one row, returning
+// ErrNotFound when it affects none.
+func (s *sqlStore) execOne(query string, args ...any) error {
+	res, err := s.exec(query, args...)
 	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
-func Close() error {
-	if db == nil {
-		return nil
+// notFound maps sql.ErrNoRows to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
 	}
-	return db.Close()
+	return err
 }
 
-type User struct {
-	Username     string
-	PasswordHash string
-	WrappedKey   []byte
-	WrappedNonce []byte
-	CreatedAt    int64
+func (s *sqlStore) Close() error {
+	if s.rdb != nil {
+		s.rdb.Close()
+	}
+	return s.db.Close()
 }
 
-func CreateUser(u User) error {
-	if db == nil {
-		return errors.New("DB not initialized")
-	}
+func (s *sqlStore) CreateUser(u User) error {
 	if err := validateUsername(u.Username); err != nil {
 		return err
 	}
-	_, err := db.Exec(`INSERT INTO users(username,password_hash,wrapped_key,wrapped_nonce,created_at) VALUES (?,?,?,?,?)`, u.Username, u.PasswordHash, u.WrappedKey, u.WrappedNonce, u.CreatedAt)
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+	_, err := s.exec(`INSERT INTO users(username,password_hash,wrapped_key,wrapped_nonce,created_at,role,disabled) VALUES (?,?,?,?,?,?,?)`,
+		u.Username, u.PasswordHash, u.WrappedKey, u.WrappedNonce, u.CreatedAt, u.Role, u.Disabled)
 	return err
 }
 
-func GetUser(username string) (User, error) {
+func (s *sqlStore) GetUser(username string) (User, error) {
 	var u User
-	if db == nil {
-		return User{}, errors.New("DB not initialized")
-	}
 	if err := validateUsername(username); err != nil {
 		return User{}, err
 	}
-	row := db.QueryRow(`SELECT username, password_hash, wrapped_key, wrapped_nonce, created_at FROM users WHERE username = ?`, username)
+	row := s.queryRow(`SELECT username, password_hash, wrapped_key, wrapped_nonce, created_at, role, disabled FROM users WHERE username = ?`, username)
 	var wk, wn []byte
-	if err := row.Scan(&u.Username, &u.PasswordHash, &wk, &wn, &u.CreatedAt); err != nil {
-		return User{}, err
+	if err := row.Scan(&u.Username, &u.PasswordHash, &wk, &wn, &u.CreatedAt, &u.Role, &u.Disabled); err != nil {
+		return User{}, notFound(err)
 	}
 	u.WrappedKey = wk
 	u.WrappedNonce = wn
 	return u, nil
 }
 
-func SaveWrappedKey(username string, wrapped, nonce []byte) error {
-	if db == nil {
-		return errors.New("DB not initialized")
-	}
+func (s *sqlStore) SetPasswordHash(username, hash string) error {
 	if err := validateUsername(username); err != nil {
 		return err
 	}
-	_, err := db.Exec(`UPDATE users SET wrapped_key = ?, wrapped_nonce = ? WHERE username = ?`, wrapped, nonce, username)
-	return err
+	return s.execOne(`UPDATE users SET password_hash = ? WHERE username = ?`, hash, username)
 }
 
-type Note struct {
-	ID       string
-	Owner    string
-	Content  []byte
-	Nonce    []byte
-	Created  int64
-	Modified int64
+func (s *sqlStore) SaveWrappedKey(username string, wrapped, nonce []byte) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	return s.execOne(`UPDATE users SET wrapped_key = ?, wrapped_nonce = ? WHERE username = ?`, wrapped, nonce, username)
 }
 
-func SaveNote(n Note) error {
-	if db == nil {
-		return errors.New("db not initialized")
+func (s *sqlStore) SaveNote(n Note) error {
+	if err := validateNote(n); err != nil {
+		return err
 	}
-	if n.Owner == "" {
-		return errors.New("note owner required")
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
-	if err := validateUsername(n.Owner); err != nil {
+	defer tx.Rollback()
+	_, err = tx.Exec(s.q(`INSERT INTO notes(id,owner,content,nonce,created,modified,revision,title,title_nonce,notebook_id) VALUES(?,?,?,?,?,?,1,?,?,?)`),
+		n.ID, n.Owner, n.Content, n.Nonce, n.Created, n.Modified, n.Title, n.TitleNonce, nullIfEmpty(n.Notebook))
+	if err != nil {
 		return err
 	}
-	if !isValidUUID(n.ID) {
-		return errors.New("invalid note id format")
+	if err := s.setNoteTags(tx, n.ID, n.Tags); err != nil {
+		return err
 	}
-	if len(n.Content) > MaxNoteContentSize {
-		return errors.New("note content too large")
+	if err := s.setNoteTerms(tx, n.Owner, n.ID, n.Terms); err != nil {
+		return err
 	}
-	if len(n.Nonce) == 0 {
-		return errors.New("missing nonce")
+	if n.FTS != nil {
+		if err := s.setNoteFTS(tx, n.Owner, n.ID, *n.FTS); err != nil {
+			return err
+		}
 	}
-	_, err := db.Exec(`INSERT INTO notes(id,owner,content,nonce,created,modified) VALUES(?,?,?,?,?,?)`,
-		n.ID, n.Owner, n.Content, n.Nonce, n.Created, n.Modified)
-	return err
+	return tx.Commit()
 }
 
-func UpdateNote(n Note) error {
-	if db == nil {
-		return errors.New("db not initialized")
+// setNoteTags replaces the tags of a note.
+func (s *sqlStore) setNoteTags(tx *sql.Tx, noteID string, tags []string) error {
+	if _, err := tx.Exec(s.q(`DELETE FROM note_tags WHERE note_id = ?`), noteID); err != nil {
+		return err
 	}
-	if n.Owner == "" {
-		return errors.New("note owner required")
+	for _, t := range tags {
+		if _, err := tx.Exec(s.q(`INSERT INTO note_tags(note_id, tag_id) VALUES(?,?)`), noteID, t); err != nil {
+			return err
+		}
 	}
-	if !isValidUUID(n.ID) {
-		return errors.New("invalid note id format")
+	return nil
+}
+
+// loadTags fills in the tags of notes.
+func (s *sqlStore) loadTags(notes []Note) error {
+	if len(notes) == 0 {
+		return nil
 	}
-	if len(n.Content) > MaxNoteContentSize {
-		return errors.New("note content too large")
+	byID := map[string]*Note{}
+	args := make([]any, len(notes))
+	for i := range notes {
+		byID[notes[i].ID] = &notes[i]
+		args[i] = notes[i].ID
 	}
-	if len(n.Nonce) == 0 {
-		return errors.New("missing nonce")
+	// tags deleted while being given to a note are left out by the join
+	rows, err := s.query(`SELECT nt.note_id, nt.tag_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id WHERE nt.note_id IN (?`+
+		strings.Repeat(",?", len(notes)-1)+`) ORDER BY nt.note_id, nt.tag_id`, args...)
+	if err != nil {
+		return err
 	}
-	_, err := db.Exec(`UPDATE notes SET content = ?, nonce = ?, modified = ? WHERE id = ? AND owner = ?`,
-		n.Content, n.Nonce, n.Modified, n.ID, n.Owner)
-	return err
+	defer rows.Close()
+	for rows.Next() {
+		var noteID, tagID string
+		if err := rows.Scan(&noteID, &tagID); err != nil {
+			return err
+		}
+		n := byID[noteID]
+		n.Tags = append(n.Tags, tagID)
+	}
+	return rows.Err()
 }
 
-func DeleteNote(id, owner string) error {
-	if db == nil {
-		return errors.New("db not initialized")
+// UpdateNote replaces the content, title, notebook and tags of a note,
+// keeping the content and title it had in note_revisions.
+func (s *sqlStore) UpdateNote(n Note) (int64, error) {
+	if err := validateNote(n); err != nil {
+		return 0, err
 	}
-	_, err := db.Exec(`DELETE FROM notes WHERE id = ? AND owner = ?`, id, owner)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var old NoteRevision
+	err = tx.QueryRow(s.q(`SELECT content, nonce, title, title_nonce, modified, revision FROM notes WHERE id = ? AND owner = ? AND deleted_at IS NULL`), n.ID, n.Owner).
+		Scan(&old.Content, &old.Nonce, &old.Title, &old.TitleNonce, &old.Modified, &old.Revision)
+	if err != nil {
+		return 0, notFound(err)
+	}
+	if n.Revision != 0 && n.Revision != old.Revision {
+		return 0, ErrConflict
+	}
+	// conditional on what was read, as PostgreSQL lets another update in
+	// between; updating first also locks the row before the insert below
+	res, err := tx.Exec(s.q(`UPDATE notes SET content = ?, nonce = ?, title = ?, title_nonce = ?, notebook_id = ?, modified = ?, revision = revision + 1 WHERE id = ? AND revision = ?`),
+		n.Content, n.Nonce, n.Title, n.TitleNonce, nullIfEmpty(n.Notebook), n.Modified, n.ID, old.Revision)
+	if err != nil {
+		return 0, err
+	}
+	if k, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if k == 0 {
+		return 0, ErrConflict
+	}
+	_, err = tx.Exec(s.q(`INSERT INTO note_revisions(note_id, revision, owner, content, nonce, title, title_nonce, modified, archived) VALUES(?,?,?,?,?,?,?,?,?)`),
+		n.ID, old.Revision, n.Owner, old.Content, old.Nonce, old.Title, old.TitleNonce, old.Modified, n.Modified)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.setNoteTags(tx, n.ID, n.Tags); err != nil {
+		return 0, err
+	}
+	if n.Terms != nil {
+		if err := s.setNoteTerms(tx, n.Owner, n.ID, n.Terms); err != nil {
+			return 0, err
+		}
+	}
+	if n.FTS != nil {
+		if err := s.setNoteFTS(tx, n.Owner, n.ID, *n.FTS); err != nil {
+			return 0, err
+		}
+	}
+	return old.Revision + 1, tx.Commit()
 }
 
-func GetNotesByOwner(owner string) ([]Note, error) {
-	if db == nil {
-		return nil, errors.New("db not initialized")
+// rowQuerier is a *sql.DB or *sql.Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// noteConflict tells why a conditional change of a note matched no row:
+// ErrConflict when the note exists outside the trash, ErrNotFound when it
+// doesn't.
+func (s *sqlStore) noteConflict(db rowQuerier, id, owner string) error {
+	var one int
+	err := db.QueryRow(s.q(`SELECT 1 FROM notes WHERE id = ? AND owner = ? AND deleted_at IS NULL`), id, owner).Scan(&one)
+	if err != nil {
+		return notFound(err)
+	}
+	return ErrConflict
+}
+
+func (s *sqlStore) ListNotes(q NoteQuery) (NotePage, error) {
+	if err := validateNoteQuery(&q); err != nil {
+		return NotePage{}, err
+	}
+	where := ` WHERE owner = ? AND deleted_at IS NULL`
+	if q.Trashed {
+		where = ` WHERE owner = ? AND deleted_at IS NOT NULL`
+	}
+	args := []any{q.Owner}
+	if q.Notebook != "" {
+		where += ` AND notebook_id = ?`
+		args = append(args, q.Notebook)
+	}
+	for _, t := range q.Tags {
+		where += ` AND id IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`
+		args = append(args, t)
+	}
+	for _, b := range []struct {
+		cond string
+		v    int64
+	}{
+		{"created > ?", q.CreatedAfter},
+		{"created < ?", q.CreatedBefore},
+		{"modified > ?", q.ModifiedAfter},
+		{"modified < ?", q.ModifiedBefore},
+	} {
+		if b.v != 0 {
+			where += " AND " + b.cond
+			args = append(args, b.v)
+		}
 	}
-	rows, err := db.Query(`SELECT id, owner, content, nonce, created, modified FROM notes WHERE owner = ?`, owner)
+
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM notes`+where, args...).Scan(&total); err != nil {
+		return NotePage{}, err
+	}
+
+	cmp, dir := "<", "DESC"
+	if q.Asc {
+		cmp, dir = ">", "ASC"
+	}
+	if q.After != nil {
+		where += fmt.Sprintf(" AND (%s, id) %s (?, ?)", q.sortColumn(), cmp)
+		args = append(args, q.After.Time, q.After.ID)
+	}
+	// the sort column is one of three, checked above
+	rows, err := s.query(`SELECT `+noteColumns+` FROM notes`+where+
+		fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", q.sortColumn(), dir, dir), append(args, q.Limit+1)...)
 	if err != nil {
-		return nil, err
+		return NotePage{}, err
 	}
 	defer rows.Close()
 	var res []Note
 	for rows.Next() {
-		var n Note
-		if err := rows.Scan(&n.ID, &n.Owner, &n.Content, &n.Nonce, &n.Created, &n.Modified); err != nil {
-			return nil, err
+		n, err := scanNote(rows)
+		if err != nil {
+			return NotePage{}, err
 		}
 		res = append(res, n)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return NotePage{}, err
 	}
-	if res == nil {
-		// return an empty slice instead of nil so JSON encoding yields []
-		res = []Note{}
+	rows.Close()
+	if err := s.loadTags(res); err != nil {
+		return NotePage{}, err
 	}
-	return res, nil
+	return q.page(res, total), nil
 }
 
-func GetNoteByID(id string) (Note, error) {
-	if db == nil {
-		return Note{}, errors.New("db not initialized")
-	}
+func (s *sqlStore) GetNoteByID(id string) (Note, error) {
 	if !isValidUUID(id) {
 		return Note{}, errors.New("invalid note id format")
 	}
-	var n Note
-	row := db.QueryRow(`SELECT id, owner, content, nonce, created, modified FROM notes WHERE id =?`, id)
-	if err := row.Scan(&n.ID, &n.Owner, &n.Content, &n.Nonce, &n.Created, &n.Modified); err != nil {
+	n, err := scanNote(s.queryRow(`SELECT `+noteColumns+` FROM notes WHERE id = ?`, id))
+	if err != nil {
+		return Note{}, notFound(err)
+	}
+	notes := []Note{n}
+	if err := s.loadTags(notes); err != nil {
 		return Note{}, err
 	}
-	return n, nil
+	return notes[0], nil
+}
+
+// noteColumns are the columns scanNote reads, tags aside.
+const noteColumns = `id, owner, content, nonce, created, modified, revision, COALESCE(deleted_at, 0), title, title_nonce, COALESCE(notebook_id, ''), note_key, note_key_nonce`
+
+func scanNote(row interface{ Scan(...any) error }) (Note, error) {
+	var n Note
+	err := row.Scan(&n.ID, &n.Owner, &n.Content, &n.Nonce, &n.Created, &n.Modified, &n.Revision, &n.Deleted, &n.Title, &n.TitleNonce, &n.Notebook, &n.Key, &n.KeyNonce)
+	return n, err
 }
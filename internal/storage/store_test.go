@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// The store tests run against every Store through forEachStore, so that
+// the memory store keeps behaving like the SQL ones. PostgreSQL is only
+// tested when SCRYPTS_TEST_POSTGRES_DSN names a database to use; the tests
+// make users of their own and leave the rest of it alone.
+
+// forEachStore runs test as a subtest for each store, migrated up.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := OpenSQLite(filepath.Join(t.TempDir(), "scrypts.db"), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		if _, err := s.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("SCRYPTS_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("SCRYPTS_TEST_POSTGRES_DSN not set")
+		}
+		s, err := OpenPostgres(dsn, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		if _, err := s.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+}
+
+// newUser creates a user with a random name, so that runs against the same
+// PostgreSQL database don't collide.
+func newUser(t *testing.T, s Store) string {
+	t.Helper()
+	b := make([]byte, 8)
+	rand.Read(b)
+	name := "test_" + hex.EncodeToString(b)
+	if err := s.CreateUser(User{Username: name, PasswordHash: "x", CreatedAt: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// newNote saves a note of owner created at created.
+func newNote(t *testing.T, s Store, owner string, created int64) Note {
+	t.Helper()
+	n := Note{
+		ID:       uuid.New().String(),
+		Owner:    owner,
+		Content:  []byte("content"),
+		Nonce:    []byte("nonce"),
+		Created:  created,
+		Modified: created,
+	}
+	if err := s.SaveNote(n); err != nil {
+		t.Fatal(err)
+	}
+	n.Revision = 1
+	return n
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		name := newUser(t, s)
+		u, err := s.GetUser(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Username != name || u.Role != RoleUser || u.Disabled {
+			t.Errorf("GetUser = %+v", u)
+		}
+		if err := s.CreateUser(User{Username: name}); err == nil {
+			t.Error("CreateUser accepted a taken username")
+		}
+		if _, err := s.GetUser("test_missing"); err != ErrNotFound {
+			t.Errorf("GetUser of a missing user: %v, want ErrNotFound", err)
+		}
+		if err := s.SetPasswordHash("test_missing", "x"); err != ErrNotFound {
+			t.Errorf("SetPasswordHash of a missing user: %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package storage
+
+import "database/sql"
+
+// TrashNote moves a note to the trash.
+func (s *sqlStore) TrashNote(id, owner string, revision, now int64) error {
+	query := `UPDATE notes SET deleted_at = ? WHERE id = ? AND owner = ? AND deleted_at IS NULL`
+	args := []any{now, id, owner}
+	if revision != 0 {
+		query += ` AND revision = ?`
+		args = append(args, revision)
+	}
+	if err := s.execOne(query, args...); err != ErrNotFound {
+		return err
+	}
+	return s.noteConflict(s.db, id, owner)
+}
+
+// RestoreNote takes a note out of the trash.
+func (s *sqlStore) RestoreNote(id, owner string) error {
+	return s.execOne(`UPDATE notes SET deleted_at = NULL WHERE id = ? AND owner = ? AND deleted_at IS NOT NULL`, id, owner)
+}
+
+// DeleteNote deletes a note in the trash for good, together with its
+// earlier revisions, tags, search terms and attachments.
+func (s *sqlStore) DeleteNote(id, owner string) error {
+	n, err := s.deleteTrashed(`id = ? AND owner = ?`, id, owner)
+	if err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// EmptyTrash deletes every note in the owner's trash for good and returns
+// how many there were.
+func (s *sqlStore) EmptyTrash(owner string) (int64, error) {
+	return s.deleteTrashed(`owner = ?`, owner)
+}
+
+// PurgeTrash deletes the notes moved to the trash before the given time,
+// whoever they belong to, and returns how many there were.
+func (s *sqlStore) PurgeTrash(before int64) (int64, error) {
+	return s.deleteTrashed(`deleted_at < ?`, before)
+}
+
+// deleteTrashed deletes the notes in the trash that match cond, with their
+// revisions, tags, search terms, attachments, shares and links.
+func (s *sqlStore) deleteTrashed(cond string, args ...any) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	where := ` WHERE deleted_at IS NOT NULL AND ` + cond
+	if err := s.releaseBlobs(tx, `note_id IN (SELECT id FROM notes`+where+`)`, args...); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"note_revisions", "note_tags", "note_terms", "attachments", "note_shares", "note_links"} {
+		if _, err := tx.Exec(s.q(`DELETE FROM `+table+` WHERE note_id IN (SELECT id FROM notes`+where+`)`), args...); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.deleteFTS(tx, `note_id IN (SELECT id FROM notes`+where+`)`, args...); err != nil {
+		return 0, err
+	}
+	var res sql.Result
+	if res, err = tx.Exec(s.q(`DELETE FROM notes`+where), args...); err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
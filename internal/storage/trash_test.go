@@ -0,0 +1,63 @@
+package storage
+
+import "testing"
+
+func TestStoreTrash(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := newUser(t, s)
+		n := newNote(t, s, owner, 1000)
+
+		if err := s.TrashNote(n.ID, owner, 5, 2000); err != ErrConflict {
+			t.Errorf("TrashNote at a wrong revision: %v, want ErrConflict", err)
+		}
+		if err := s.TrashNote(n.ID, owner, 1, 2000); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.TrashNote(n.ID, owner, 0, 2000); err != ErrNotFound {
+			t.Errorf("TrashNote of a trashed note: %v, want ErrNotFound", err)
+		}
+		if _, err := s.UpdateNote(n); err != ErrNotFound {
+			t.Errorf("UpdateNote of a trashed note: %v, want ErrNotFound", err)
+		}
+		if p, err := s.ListNotes(NoteQuery{Owner: owner, Limit: 10}); err != nil || len(p.Notes) != 0 {
+			t.Errorf("ListNotes listed %d notes in the trash (%v)", len(p.Notes), err)
+		}
+		p, err := s.ListNotes(NoteQuery{Owner: owner, Limit: 10, Trashed: true, SortBy: SortDeleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Notes) != 1 || p.Notes[0].Deleted != 2000 {
+			t.Errorf("ListNotes of the trash = %+v", p.Notes)
+		}
+
+		if err := s.RestoreNote(n.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteNote(n.ID, owner); err != ErrNotFound {
+			t.Errorf("DeleteNote of a note not in the trash: %v, want ErrNotFound", err)
+		}
+		if err := s.TrashNote(n.ID, owner, 0, 3000); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteNote(n.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetNoteByID(n.ID); err != ErrNotFound {
+			t.Errorf("GetNoteByID of a deleted note: %v, want ErrNotFound", err)
+		}
+
+		old := newNote(t, s, owner, 1000)
+		recent := newNote(t, s, owner, 1000)
+		s.TrashNote(old.ID, owner, 0, 2000)
+		s.TrashNote(recent.ID, owner, 0, 4000)
+		if n, err := s.PurgeTrash(3000); err != nil || n < 1 {
+			t.Errorf("PurgeTrash = %d, %v", n, err)
+		}
+		if _, err := s.GetNoteByID(old.ID); err != ErrNotFound {
+			t.Errorf("GetNoteByID of a purged note: %v, want ErrNotFound", err)
+		}
+		if _, err := s.GetNoteByID(recent.ID); err != nil {
+			t.Errorf("GetNoteByID of a note trashed after the purge: %v", err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"scrypts/internal/config"
+	"strings"
+)
+
+// ClientIP returns the address of the client that sent r. Requests from
+// one of config.TrustedProxies are attributed to the last address in
+// X-Forwarded-For that isn't a trusted proxy too, since entries to its left
+// may have been made up by the client; other requests to the address they
+// came from, whatever their headers say.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// a proxy we trust wouldn't have added it
+			break
+		}
+		host = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether addr is one of config.TrustedProxies.
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range config.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
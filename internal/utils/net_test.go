@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net"
+	"net/http/httptest"
+	"scrypts/internal/config"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	config.TrustedProxies = []*net.IPNet{proxies}
+	defer func() { config.TrustedProxies = nil }()
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"forwarded by an untrusted client", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"through a proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"made-up entries to the left", "10.0.0.2:4000", []string{"192.0.2.9, 198.51.100.1"}, "198.51.100.1"},
+		{"through two proxies", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"over several headers", "10.0.0.2:4000", []string{"192.0.2.9", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage from the client", "10.0.0.2:4000", []string{"nonsense, 198.51.100.1"}, "198.51.100.1"},
+		{"garbage from the proxy", "10.0.0.2:4000", []string{"nonsense"}, "10.0.0.2"},
+		{"proxy without the header", "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"IPv6", "[2001:db8::1]:4000", []string{"198.51.100.1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// Keys are shared between users by sealing them to the recipient's X25519
+// public key: an ephemeral keypair is made for each sealed key, the key is
+// encrypted with AES-GCM under a key derived with HKDF-SHA256 from the
+// shared secret and both public keys, and the ephemeral public key is sent
+// along. As the derived key is used once, the nonce is zero.
+
+// ErrSealedKey is returned by OpenKey for a sealed key that isn't
+// well-formed or wasn't sealed to the given keypair.
+var ErrSealedKey = errors.New("sealed key doesn't open")
+
+// GenerateKeyPair returns a new X25519 keypair.
+func GenerateKeyPair() (public, private []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.PublicKey().Bytes(), priv.Bytes(), nil
+}
+
+// SealKey seals key to the X25519 public key recipient.
+func SealKey(recipient, key []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	ephPub := eph.PublicKey().Bytes()
+	aead, err := sealAEAD(secret, ephPub, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(ephPub, make([]byte, aead.NonceSize()), key, nil), nil
+}
+
+// OpenKey opens a key sealed to the X25519 keypair whose private key is
+// given.
+func OpenKey(private, sealed []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 32 {
+		return nil, ErrSealedKey
+	}
+	ephPub, ct := sealed[:32], sealed[32:]
+	eph, err := ecdh.X25519().NewPublicKey(ephPub)
+	if err != nil {
+		return nil, ErrSealedKey
+	}
+	secret, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, ErrSealedKey
+	}
+	aead, err := sealAEAD(secret, ephPub, priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), ct, nil)
+	if err != nil {
+		return nil, ErrSealedKey
+	}
+	return key, nil
+}
+
+// sealAEAD derives the cipher of a sealed key from the shared secret of
+// the exchange and both public keys.
+func sealAEAD(secret, ephPub, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephPub...), recipient...)
+	k, err := hkdf.Key(sha256.New, secret, salt, "scrypts sealed key v1", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamChunkSize is the plaintext size of each chunk of an encrypted stream.
+const StreamChunkSize = 64 << 10
+
+// StreamPrefixSize is the size of the random nonce prefix of a stream.
+const StreamPrefixSize = 7
+
+// ErrStreamTruncated is returned when an encrypted stream ends before its
+// final chunk.
+var ErrStreamTruncated = errors.New("encrypted stream is truncated")
+
+// StreamCipher encrypts data too large to hold in memory using the STREAM
+// construction (Hoang, Reyhanitabar, Rogaway and Vizár) over AES-GCM. The
+// plaintext is cut into StreamChunkSize chunks sealed separately; chunk i of
+// a stream uses the nonce prefix || i || last, so chunks can't be reordered,
+// dropped or spliced between streams, and the stream can't be truncated.
+// Each chunk can be decrypted on its own, which allows random access.
+type StreamCipher struct {
+	aead   cipher.AEAD
+	prefix [StreamPrefixSize]byte
+}
+
+// NewStreamCipher returns a cipher for one stream. prefix must be
+// StreamPrefixSize bytes and never reused with the same key.
+func NewStreamCipher(key, prefix []byte) (*StreamCipher, error) {
+	if len(prefix) != StreamPrefixSize {
+		return nil, fmt.Errorf("stream prefix must be %d bytes", StreamPrefixSize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &StreamCipher{aead: aead}
+	copy(c.prefix[:], prefix)
+	return c, nil
+}
+
+func (c *StreamCipher) nonce(i uint32, last bool) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, c.prefix[:])
+	binary.BigEndian.PutUint32(nonce[StreamPrefixSize:], i)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// SealChunk appends the encryption of chunk i to dst.
+func (c *StreamCipher) SealChunk(dst, plaintext []byte, i uint32, last bool, ad []byte) []byte {
+	return c.aead.Seal(dst, c.nonce(i, last), plaintext, ad)
+}
+
+// OpenChunk appends the decryption of chunk i to dst.
+func (c *StreamCipher) OpenChunk(dst, ciphertext []byte, i uint32, last bool, ad []byte) ([]byte, error) {
+	return c.aead.Open(dst, c.nonce(i, last), ciphertext, ad)
+}
+
+// Overhead is the number of bytes each chunk grows by when sealed.
+func (c *StreamCipher) Overhead() int {
+	return c.aead.Overhead()
+}
+
+// EncryptedStreamSize returns the ciphertext size of an n byte plaintext,
+// excluding the prefix.
+func (c *StreamCipher) EncryptedStreamSize(n int64) int64 {
+	// the final chunk is always written, even when empty
+	chunks := n/StreamChunkSize + 1
+	return n + chunks*int64(c.Overhead())
+}
+
+type streamWriter struct {
+	w       io.Writer
+	c       *StreamCipher
+	ad      []byte
+	buf     []byte
+	counter uint32
+	out     []byte
+	closed  bool
+}
+
+// NewStreamWriter returns a writer that encrypts to w. Close must be called
+// to write the final chunk; it does not close w.
+func NewStreamWriter(w io.Writer, c *StreamCipher, ad []byte) io.WriteCloser {
+	return &streamWriter{w: w, c: c, ad: ad, buf: make([]byte, 0, StreamChunkSize)}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is only flushed once more data arrives, since it might
+		// turn out to be the last one
+		if len(sw.buf) == StreamChunkSize {
+			if err := sw.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):StreamChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (sw *streamWriter) flush(last bool) error {
+	if sw.counter == ^uint32(0) {
+		return errors.New("stream too long")
+	}
+	sw.out = sw.c.SealChunk(sw.out[:0], sw.buf, sw.counter, last, sw.ad)
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	_, err := sw.w.Write(sw.out)
+	return err
+}
+
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	// the reader takes a full chunk to mean more follow, so a full buffer is
+	// followed by an empty final chunk
+	if len(sw.buf) == StreamChunkSize {
+		if err := sw.flush(false); err != nil {
+			return err
+		}
+	}
+	return sw.flush(true)
+}
+
+type streamReader struct {
+	r       io.Reader
+	c       *StreamCipher
+	ad      []byte
+	in      []byte
+	buf     []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+// NewStreamReader returns a reader that decrypts the stream read from r. It
+// fails with an error if any chunk doesn't authenticate or the stream is
+// truncated, so data read before an error must not be trusted as complete.
+func NewStreamReader(r io.Reader, c *StreamCipher, ad []byte) io.Reader {
+	return &streamReader{r: r, c: c, ad: ad, in: make([]byte, StreamChunkSize+c.Overhead())}
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.next()
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *streamReader) next() {
+	n, err := io.ReadFull(sr.r, sr.in)
+	last := false
+	switch err {
+	case nil:
+		// a full chunk is never the final one
+	case io.ErrUnexpectedEOF, io.EOF:
+		last = true
+	default:
+		sr.err = err
+		return
+	}
+	pt, err := sr.c.OpenChunk(sr.buf[:0], sr.in[:n], sr.counter, last, sr.ad)
+	if err != nil {
+		if n == 0 {
+			sr.err = ErrStreamTruncated
+		} else {
+			sr.err = fmt.Errorf("chunk %d: %w", sr.counter, err)
+		}
+		return
+	}
+	sr.counter++
+	sr.buf = pt
+	sr.done = last
+}
+
+// PlaintextSize returns the plaintext size of an n byte stream, excluding
+// the prefix: the inverse of EncryptedStreamSize. It fails when no stream
+// has that size.
+func (c *StreamCipher) PlaintextSize(n int64) (int64, error) {
+	sealed := int64(StreamChunkSize + c.Overhead())
+	// every chunk but the final one is full, and the final one is shorter
+	chunks := n/sealed + 1
+	size := n - chunks*int64(c.Overhead())
+	if size < 0 || n%sealed < int64(c.Overhead()) {
+		return 0, ErrStreamTruncated
+	}
+	return size, nil
+}
+
+// StreamReaderAt decrypts any part of an encrypted stream, reading only the
+// chunks it needs. Like NewStreamReader it fails on any chunk that doesn't
+// authenticate, including a final chunk that isn't the last one written.
+type StreamReaderAt struct {
+	r      io.ReaderAt
+	c      *StreamCipher
+	ad     []byte
+	size   int64 // of the plaintext
+	chunks int64
+
+	mu     sync.Mutex
+	in     []byte
+	buf    []byte // plaintext of chunk cached
+	cached int64
+}
+
+// NewStreamReaderAt returns a reader of the stream of size bytes, excluding
+// the prefix, read from r.
+func NewStreamReaderAt(r io.ReaderAt, size int64, c *StreamCipher, ad []byte) (*StreamReaderAt, error) {
+	plain, err := c.PlaintextSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReaderAt{
+		r: r, c: c, ad: ad, size: plain,
+		chunks: plain/StreamChunkSize + 1,
+		in:     make([]byte, StreamChunkSize+c.Overhead()),
+		cached: -1,
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (sr *StreamReaderAt) Size() int64 {
+	return sr.size
+}
+
+// ReadAt reads the plaintext at off.
+func (sr *StreamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	n := 0
+	for n < len(p) && off < sr.size {
+		i := off / StreamChunkSize
+		if err := sr.load(i); err != nil {
+			return n, err
+		}
+		m := copy(p[n:], sr.buf[off-i*StreamChunkSize:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// load decrypts chunk i into buf unless it is there already.
+func (sr *StreamReaderAt) load(i int64) error {
+	if i == sr.cached {
+		return nil
+	}
+	sealed := int64(StreamChunkSize + sr.c.Overhead())
+	in := sr.in
+	last := i == sr.chunks-1
+	if last {
+		in = in[:sr.size-i*StreamChunkSize+int64(sr.c.Overhead())]
+	}
+	// a ReaderAt may report EOF along with the final bytes
+	if n, err := sr.r.ReadAt(in, i*sealed); n < len(in) {
+		if err == io.EOF {
+			err = ErrStreamTruncated
+		}
+		return err
+	}
+	pt, err := sr.c.OpenChunk(sr.buf[:0], in, uint32(i), last, sr.ad)
+	if err != nil {
+		sr.cached = -1
+		return fmt.Errorf("chunk %d: %w", i, err)
+	}
+	sr.buf, sr.cached = pt, i
+	return nil
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+)
+
+// testStream returns a cipher under a random key and prefix.
+func testStream(t *testing.T) *StreamCipher {
+	key := make([]byte, 32)
+	prefix := make([]byte, StreamPrefixSize)
+	rand.Read(key)
+	rand.Read(prefix)
+	c, err := NewStreamCipher(key, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// seal encrypts plaintext, written in pieces of odd sizes.
+func seal(t *testing.T, c *StreamCipher, plaintext, ad []byte) []byte {
+	var buf bytes.Buffer
+	w := NewStreamWriter(&buf, c, ad)
+	for p, n := plaintext, 1; len(p) > 0; n = n*3 + 1 {
+		n = min(n, len(p))
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	c := testStream(t)
+	ad := []byte("attachment-id")
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 2 * StreamChunkSize, 3*StreamChunkSize + 17} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		sealed := seal(t, c, plaintext, ad)
+		if int64(len(sealed)) != c.EncryptedStreamSize(int64(size)) {
+			t.Errorf("%d bytes: sealed to %d, EncryptedStreamSize says %d", size, len(sealed), c.EncryptedStreamSize(int64(size)))
+		}
+		if n, err := c.PlaintextSize(int64(len(sealed))); err != nil || n != int64(size) {
+			t.Errorf("%d bytes: PlaintextSize = %d, %v", size, n, err)
+		}
+
+		got, err := io.ReadAll(NewStreamReader(bytes.NewReader(sealed), c, ad))
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: read back %d bytes, %v", size, len(got), err)
+		}
+		r, err := NewStreamReaderAt(bytes.NewReader(sealed), int64(len(sealed)), c, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes: read back %d bytes at offsets, %v", size, len(got), err)
+		}
+
+		if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(sealed), c, []byte("other-id"))); err == nil {
+			t.Errorf("%d bytes: read back under other associated data", size)
+		}
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	c := testStream(t)
+	chunk := StreamChunkSize + c.Overhead()
+	for _, size := range []int{2*StreamChunkSize + 5, 2 * StreamChunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		sealed := seal(t, c, plaintext, nil)
+		// cut after whole chunks, so that every chunk left authenticates
+		for _, n := range []int{0, chunk, 2 * chunk} {
+			cut := sealed[:n]
+			if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(cut), c, nil)); !errors.Is(err, ErrStreamTruncated) {
+				t.Errorf("%d bytes cut to %d: read %v, want ErrStreamTruncated", size, n, err)
+			}
+			if _, err := NewStreamReaderAt(bytes.NewReader(cut), int64(n), c, nil); !errors.Is(err, ErrStreamTruncated) {
+				t.Errorf("%d bytes cut to %d: NewStreamReaderAt %v, want ErrStreamTruncated", size, n, err)
+			}
+		}
+		if size%StreamChunkSize == 0 {
+			continue
+		}
+		// a reader told the full size of a stream that is then cut short
+		r, err := NewStreamReaderAt(bytes.NewReader(sealed[:2*chunk]), int64(len(sealed)), c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadAt(make([]byte, 1), int64(size-1)); !errors.Is(err, ErrStreamTruncated) {
+			t.Errorf("%d bytes: ReadAt past the cut %v, want ErrStreamTruncated", size, err)
+		}
+	}
+
+	// dropping a chunk from the middle is caught by its number
+	plaintext := make([]byte, 3*StreamChunkSize)
+	sealed := seal(t, c, plaintext, nil)
+	spliced := append(append([]byte{}, sealed[:chunk]...), sealed[2*chunk:]...)
+	if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(spliced), c, nil)); err == nil {
+		t.Error("read a stream with a chunk dropped")
+	}
+	sealed[chunk+10] ^= 1
+	if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(sealed), c, nil)); err == nil {
+		t.Error("read a tampered stream")
+	}
+}
+
+func TestStreamReaderAt(t *testing.T) {
+	c := testStream(t)
+	plaintext := make([]byte, 4*StreamChunkSize+123)
+	rand.Read(plaintext)
+	sealed := seal(t, c, plaintext, nil)
+	r, err := NewStreamReaderAt(bytes.NewReader(sealed), int64(len(sealed)), c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(plaintext)) {
+		t.Fatalf("Size = %d, want %d", r.Size(), len(plaintext))
+	}
+
+	rnd := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		off := rnd.Int63n(r.Size() + 1)
+		p := make([]byte, rnd.Intn(3*StreamChunkSize))
+		n, err := r.ReadAt(p, off)
+		want := plaintext[off:min(off+int64(len(p)), r.Size())]
+		if n != len(want) || !bytes.Equal(p[:n], want) {
+			t.Fatalf("ReadAt(%d bytes, %d) = %d bytes, want %d", len(p), off, n, len(want))
+		}
+		if n < len(p) && err != io.EOF {
+			t.Fatalf("short ReadAt(%d bytes, %d): %v, want EOF", len(p), off, err)
+		}
+		if n == len(p) && err != nil {
+			t.Fatalf("ReadAt(%d bytes, %d): %v", len(p), off, err)
+		}
+	}
+
+	// a tampered chunk fails only the reads that need it
+	sealed[StreamChunkSize+c.Overhead()+5] ^= 1
+	r, _ = NewStreamReaderAt(bytes.NewReader(sealed), int64(len(sealed)), c, nil)
+	if _, err := r.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Errorf("ReadAt of an intact chunk: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), StreamChunkSize+1); err == nil {
+		t.Error("ReadAt of a tampered chunk succeeded")
+	}
+}